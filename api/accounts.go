@@ -0,0 +1,437 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/pcarleton/cashcoach/api/auth"
+	"github.com/pcarleton/cashcoach/api/plaid"
+	"github.com/pcarleton/cashcoach/api/storage"
+)
+
+// AccountView is what the browser sees of a storage.Account.  Accounts
+// are addressed by ID; the access token stays on the server.
+type AccountView struct {
+	ID            string     `json:"id"`
+	Name          string     `json:"name"`
+	InstitutionID string     `json:"institution_id"`
+	Masks         []string   `json:"masks"`
+	LastSync      *time.Time `json:"last_sync,omitempty"`
+	SyncError     string     `json:"sync_error,omitempty"`
+
+	// SyncErrorClass is the plaid.ErrorClass of SyncError, so the UI
+	// knows when to offer Link's update mode.
+	SyncErrorClass string `json:"sync_error_class,omitempty"`
+}
+
+type PersonView struct {
+	Email    string        `json:"email"`
+	Accounts []AccountView `json:"accounts"`
+}
+
+func newAccountView(acct storage.Account) AccountView {
+	view := AccountView{
+		ID:             acct.ID,
+		Name:           acct.Name,
+		InstitutionID:  acct.InstitutionID,
+		Masks:          acct.Masks,
+		SyncError:      acct.SyncError,
+		SyncErrorClass: acct.SyncErrorClass,
+	}
+
+	if view.Masks == nil {
+		view.Masks = []string{}
+	}
+
+	if !acct.LastSync.IsZero() {
+		lastSync := acct.LastSync
+		view.LastSync = &lastSync
+	}
+
+	return view
+}
+
+func newPersonView(person *storage.Person) PersonView {
+	accts := make([]AccountView, len(person.Accounts))
+	for i, acct := range person.Accounts {
+		accts[i] = newAccountView(acct)
+	}
+	return PersonView{person.Email, accts}
+}
+
+// loadPerson gets the person for profile, giving IDs to any accounts that
+// were saved before accounts had them.
+func loadPerson(profile *auth.Profile) (*storage.Person, *appError) {
+	person, err := config.Get(profile.Email)
+
+	if err != nil {
+		return nil, appErrorf(err, "couldn't find %s", profile.Email)
+	}
+
+	changed, err := person.EnsureAccountIDs()
+
+	if err != nil {
+		return nil, appErrorf(err, "problem creating account IDs")
+	}
+
+	if changed {
+		if err := config.Update(person); err != nil {
+			return nil, appErrorf(err, "problem saving account IDs")
+		}
+	}
+
+	return person, nil
+}
+
+// backfillItems looks up the Plaid item behind accounts linked before
+// item IDs were saved, which syncing and webhooks need.  It returns true if
+// any were filled in, meaning the person should be saved.  Accounts Plaid
+// can't tell us about right now are left for the next try.
+func backfillItems(ctx context.Context, client plaid.Client, p *storage.Person) bool {
+	changed := false
+	for i := range p.Accounts {
+		acct := &p.Accounts[i]
+		if acct.ItemID != "" || acct.Token == "" {
+			continue
+		}
+
+		resp, err := client.Item(ctx, acct.Token)
+		if err != nil {
+			log.Printf("Unable to look up the item for %s of %s: %v", acct.Name, p.Email, err)
+			continue
+		}
+
+		acct.ItemID = resp.Item.ItemID
+		if acct.InstitutionID == "" {
+			acct.InstitutionID = resp.Item.InstitutionID
+		}
+		changed = true
+	}
+	return changed
+}
+
+// backfillAllItems runs backfillItems for everyone, and returns how many
+// people were updated.
+func backfillAllItems(ctx context.Context, store storage.Storage, client plaid.Client) (int, error) {
+	people, err := store.All()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, p := range people {
+		if !backfillItems(ctx, client, p) {
+			continue
+		}
+
+		if err := saveItems(store, p); err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	return count, nil
+}
+
+// saveItems stores the item IDs backfillItems filled in on p.  The person
+// is re-read just before writing, and only empty items are filled in, so
+// changes saved while Plaid was being asked, like a sync's cursor, are
+// kept.
+func saveItems(store storage.Storage, p *storage.Person) error {
+	fresh, err := store.Get(p.Email)
+	if err != nil {
+		return err
+	}
+
+	for _, acct := range p.Accounts {
+		if f := fresh.AccountByID(acct.ID); f != nil && f.ItemID == "" {
+			f.ItemID = acct.ItemID
+			f.InstitutionID = acct.InstitutionID
+		}
+	}
+
+	return store.Update(fresh)
+}
+
+func accountNotFound(id string) *appError {
+	return &appError{nil, fmt.Sprintf("no account with id %s", id), http.StatusNotFound}
+}
+
+func accountsHandler(profile *auth.Profile, w http.ResponseWriter, r *http.Request) *appError {
+	person, appErr := loadPerson(profile)
+
+	if appErr != nil {
+		return appErr
+	}
+
+	return respondJson(w, newPersonView(person))
+}
+
+type AddAccountRequest struct {
+	Name        string `json:"name"`
+	PublicToken string `json:"public_token"`
+}
+
+func addAccount(profile *auth.Profile, w http.ResponseWriter, r *http.Request) *appError {
+	person, appErr := loadPerson(profile)
+
+	if appErr != nil {
+		return appErr
+	}
+
+	req := AddAccountRequest{}
+	err := unmarshal(&req, r)
+
+	if err != nil {
+		return appErrorf(err, "bad request")
+	}
+
+	resp, err := config.Plaid.Exchange(r.Context(), req.PublicToken)
+
+	if err != nil {
+		return appErrorf(err, "problem exchanging public token")
+	}
+
+	id, err := storage.NewAccountID()
+
+	if err != nil {
+		return appErrorf(err, "problem creating account ID")
+	}
+
+	acct := storage.Account{ID: id, Name: req.Name, Token: resp.AccessToken, ItemID: resp.ItemID}
+
+	// The masks and institution are only for display, so a failure here
+	// shouldn't lose the newly linked item.
+	accounts, err := config.Plaid.Accounts(r.Context(), resp.AccessToken)
+	if err != nil {
+		log.Printf("Unable to load account details for item %s: %v", resp.ItemID, err)
+	} else {
+		acct.InstitutionID = accounts.Item.InstitutionID
+		for _, a := range accounts.Accounts {
+			acct.Masks = append(acct.Masks, a.Mask)
+		}
+	}
+
+	person.Accounts = append(person.Accounts, acct)
+
+	err = config.Update(person)
+
+	if err != nil {
+		return appErrorf(err, "problem saving")
+	}
+
+	syncNew(person.Email, []storage.Account{acct})
+
+	return respondJson(w, newAccountView(acct))
+}
+
+type AccountRequest struct {
+	ID string `json:"id"`
+}
+
+type UpdateAccountResponse struct {
+	PublicToken string `json:"public_token"`
+}
+
+// updateAccount returns a public token for Plaid Link's update mode, which
+// lets the user repair a bank login without linking a new item.
+func updateAccount(profile *auth.Profile, w http.ResponseWriter, r *http.Request) *appError {
+	person, appErr := loadPerson(profile)
+
+	if appErr != nil {
+		return appErr
+	}
+
+	req := AccountRequest{}
+	err := unmarshal(&req, r)
+
+	if err != nil {
+		return appErrorf(err, "bad request")
+	}
+
+	acct := person.AccountByID(req.ID)
+
+	if acct == nil {
+		return accountNotFound(req.ID)
+	}
+
+	resp, err := config.Plaid.CreatePublicToken(r.Context(), acct.Token)
+
+	if err != nil {
+		return appErrorf(err, "problem creating public token")
+	}
+
+	return respondJson(w, UpdateAccountResponse{resp.PublicToken})
+}
+
+type RenameAccountRequest struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+func renameAccount(profile *auth.Profile, w http.ResponseWriter, r *http.Request) *appError {
+	person, appErr := loadPerson(profile)
+
+	if appErr != nil {
+		return appErr
+	}
+
+	req := RenameAccountRequest{}
+	err := unmarshal(&req, r)
+
+	if err != nil {
+		return appErrorf(err, "bad request")
+	}
+
+	if req.Name == "" {
+		return &appError{nil, "name must not be empty", http.StatusBadRequest}
+	}
+
+	acct := person.AccountByID(req.ID)
+
+	if acct == nil {
+		return accountNotFound(req.ID)
+	}
+
+	acct.Name = req.Name
+
+	err = config.Update(person)
+
+	if err != nil {
+		return appErrorf(err, "problem saving")
+	}
+
+	return respondJson(w, newAccountView(*acct))
+}
+
+// deleteAccount forgets the account and its transactions.  If no other
+// account uses its Plaid item, the item is removed from Plaid too.  The
+// person is saved first, so a failed save never leaves an account whose
+// item is already gone; if removing the item then fails, the account is
+// put back so deleting it can be tried again.
+func deleteAccount(profile *auth.Profile, w http.ResponseWriter, r *http.Request) *appError {
+	person, appErr := loadPerson(profile)
+
+	if appErr != nil {
+		return appErr
+	}
+
+	req := AccountRequest{}
+	err := unmarshal(&req, r)
+
+	if err != nil {
+		return appErrorf(err, "bad request")
+	}
+
+	found := person.AccountByID(req.ID)
+
+	if found == nil {
+		return accountNotFound(req.ID)
+	}
+	deleted := *found
+
+	kept := make([]storage.Account, 0, len(person.Accounts))
+	shared := false
+	for _, acct := range person.Accounts {
+		if acct.ID == req.ID {
+			continue
+		}
+		kept = append(kept, acct)
+		shared = shared || sameItem(acct, deleted)
+	}
+
+	person.Accounts = kept
+
+	err = config.Update(person)
+
+	if err != nil {
+		return appErrorf(err, "problem saving")
+	}
+
+	// Plaid keeps billing for an item, and sending its webhooks, until
+	// it's removed.  A token Plaid no longer knows is already gone.
+	if !shared {
+		err := config.Plaid.RemoveItem(r.Context(), deleted.Token)
+
+		if err != nil && plaid.ErrorClassOf(err) != plaid.ClassInvalidInput {
+			if restoreErr := restoreAccount(person.Email, deleted); restoreErr != nil {
+				log.Printf("Unable to restore account %s for %s: %v", deleted.ID, person.Email, restoreErr)
+			}
+			return &appError{err, "problem removing the account from Plaid", http.StatusBadGateway}
+		}
+	}
+
+	err = config.Transactions.RemoveAccountTransactions(person.Email, req.ID)
+
+	if err != nil {
+		return appErrorf(err, "problem removing transactions")
+	}
+
+	return respondJson(w, newPersonView(person))
+}
+
+// restoreAccount adds acct back to the person after a delete that
+// couldn't be finished.
+func restoreAccount(email string, acct storage.Account) error {
+	person, err := config.Get(email)
+	if err != nil {
+		return err
+	}
+
+	if person.AccountByID(acct.ID) == nil {
+		person.Accounts = append(person.Accounts, acct)
+	}
+	return config.Update(person)
+}
+
+// sameItem reports whether a and b are accounts of the same Plaid item.
+func sameItem(a, b storage.Account) bool {
+	if a.ItemID != "" && b.ItemID != "" {
+		return a.ItemID == b.ItemID
+	}
+	return a.Token != "" && a.Token == b.Token
+}
+
+// AccountBalances are the balances of one linked account's Plaid
+// accounts.  If they couldn't be fetched, Accounts is empty and Error and
+// Class say why, like they do in an AccountError.
+type AccountBalances struct {
+	Account  AccountView     `json:"account"`
+	Accounts []plaid.Account `json:"accounts"`
+	Error    string          `json:"error,omitempty"`
+	Class    string          `json:"class,omitempty"`
+}
+
+// balancesHandler fetches every account's balances.  An account that
+// fails doesn't fail the rest; it comes back with its error instead.
+func balancesHandler(profile *auth.Profile, w http.ResponseWriter, r *http.Request) *appError {
+	person, appErr := loadPerson(profile)
+
+	if appErr != nil {
+		return appErr
+	}
+
+	balances := make([]AccountBalances, 0, len(person.Accounts))
+
+	for _, acct := range person.Accounts {
+		resp, err := config.Plaid.Balances(r.Context(), acct.Token, nil)
+
+		if err != nil {
+			log.Printf("Error getting balances for %s: %v", acct.Name, err)
+			balances = append(balances, AccountBalances{
+				Account:  newAccountView(acct),
+				Accounts: []plaid.Account{},
+				Error:    err.Error(),
+				Class:    plaid.ErrorClassOf(err).String(),
+			})
+			continue
+		}
+
+		balances = append(balances, AccountBalances{Account: newAccountView(acct), Accounts: resp.Accounts})
+	}
+
+	return respondJson(w, balances)
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pcarleton/cashcoach/api/auth"
+	"github.com/pcarleton/cashcoach/api/plaid"
+	"github.com/pcarleton/cashcoach/api/plaid/plaidtest"
+	"github.com/pcarleton/cashcoach/api/storage"
+)
+
+// setupAccounts points the handlers' globals at a store for someone with
+// the accounts, and a plaidtest server with the fixtures.  Callers must
+// Close the server.
+func setupAccounts(t *testing.T, accounts ...storage.Account) (*auth.Profile, *httptest.Server) {
+	t.Helper()
+	fixtures, err := plaidtest.LoadFixtures("plaid/plaidtest/testdata/fixtures.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := plaidtest.Start(fixtures)
+
+	client := plaid.NewClient(fixtures.ClientID, fixtures.Secret, srv.URL)
+	client.SetRetryPolicy(plaid.NoRetries)
+	client.SetRateLimit(0, 0)
+
+	profile := &auth.Profile{Email: "someone@example.com"}
+	store := storage.NewFakeStorage()
+	if _, err := store.Create(profile.Email); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Update(&storage.Person{Email: profile.Email, Accounts: accounts}); err != nil {
+		t.Fatal(err)
+	}
+
+	config = &Config{Storage: store, Transactions: store, Plaid: client}
+	return profile, srv
+}
+
+func deleteAccountID(profile *auth.Profile, id string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/api/accounts/delete", strings.NewReader(`{"id": "`+id+`"}`))
+	w := httptest.NewRecorder()
+	appHandler(handleAuthAs(profile, deleteAccount)).ServeHTTP(w, req)
+	return w
+}
+
+// handleAuthAs skips the session cookie handleAuth would read.
+func handleAuthAs(profile *auth.Profile, handler authorizedHandler) appHandler {
+	return func(w http.ResponseWriter, r *http.Request) *appError {
+		return handler(profile, w, r)
+	}
+}
+
+func itemExists(t *testing.T, token string) bool {
+	t.Helper()
+	_, err := config.Plaid.Item(context.Background(), token)
+	if err != nil && plaid.ErrorClassOf(err) != plaid.ClassInvalidInput {
+		t.Fatalf("Item: %v", err)
+	}
+	return err == nil
+}
+
+func TestDeleteAccountRemovesItem(t *testing.T) {
+	profile, srv := setupAccounts(t,
+		storage.Account{ID: "checking", Name: "checking", Token: "access-sandbox-checking", ItemID: "item-checking"},
+		storage.Account{ID: "credit", Name: "credit", Token: "access-sandbox-checking", ItemID: "item-checking"},
+	)
+	defer srv.Close()
+
+	if w := deleteAccountID(profile, "checking"); w.Code != http.StatusOK {
+		t.Fatalf("deleting checking: status %d: %s", w.Code, w.Body)
+	}
+	if !itemExists(t, "access-sandbox-checking") {
+		t.Fatal("item was removed while credit still uses it")
+	}
+
+	if w := deleteAccountID(profile, "credit"); w.Code != http.StatusOK {
+		t.Fatalf("deleting credit: status %d: %s", w.Code, w.Body)
+	}
+	if itemExists(t, "access-sandbox-checking") {
+		t.Error("item wasn't removed with its last account")
+	}
+
+	person, err := config.Get(profile.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(person.Accounts) != 0 {
+		t.Errorf("accounts left: %+v", person.Accounts)
+	}
+}
+
+func TestDeleteAccountWithoutItemID(t *testing.T) {
+	// Accounts linked before item IDs were saved share only the token.
+	profile, srv := setupAccounts(t,
+		storage.Account{ID: "checking", Name: "checking", Token: "access-sandbox-checking"},
+		storage.Account{ID: "credit", Name: "credit", Token: "access-sandbox-checking", ItemID: "item-checking"},
+	)
+	defer srv.Close()
+
+	if w := deleteAccountID(profile, "checking"); w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	if !itemExists(t, "access-sandbox-checking") {
+		t.Error("item was removed while credit still uses it")
+	}
+}
+
+func TestDeleteAccountUnknownToken(t *testing.T) {
+	profile, srv := setupAccounts(t,
+		storage.Account{ID: "gone", Name: "gone", Token: "access-sandbox-removed", ItemID: "item-removed"},
+	)
+	defer srv.Close()
+
+	// Plaid has already forgotten the item, which shouldn't stop us.
+	if w := deleteAccountID(profile, "gone"); w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+
+	person, err := config.Get(profile.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(person.Accounts) != 0 {
+		t.Errorf("accounts left: %+v", person.Accounts)
+	}
+}
+
+func TestDeleteAccountPlaidDown(t *testing.T) {
+	profile, srv := setupAccounts(t,
+		storage.Account{ID: "checking", Name: "checking", Token: "access-sandbox-checking", ItemID: "item-checking"},
+	)
+	srv.Close()
+
+	if w := deleteAccountID(profile, "checking"); w.Code != http.StatusBadGateway {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusBadGateway)
+	}
+
+	// The account stays so deleting it can be tried again.
+	person, err := config.Get(profile.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(person.Accounts) != 1 {
+		t.Errorf("got accounts %+v, want checking kept", person.Accounts)
+	}
+}
+
+// failingUpdates is a store whose Updates fail.
+type failingUpdates struct {
+	storage.Storage
+}
+
+func (failingUpdates) Update(*storage.Person) error {
+	return errors.New("disk full")
+}
+
+func TestDeleteAccountSaveFails(t *testing.T) {
+	profile, srv := setupAccounts(t,
+		storage.Account{ID: "checking", Name: "checking", Token: "access-sandbox-checking", ItemID: "item-checking"},
+	)
+	defer srv.Close()
+	config.Storage = failingUpdates{config.Storage}
+
+	if w := deleteAccountID(profile, "checking"); w.Code != http.StatusInternalServerError {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+
+	// The item has to outlive the account that's still stored.
+	if !itemExists(t, "access-sandbox-checking") {
+		t.Error("item was removed though the account wasn't deleted")
+	}
+}
+
+func TestBalancesPartialFailure(t *testing.T) {
+	profile, srv := setupAccounts(t,
+		storage.Account{ID: "checking", Name: "checking", Token: "access-sandbox-checking", ItemID: "item-checking"},
+		storage.Account{ID: "gone", Name: "gone", Token: "access-sandbox-removed", ItemID: "item-removed"},
+	)
+	defer srv.Close()
+
+	req := httptest.NewRequest(http.MethodGet, "/api/balances", nil)
+	w := httptest.NewRecorder()
+	appHandler(handleAuthAs(profile, balancesHandler)).ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+
+	var balances []AccountBalances
+	if err := json.Unmarshal(w.Body.Bytes(), &balances); err != nil {
+		t.Fatal(err)
+	}
+	if len(balances) != 2 {
+		t.Fatalf("got %d accounts, want 2", len(balances))
+	}
+
+	if b := balances[0]; b.Error != "" || len(b.Accounts) == 0 {
+		t.Errorf("checking: got error %q and %d accounts", b.Error, len(b.Accounts))
+	}
+	if b := balances[1]; b.Error == "" || b.Class != plaid.ClassInvalidInput.String() || len(b.Accounts) != 0 {
+		t.Errorf("gone: got error %q (%s) and %d accounts", b.Error, b.Class, len(b.Accounts))
+	}
+}
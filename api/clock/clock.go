@@ -0,0 +1,103 @@
+// Package clock lets background work be driven by a fake clock, so
+// schedules can be stepped through without waiting on real time.
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+type Clock interface {
+	Now() time.Time
+
+	// After sends the time on the returned channel once d has passed.
+	After(d time.Duration) <-chan time.Time
+}
+
+// Real is the system clock.
+var Real Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// Fake is a Clock that only moves when Advance or Set is called.
+type Fake struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []waiter
+}
+
+type waiter struct {
+	at time.Time
+	c  chan time.Time
+}
+
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *Fake) After(d time.Duration) <-chan time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c := make(chan time.Time, 1)
+	at := f.now.Add(d)
+
+	if d <= 0 {
+		c <- f.now
+		return c
+	}
+
+	f.waiters = append(f.waiters, waiter{at, c})
+	return c
+}
+
+// Advance moves the clock forward by d, firing any After channels that
+// have come due, earliest first.
+func (f *Fake) Advance(d time.Duration) {
+	f.Set(f.Now().Add(d))
+}
+
+// Set moves the clock to t, firing any After channels that have come due.
+func (f *Fake) Set(t time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.now = t
+
+	sort.SliceStable(f.waiters, func(i, j int) bool {
+		return f.waiters[i].at.Before(f.waiters[j].at)
+	})
+
+	kept := f.waiters[:0]
+	for _, w := range f.waiters {
+		if w.at.After(t) {
+			kept = append(kept, w)
+			continue
+		}
+		w.c <- t
+	}
+	f.waiters = kept
+}
+
+// Waiters returns how many After channels have yet to fire, so a caller
+// can wait for a goroutine to block on the clock before advancing it.
+func (f *Fake) Waiters() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.waiters)
+}
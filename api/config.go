@@ -1,8 +1,16 @@
 package main
 
 import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
 	"github.com/pcarleton/cashcoach/api/auth"
 	"github.com/pcarleton/cashcoach/api/plaid"
+	"github.com/pcarleton/cashcoach/api/plaid/cassette"
+	"github.com/pcarleton/cashcoach/api/rules"
 	"github.com/pcarleton/cashcoach/api/storage"
 	"github.com/spf13/viper"
 	"golang.org/x/oauth2"
@@ -25,10 +33,86 @@ type Config struct {
 	Sessions    *auth.SessionHandler
 	storage.Storage
 	Plaid plaid.Client
+
+	// Transactions holds what the syncer has fetched from Plaid.
+	Transactions storage.TransactionStore
+
+	// Webhooks verifies Plaid webhook signatures.  Nil only when
+	// plaid.webhook_skip_verify is set.
+	Webhooks *webhookVerifier
+
+	Schedule scheduleConfig
+
+	// Admins are the emails allowed to use /api/admin endpoints.
+	Admins []string
+
+	// Rules categorize transactions before they are served.
+	Rules *rules.Engine
+}
+
+func getRules(v *viper.Viper) (*rules.Engine, error) {
+	list := make([]rules.Rule, 0)
+	if err := v.UnmarshalKey("rules", &list); err != nil {
+		return nil, fmt.Errorf("unable to read rules: %v", err)
+	}
+
+	return rules.New(list)
+}
+
+func getScheduleConfig(v *viper.Viper) (scheduleConfig, error) {
+	config := defaultScheduleConfig
+
+	durations := map[string]*time.Duration{
+		"sync.interval":    &config.Interval,
+		"sync.backoff":     &config.Backoff,
+		"sync.max_backoff": &config.MaxBackoff,
+	}
+
+	for key, d := range durations {
+		if !v.IsSet(key) {
+			continue
+		}
+		*d = v.GetDuration(key)
+		if *d <= 0 {
+			return config, fmt.Errorf("%s must be a positive duration like \"6h\"", key)
+		}
+	}
+
+	if v.IsSet("sync.workers") {
+		config.Workers = v.GetInt("sync.workers")
+		if config.Workers < 1 {
+			return config, fmt.Errorf("sync.workers must be at least 1")
+		}
+	}
+
+	if config.Backoff > config.MaxBackoff {
+		return config, fmt.Errorf("sync.backoff must not be longer than sync.max_backoff")
+	}
+
+	return config, nil
+}
+
+// getWebhookVerifier uses the key in plaid.webhook_key if it's set, and
+// otherwise fetches keys from Plaid as webhooks arrive.
+func getWebhookVerifier(v *viper.Viper, client plaid.Client) (*webhookVerifier, error) {
+	if v.GetBool("plaid.webhook_skip_verify") {
+		log.Printf("WARNING: Plaid webhook signatures are not being verified")
+		return nil, nil
+	}
+
+	if keyPath := v.GetString("plaid.webhook_key"); keyPath != "" {
+		return loadWebhookVerifier(keyPath)
+	}
+
+	return plaidWebhookVerifier(client), nil
 }
 
 func getFakeStorage(v *viper.Viper) storage.Storage {
-	return &storage.FakeStorage{[]string{v.GetString("plaid.access_token")}}
+	var tokens []string
+	if token := v.GetString("plaid.access_token"); token != "" {
+		tokens = append(tokens, token)
+	}
+	return storage.NewFakeStorage(tokens...)
 }
 
 func getMongoStorage(v *viper.Viper) (storage.Storage, error) {
@@ -37,11 +121,90 @@ func getMongoStorage(v *viper.Viper) (storage.Storage, error) {
 	if err != nil {
 		return nil, err
 	}
-	storage := &storage.MongoStorage{session}
+	storage := &storage.MongoStorage{Session: session, DB: v.GetString("mongo.db")}
 
 	return storage, nil
 }
 
+func getFileStorage(v *viper.Viper) (storage.Storage, error) {
+	path := v.GetString("storage.path")
+	if path == "" {
+		return nil, fmt.Errorf("storage.path must be set for the file driver")
+	}
+
+	return storage.OpenFileStorage(path)
+}
+
+// getStorage picks the backend named by storage.driver.  Mongo is the
+// default so existing configs keep working.
+func getStorage(v *viper.Viper) (storage.Storage, error) {
+	switch driver := v.GetString("storage.driver"); driver {
+	case "", "mongo":
+		return getMongoStorage(v)
+	case "file":
+		return getFileStorage(v)
+	case "fake":
+		return getFakeStorage(v), nil
+	default:
+		return nil, fmt.Errorf("unknown storage.driver %q, want mongo, file or fake", driver)
+	}
+}
+
+// getPlaidTransport returns a cassette recorder when plaid.cassette is set,
+// or nil to talk to Plaid directly.
+func getPlaidTransport(v *viper.Viper) (http.RoundTripper, error) {
+	path := v.GetString("plaid.cassette")
+	if path == "" {
+		return nil, nil
+	}
+
+	mode, err := cassette.ParseMode(v.GetString("plaid.cassette_mode"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	recorder, err := cassette.New(path, mode, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return recorder, nil
+}
+
+// keyringEnv holds the keyring itself, for deployments that would rather
+// not put it on disk.
+const keyringEnv = "CASHCOACH_KEYRING"
+
+func getKeyring(v *viper.Viper) (*storage.Keyring, error) {
+	if env := os.Getenv(keyringEnv); env != "" {
+		return storage.ParseKeyring([]byte(env))
+	}
+
+	if path := v.GetString("storage.keyring"); path != "" {
+		return storage.LoadKeyring(path)
+	}
+
+	return nil, nil
+}
+
+// encryptStorage seals access tokens at rest when a keyring is configured.
+func encryptStorage(v *viper.Viper, s storage.Storage) (storage.Storage, error) {
+	keyring, err := getKeyring(v)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if keyring == nil {
+		log.Printf("WARNING: no keyring configured, access tokens are stored in plaintext")
+		return s, nil
+	}
+
+	return &storage.EncryptedStorage{Storage: s, Keys: keyring}, nil
+}
+
 func makeConfig(v *viper.Viper) (*Config, error) {
 	// TODO: Consider passing this in
 	scopes := []string{plus.UserinfoProfileScope, plus.UserinfoEmailScope}
@@ -58,11 +221,22 @@ func makeConfig(v *viper.Viper) (*Config, error) {
 	if v.GetString("plaid.env") == "sandbox" {
 		plaidURL = plaid.SandboxURL
 	}
+	// An explicit URL wins, e.g. to point at a plaidtest server.
+	if url := v.GetString("plaid.url"); url != "" {
+		plaidURL = url
+	}
+
+	transport, err := getPlaidTransport(v)
+
+	if err != nil {
+		return nil, err
+	}
 
-	plaidClient := plaid.NewClient(
+	plaidClient := plaid.NewClientWithTransport(
 		v.GetString("plaid.client_id"),
 		v.GetString("plaid.client_secret"),
-		plaidURL)
+		plaidURL,
+		transport)
 
 	sessionHandler, err := auth.CreateSessionHandler(v.GetString("cookie_secret"))
 
@@ -70,11 +244,50 @@ func makeConfig(v *viper.Viper) (*Config, error) {
 		return nil, err
 	}
 
-	storage, err := getMongoStorage(v)
+	backend, err := getStorage(v)
+
+	if err != nil {
+		return nil, err
+	}
+
+	transactions, ok := backend.(storage.TransactionStore)
+	if !ok {
+		return nil, fmt.Errorf("storage.driver %q can't store transactions", v.GetString("storage.driver"))
+	}
+
+	people, err := encryptStorage(v, backend)
+
+	if err != nil {
+		return nil, err
+	}
+
+	webhooks, err := getWebhookVerifier(v, plaidClient)
+
+	if err != nil {
+		return nil, err
+	}
+
+	schedule, err := getScheduleConfig(v)
+
+	if err != nil {
+		return nil, err
+	}
+
+	engine, err := getRules(v)
 
 	if err != nil {
 		return nil, err
 	}
 
-	return &Config{oauthConf, sessionHandler, storage, plaidClient}, nil
+	return &Config{
+		OAuthConfig:  oauthConf,
+		Sessions:     sessionHandler,
+		Storage:      people,
+		Plaid:        plaidClient,
+		Transactions: transactions,
+		Webhooks:     webhooks,
+		Schedule:     schedule,
+		Admins:       v.GetStringSlice("admins"),
+		Rules:        engine,
+	}, nil
 }
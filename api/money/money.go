@@ -0,0 +1,298 @@
+// Package money holds exact amounts of money.  Amounts are whole cents, so
+// sums and splits come out to the cent instead of drifting the way
+// float64 does.
+package money
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"math/bits"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Amount is a number of cents.  It reads and writes as a decimal number of
+// dollars (or whatever the currency's main unit is), like 12.50, in JSON
+// and BSON, so it can stand in for the float64 amounts Plaid sends.
+type Amount int64
+
+// Cents returns n cents.
+func Cents(n int64) Amount {
+	return Amount(n)
+}
+
+// Parse reads a decimal amount like "12.50", "-3", "+0.5" or "1,234.56".
+// Amounts with fractions of a cent are rejected rather than rounded.
+func Parse(s string) (Amount, error) {
+	return parse(s, false)
+}
+
+// parseRounded is Parse, but rounds fractions of a cent to the nearest
+// cent, halves away from zero, like FromFloat.  It's for amounts read off
+// the wire, where one odd value shouldn't fail a whole response.
+func parseRounded(s string) (Amount, error) {
+	return parse(s, true)
+}
+
+func parse(s string, round bool) (Amount, error) {
+	orig := s
+	s = strings.Replace(strings.TrimSpace(s), ",", "", -1)
+
+	negative := false
+	switch {
+	case strings.HasPrefix(s, "-"):
+		negative = true
+		s = s[1:]
+	case strings.HasPrefix(s, "+"):
+		s = s[1:]
+	}
+
+	whole, frac := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		whole, frac = s[:i], s[i+1:]
+	}
+
+	if (whole == "" && frac == "") || !isDigits(whole) || !isDigits(frac) {
+		return 0, fmt.Errorf("invalid amount %q", orig)
+	}
+
+	// Extra zeros past the cents are fine; anything else would be lost
+	// unless rounding was asked for.
+	roundUp := false
+	if len(frac) > 2 {
+		if strings.Trim(frac[2:], "0") != "" {
+			if !round {
+				return 0, fmt.Errorf("amount %q has fractions of a cent", orig)
+			}
+			roundUp = frac[2] >= '5'
+		}
+		frac = frac[:2]
+	}
+	frac += strings.Repeat("0", 2-len(frac))
+
+	if whole == "" {
+		whole = "0"
+	}
+
+	cents, err := strconv.ParseInt(whole+frac, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %q", orig)
+	}
+
+	if roundUp {
+		cents++
+	}
+	if negative {
+		cents = -cents
+	}
+	return Amount(cents), nil
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// MustParse is Parse for amounts known to be valid, like constants.
+func MustParse(s string) Amount {
+	a, err := Parse(s)
+	if err != nil {
+		panic(err)
+	}
+	return a
+}
+
+// FromFloat rounds f to the nearest cent, halves away from zero.  It's
+// for float64s from outside, like config values; anything parsed from
+// text should use Parse.
+func FromFloat(f float64) Amount {
+	if f < 0 {
+		return -Amount(math.Floor(-f*100 + 0.5))
+	}
+	return Amount(math.Floor(f*100 + 0.5))
+}
+
+// Float64 returns a as a float64, for places that need one, like charts.
+func (a Amount) Float64() float64 {
+	return float64(a) / 100
+}
+
+// String formats a with two decimal places, like "-1234.50".
+func (a Amount) String() string {
+	sign := ""
+	cents := int64(a)
+	if cents < 0 {
+		sign = "-"
+		cents = -cents
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, cents/100, cents%100)
+}
+
+// Abs returns a without its sign.
+func (a Amount) Abs() Amount {
+	if a < 0 {
+		return -a
+	}
+	return a
+}
+
+// MarshalJSON writes a as a JSON number.
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// UnmarshalJSON reads a JSON number or a string holding one.  The digits
+// are parsed exactly, never through a float64, and fractions of a cent
+// are rounded like FromFloat does.  null leaves a unchanged, like it does
+// for the other number types.
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if string(data) == "null" {
+		return nil
+	}
+
+	s := string(data)
+	if unquoted, err := strconv.Unquote(s); err == nil {
+		s = unquoted
+	}
+
+	// Plaid sends plain decimals, but allow exponents in case something
+	// re-encoded a float.
+	if strings.ContainsAny(s, "eE") {
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("invalid amount %s", data)
+		}
+		*a = FromFloat(f)
+		return nil
+	}
+
+	parsed, err := parseRounded(s)
+	if err != nil {
+		return err
+	}
+	*a = parsed
+	return nil
+}
+
+// GetBSON stores a as a double, the way amounts were stored when they were
+// float64s, so existing documents and queries keep working.
+func (a Amount) GetBSON() (interface{}, error) {
+	return a.Float64(), nil
+}
+
+// SetBSON reads an amount stored as a number or a string, rounding
+// fractions of a cent like UnmarshalJSON.
+func (a *Amount) SetBSON(raw bson.Raw) error {
+	var v interface{}
+	if err := raw.Unmarshal(&v); err != nil {
+		return err
+	}
+
+	switch v := v.(type) {
+	case nil:
+		*a = 0
+	case float64:
+		*a = FromFloat(v)
+	case int:
+		*a = Amount(v) * 100
+	case int64:
+		*a = Amount(v) * 100
+	case string:
+		parsed, err := parseRounded(v)
+		if err != nil {
+			return err
+		}
+		*a = parsed
+	default:
+		return fmt.Errorf("can't read an amount from BSON %T", v)
+	}
+	return nil
+}
+
+// Split divides a into n parts that add up to exactly a.  The parts differ
+// by at most a cent, and the earlier parts get the extra cents, so the
+// same split always comes out the same way.  n must be positive.
+func (a Amount) Split(n int) []Amount {
+	if n <= 0 {
+		panic(fmt.Sprintf("money: can't split into %d parts", n))
+	}
+
+	weights := make([]int64, n)
+	for i := range weights {
+		weights[i] = 1
+	}
+	return a.Allocate(weights...)
+}
+
+// Allocate divides a in proportion to weights, in parts that add up to
+// exactly a.  Each part is first rounded toward zero; the cents left over
+// go one each to the parts that lost the most to rounding, earlier parts
+// first on ties.  Parts have a's sign.  Weights must not be negative, at
+// least one must be positive, and together they must fit in an int64.
+func (a Amount) Allocate(weights ...int64) []Amount {
+	var total int64
+	for _, w := range weights {
+		if w < 0 {
+			panic(fmt.Sprintf("money: negative weight %d", w))
+		}
+		if w > math.MaxInt64-total {
+			panic("money: weights add up to more than an int64")
+		}
+		total += w
+	}
+	if total == 0 {
+		panic("money: no weight to allocate by")
+	}
+
+	sign := Amount(1)
+	if a < 0 {
+		sign = -1
+	}
+	cents := uint64(a.Abs())
+
+	parts := make([]Amount, len(weights))
+	remainders := make([]uint64, len(weights))
+	left := int64(cents)
+
+	// cents*w can need 128 bits.  The quotient can't, since w <= total.
+	for i, w := range weights {
+		hi, lo := bits.Mul64(cents, uint64(w))
+		share, remainder := bits.Div64(hi, lo, uint64(total))
+		parts[i] = Amount(share)
+		remainders[i] = remainder
+		left -= int64(share)
+	}
+
+	order := make([]int, len(weights))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return remainders[order[i]] > remainders[order[j]]
+	})
+
+	for _, i := range order {
+		if left == 0 {
+			break
+		}
+		if weights[i] == 0 {
+			continue
+		}
+		parts[i]++
+		left--
+	}
+
+	for i := range parts {
+		parts[i] *= sign
+	}
+	return parts
+}
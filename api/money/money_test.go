@@ -0,0 +1,158 @@
+package money_test
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"testing"
+
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/pcarleton/cashcoach/api/money"
+)
+
+func TestParseRejectsFractionsOfACent(t *testing.T) {
+	for _, s := range []string{"1.005", "-0.001", "12.3456"} {
+		if a, err := money.Parse(s); err == nil {
+			t.Errorf("Parse(%q) = %v, want an error", s, a)
+		}
+	}
+
+	if a, err := money.Parse("1.2500"); err != nil || a != money.Cents(125) {
+		t.Errorf("Parse(1.2500) = %v, %v; want 1.25", a, err)
+	}
+}
+
+func TestUnmarshalJSONRounds(t *testing.T) {
+	cases := map[string]money.Amount{
+		`12.5`:      1250,
+		`1.004`:     100,
+		`1.005`:     101,
+		`-1.005`:    -101,
+		`0.995`:     100,
+		`"-0.0049"`: 0,
+		`"2.675"`:   268,
+		`1.5e-3`:    0,
+	}
+
+	for in, want := range cases {
+		var got money.Amount
+		if err := json.Unmarshal([]byte(in), &got); err != nil {
+			t.Errorf("%s: %v", in, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("%s read as %v, want %v", in, got, want)
+		}
+	}
+}
+
+// TestUnmarshalResponse checks one sub-cent amount doesn't fail the rest
+// of a response.
+func TestUnmarshalResponse(t *testing.T) {
+	var resp struct {
+		Transactions []struct {
+			Amount money.Amount `json:"amount"`
+		} `json:"transactions"`
+	}
+
+	err := json.Unmarshal([]byte(`{"transactions": [{"amount": 4.5}, {"amount": 0.0125}]}`), &resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Transactions) != 2 || resp.Transactions[0].Amount != 450 || resp.Transactions[1].Amount != 1 {
+		t.Errorf("got %+v", resp.Transactions)
+	}
+}
+
+func TestSetBSONRounds(t *testing.T) {
+	data, err := bson.Marshal(bson.M{"f": 1.005, "s": "-1.005"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var doc struct {
+		F money.Amount `bson:"f"`
+		S money.Amount `bson:"s"`
+	}
+	if err := bson.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.S != -101 {
+		t.Errorf("string -1.005 read as %v, want -1.01", doc.S)
+	}
+	if doc.F != money.FromFloat(1.005) {
+		t.Errorf("double 1.005 read as %v, want %v", doc.F, money.FromFloat(1.005))
+	}
+}
+
+func TestSplit(t *testing.T) {
+	cases := []struct {
+		amount money.Amount
+		n      int
+		want   []money.Amount
+	}{
+		{100, 3, []money.Amount{34, 33, 33}},
+		{-100, 3, []money.Amount{-34, -33, -33}},
+		{2, 3, []money.Amount{1, 1, 0}},
+		{-1, 2, []money.Amount{-1, 0}},
+		{0, 2, []money.Amount{0, 0}},
+		{999, 1, []money.Amount{999}},
+	}
+
+	for _, c := range cases {
+		got := c.amount.Split(c.n)
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%v.Split(%d) = %v, want %v", c.amount, c.n, got, c.want)
+		}
+	}
+}
+
+func TestAllocate(t *testing.T) {
+	cases := []struct {
+		amount  money.Amount
+		weights []int64
+		want    []money.Amount
+	}{
+		// The cent left over goes to the part that lost the most.
+		{1000, []int64{1, 0, 2}, []money.Amount{333, 0, 667}},
+		// Ties go to the earlier part, skipping zero weights.
+		{5, []int64{0, 1, 1}, []money.Amount{0, 3, 2}},
+		{2, []int64{0, 1, 1, 1}, []money.Amount{0, 1, 1, 0}},
+		{-7, []int64{1, 1}, []money.Amount{-4, -3}},
+		{-1000, []int64{1, 0, 2}, []money.Amount{-333, 0, -667}},
+		// Shares that overflow an int64 before dividing.
+		{1 << 62, []int64{1 << 40, 1 << 40}, []money.Amount{1 << 61, 1 << 61}},
+		{math.MaxInt64, []int64{math.MaxInt64 / 2, math.MaxInt64 / 2},
+			[]money.Amount{math.MaxInt64/2 + 1, math.MaxInt64 / 2}},
+	}
+
+	for _, c := range cases {
+		got := c.amount.Allocate(c.weights...)
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%v.Allocate(%v) = %v, want %v", c.amount, c.weights, got, c.want)
+		}
+	}
+}
+
+func TestAllocatePanics(t *testing.T) {
+	cases := map[string]func(){
+		"split into none":     func() { money.Cents(100).Split(0) },
+		"split into negative": func() { money.Cents(100).Split(-1) },
+		"no weights":          func() { money.Cents(100).Allocate() },
+		"zero weights":        func() { money.Cents(100).Allocate(0, 0) },
+		"negative weight":     func() { money.Cents(100).Allocate(-1, 2) },
+		"weights overflow":    func() { money.Cents(100).Allocate(math.MaxInt64, 1) },
+	}
+
+	for name, f := range cases {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: didn't panic", name)
+				}
+			}()
+			f()
+		}()
+	}
+}
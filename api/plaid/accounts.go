@@ -0,0 +1,56 @@
+package plaid
+
+import "context"
+
+type AccountsOptions struct {
+	AccountIDs []string `json:"account_ids,omitempty"`
+}
+
+type AccountsRequest struct {
+	ClientID    string           `json:"client_id"`
+	Secret      string           `json:"secret"`
+	AccessToken string           `json:"access_token"`
+	Options     *AccountsOptions `json:"options,omitempty"`
+}
+
+type AccountsResponse struct {
+	Accounts  []Account `json:"accounts"`
+	Item      Item      `json:"item"`
+	RequestID string    `json:"request_id"`
+}
+
+// Accounts returns the item's accounts from /accounts/get.  Balances are
+// whatever Plaid last cached and may be hours old; use Balances for live
+// numbers.
+func (c *Client) Accounts(ctx context.Context, accessToken string) (AccountsResponse, error) {
+	return c.accounts(ctx, "/accounts/get", accessToken, nil)
+}
+
+// Balances fetches real-time balances from /accounts/balance/get, which
+// asks the institution directly and can be slow.  An empty accountIDs
+// returns every account on the item.
+func (c *Client) Balances(ctx context.Context, accessToken string, accountIDs []string) (AccountsResponse, error) {
+	var opts *AccountsOptions
+	if len(accountIDs) > 0 {
+		opts = &AccountsOptions{AccountIDs: accountIDs}
+	}
+
+	return c.accounts(ctx, "/accounts/balance/get", accessToken, opts)
+}
+
+func (c *Client) accounts(ctx context.Context, endpoint, accessToken string, opts *AccountsOptions) (AccountsResponse, error) {
+	request := AccountsRequest{
+		ClientID:    c.clientID,
+		Secret:      c.secret,
+		AccessToken: accessToken,
+		Options:     opts,
+	}
+
+	resp := AccountsResponse{}
+	err := c.post(ctx, endpoint, request, &resp)
+	if err != nil {
+		return resp, err
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,324 @@
+// Package cassette records Plaid HTTP traffic to disk and replays it.
+//
+// A Recorder is an http.RoundTripper for plaid.NewClientWithTransport.  In
+// Record mode it forwards requests to Plaid and appends each
+// request/response pair to a cassette file, with credentials and tokens
+// scrubbed.  In Replay mode it never touches the network: requests are
+// matched against the cassette by method, path and scrubbed body, and
+// identical requests are answered in the order they were recorded.
+package cassette
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+type Mode int
+
+const (
+	Replay Mode = iota
+	Record
+)
+
+// ParseMode converts "record" or "replay" to a Mode.
+func ParseMode(mode string) (Mode, error) {
+	switch mode {
+	case "record":
+		return Record, nil
+	case "replay", "":
+		return Replay, nil
+	}
+	return Replay, fmt.Errorf("unknown cassette mode %q, expected record or replay", mode)
+}
+
+// scrubbed lists request fields that never get written to a cassette.
+var scrubbed = []string{"client_id", "secret"}
+
+// tokens lists request and response fields holding item tokens.  They're
+// replaced by a pseudonym rather than redacted outright, so requests for
+// different items still replay differently, and a token the client got
+// from a replayed response matches the requests recorded with the real one.
+var tokens = []string{"access_token", "access_token_v1", "public_token"}
+
+const (
+	redacted = "REDACTED"
+
+	// pseudonymPrefix starts every replaced token, so scrubbing a
+	// pseudonym leaves it alone.
+	pseudonymPrefix = "redacted-"
+)
+
+type Request struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	Body   string `json:"body"`
+}
+
+type Response struct {
+	StatusCode  int    `json:"status_code"`
+	ContentType string `json:"content_type"`
+	Body        string `json:"body"`
+}
+
+type Interaction struct {
+	Request  Request  `json:"request"`
+	Response Response `json:"response"`
+}
+
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+type Recorder struct {
+	path string
+	mode Mode
+	real http.RoundTripper
+
+	mu       sync.Mutex
+	cassette Cassette
+	// played counts how many times each request key has been replayed.
+	played map[string]int
+}
+
+// New returns a Recorder backed by the cassette file at path.  In Replay
+// mode the file must already exist.  In Record mode it is overwritten, and
+// requests are sent with real, or http.DefaultTransport if nil.
+func New(path string, mode Mode, real http.RoundTripper) (*Recorder, error) {
+	if real == nil {
+		real = http.DefaultTransport
+	}
+
+	r := &Recorder{
+		path:   path,
+		mode:   mode,
+		real:   real,
+		played: make(map[string]int),
+	}
+
+	if mode == Record {
+		return r, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &r.cassette); err != nil {
+		return nil, fmt.Errorf("invalid cassette %s: %v", path, err)
+	}
+
+	return r, nil
+}
+
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	body := []byte{}
+	if req.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	recorded := Request{
+		Method: req.Method,
+		Path:   req.URL.Path,
+		Body:   scrub(body),
+	}
+
+	if r.mode == Record {
+		return r.record(req, body, recorded)
+	}
+
+	return r.replay(req, recorded)
+}
+
+func (r *Recorder) record(req *http.Request, body []byte, recorded Request) (*http.Response, error) {
+	// The original body was consumed above, so send a fresh copy.
+	out := req.Clone(req.Context())
+	out.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	resp, err := r.real.RoundTrip(out)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	interaction := Interaction{
+		Request: recorded,
+		Response: Response{
+			StatusCode:  resp.StatusCode,
+			ContentType: resp.Header.Get("Content-Type"),
+			Body:        scrubResponse(respBody),
+		},
+	}
+
+	r.mu.Lock()
+	r.cassette.Interactions = append(r.cassette.Interactions, interaction)
+	err = r.save()
+	r.mu.Unlock()
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to write cassette: %v", err)
+	}
+
+	// The caller gets the real response, so recording works end to end.
+	live := interaction.Response
+	live.Body = string(respBody)
+	return live.httpResponse(req), nil
+}
+
+func (r *Recorder) replay(req *http.Request, recorded Request) (*http.Response, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := recorded.key()
+	skip := r.played[key]
+
+	for _, i := range r.cassette.Interactions {
+		if i.Request.key() != key {
+			continue
+		}
+		if skip > 0 {
+			skip--
+			continue
+		}
+
+		r.played[key]++
+		return i.Response.httpResponse(req), nil
+	}
+
+	return nil, fmt.Errorf("cassette %s has no recorded response for %s %s (call %d) with body %s",
+		r.path, recorded.Method, recorded.Path, r.played[key]+1, recorded.Body)
+}
+
+// save writes the whole cassette; callers must hold r.mu.
+func (r *Recorder) save() error {
+	data, err := json.MarshalIndent(r.cassette, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := r.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, r.path)
+}
+
+func (req Request) key() string {
+	return req.Method + " " + req.Path + " " + req.Body
+}
+
+func (resp Response) httpResponse(req *http.Request) *http.Response {
+	header := make(http.Header)
+	if resp.ContentType != "" {
+		header.Set("Content-Type", resp.ContentType)
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", resp.StatusCode, http.StatusText(resp.StatusCode)),
+		StatusCode:    resp.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader([]byte(resp.Body))),
+		ContentLength: int64(len(resp.Body)),
+		Request:       req,
+	}
+}
+
+// scrub redacts credentials and tokens and re-encodes the body with sorted
+// keys, so the same request always records and matches the same way.
+// Bodies that aren't JSON objects are kept as they are.
+func scrub(body []byte) string {
+	fields, ok := decodeObject(body)
+	if !ok {
+		return string(body)
+	}
+
+	for _, name := range scrubbed {
+		if _, ok := fields[name]; ok {
+			fields[name] = redacted
+		}
+	}
+	pseudonymize(fields)
+
+	canonical, err := json.Marshal(fields)
+	if err != nil {
+		return string(body)
+	}
+
+	return string(canonical)
+}
+
+// scrubResponse replaces the tokens in a response, like the access token
+// /item/public_token/exchange hands out.  Responses without any are kept
+// byte for byte.
+func scrubResponse(body []byte) string {
+	fields, ok := decodeObject(body)
+	if !ok || !pseudonymize(fields) {
+		return string(body)
+	}
+
+	scrubbed, err := json.Marshal(fields)
+	if err != nil {
+		return string(body)
+	}
+
+	return string(scrubbed)
+}
+
+// decodeObject decodes a JSON object, keeping numbers as they were
+// written so amounts aren't rounded through float64.
+func decodeObject(body []byte) (map[string]interface{}, bool) {
+	fields := make(map[string]interface{})
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(&fields); err != nil {
+		return nil, false
+	}
+	return fields, true
+}
+
+// pseudonymize replaces the tokens in fields, reporting whether there
+// were any.
+func pseudonymize(fields map[string]interface{}) bool {
+	changed := false
+	for _, name := range tokens {
+		if token, ok := fields[name].(string); ok && token != "" {
+			fields[name] = pseudonym(token)
+			changed = true
+		}
+	}
+	return changed
+}
+
+// pseudonym stands in for token.  It's a hash, so the same token always
+// gets the same pseudonym without the token being recoverable from it.
+func pseudonym(token string) string {
+	if strings.HasPrefix(token, pseudonymPrefix) {
+		return token
+	}
+
+	sum := sha256.Sum256([]byte(token))
+	return pseudonymPrefix + hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,149 @@
+package cassette_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pcarleton/cashcoach/api/plaid"
+	"github.com/pcarleton/cashcoach/api/plaid/cassette"
+	"github.com/pcarleton/cashcoach/api/plaid/plaidtest"
+)
+
+// session is what a client does with a newly linked item: exchange the
+// public token, then fetch its transactions and accounts.
+type session struct {
+	Exchange     plaid.ExchangeResponse
+	Transactions plaid.TransactionResponse
+	Accounts     plaid.AccountsResponse
+}
+
+func run(t *testing.T, client plaid.Client, publicToken string) session {
+	t.Helper()
+	ctx := context.Background()
+
+	exchange, err := client.Exchange(ctx, publicToken)
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+
+	start := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2017, 12, 31, 0, 0, 0, 0, time.UTC)
+	txns, err := client.AllTransactions(ctx, exchange.AccessToken, start, end, nil)
+	if err != nil {
+		t.Fatalf("AllTransactions: %v", err)
+	}
+
+	accounts, err := client.Accounts(ctx, exchange.AccessToken)
+	if err != nil {
+		t.Fatalf("Accounts: %v", err)
+	}
+
+	return session{exchange, txns, accounts}
+}
+
+func newClient(fixtures *plaidtest.Fixtures, url string, recorder *cassette.Recorder) plaid.Client {
+	client := plaid.NewClientWithTransport(fixtures.ClientID, fixtures.Secret, url, recorder)
+	client.SetRetryPolicy(plaid.NoRetries)
+	client.SetRateLimit(0, 0)
+	return client
+}
+
+func TestRecordReplay(t *testing.T) {
+	fixtures, err := plaidtest.LoadFixtures("../plaidtest/testdata/fixtures.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	item := fixtures.Items[0]
+
+	dir, err := ioutil.TempDir("", "cassette")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "plaid.json")
+
+	srv := plaidtest.Start(fixtures)
+	recorder, err := cassette.New(path, cassette.Record, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorded := run(t, newClient(fixtures, srv.URL, recorder), item.PublicToken)
+	srv.Close()
+
+	if recorded.Exchange.AccessToken != item.AccessToken {
+		t.Errorf("recording got access token %q, want the real one", recorded.Exchange.AccessToken)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{fixtures.ClientID, fixtures.Secret, item.AccessToken, item.PublicToken} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("cassette contains %q", secret)
+		}
+	}
+
+	// Replay against a server that's gone, so nothing can reach it.
+	player, err := cassette.New(path, cassette.Replay, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayed := run(t, newClient(fixtures, srv.URL, player), item.PublicToken)
+
+	if !strings.HasPrefix(replayed.Exchange.AccessToken, "redacted-") {
+		t.Errorf("replay got access token %q, want a pseudonym", replayed.Exchange.AccessToken)
+	}
+
+	// Everything but the token should come back the same.
+	replayed.Exchange.AccessToken = recorded.Exchange.AccessToken
+	if !reflect.DeepEqual(recorded, replayed) {
+		t.Errorf("replay differs from recording:\nrecorded %+v\nreplayed %+v", recorded, replayed)
+	}
+
+	// Clients holding the real token, like a CLI config written while
+	// recording, replay too.
+	player, err = cassette.New(path, cassette.Replay, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := newClient(fixtures, srv.URL, player)
+	if _, err := client.Exchange(context.Background(), item.PublicToken); err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	start := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2017, 12, 31, 0, 0, 0, 0, time.UTC)
+	if _, err := client.AllTransactions(context.Background(), item.AccessToken, start, end, nil); err != nil {
+		t.Errorf("replay with the real token: %v", err)
+	}
+}
+
+func TestReplayUnknownRequest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cassette")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "empty.json")
+	if err := ioutil.WriteFile(path, []byte(`{"interactions": []}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	player, err := cassette.New(path, cassette.Replay, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := plaid.NewClientWithTransport("id", "secret", "http://plaid.invalid", player)
+	client.SetRetryPolicy(plaid.NoRetries)
+	if _, err := client.Exchange(context.Background(), "public-token"); err == nil {
+		t.Error("Exchange succeeded with nothing recorded")
+	}
+}
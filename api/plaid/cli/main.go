@@ -1,10 +1,12 @@
 package main
 
 import (
+	"context"
 	"github.com/spf13/viper"
 	"log"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/pcarleton/cashcoach/api/plaid"
 )
@@ -27,8 +29,9 @@ func main() {
 
 func listTransactions(accessToken string, client plaid.Client) {
 	accessToken = updateToken(accessToken, client)
-	resp, err := client.Transactions(accessToken,
-		"2017-04-01", "2017-04-07")
+	start, _ := time.Parse(plaid.DateFmt, "2017-04-01")
+	end, _ := time.Parse(plaid.DateFmt, "2017-04-07")
+	resp, err := client.AllTransactions(context.Background(), accessToken, start, end, nil)
 
 	if err != nil {
 		log.Fatal(err)
@@ -38,7 +41,7 @@ func listTransactions(accessToken string, client plaid.Client) {
 }
 
 func updateToken(accessToken string, client plaid.Client) string {
-	resp, err := client.UpdateAccessToken(accessToken)
+	resp, err := client.UpdateAccessToken(context.Background(), accessToken)
 
 	if err != nil {
 		log.Fatalf("Error upgrading token: %v", err)
@@ -0,0 +1,174 @@
+package plaid
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ErrorClass groups Plaid errors by how a caller should react to them.
+type ErrorClass int
+
+const (
+	ClassUnknown ErrorClass = iota
+
+	// ClassRateLimit means we, or the institution, are sending too many
+	// requests.  Retryable.
+	ClassRateLimit
+
+	// ClassInstitutionDown means the bank isn't answering.  Retryable.
+	ClassInstitutionDown
+
+	// ClassItemLoginRequired means the user must re-authenticate through
+	// Link's update mode before the item works again.
+	ClassItemLoginRequired
+
+	// ClassInvalidInput means the request itself was bad: unknown tokens,
+	// bad credentials, malformed fields.
+	ClassInvalidInput
+
+	// ClassServer is an internal Plaid error or a non-JSON 5xx.  Retryable.
+	ClassServer
+)
+
+var classNames = map[ErrorClass]string{
+	ClassUnknown:           "unknown",
+	ClassRateLimit:         "rate-limit",
+	ClassInstitutionDown:   "institution-down",
+	ClassItemLoginRequired: "item-login-required",
+	ClassInvalidInput:      "invalid-input",
+	ClassServer:            "server",
+}
+
+func (c ErrorClass) String() string {
+	return classNames[c]
+}
+
+// ParseErrorClass is the inverse of String.  Unrecognized names are
+// ClassUnknown.
+func ParseErrorClass(name string) ErrorClass {
+	for c, n := range classNames {
+		if n == name {
+			return c
+		}
+	}
+	return ClassUnknown
+}
+
+// ApiError is returned for any non-200 response from Plaid.  Use errors.As
+// to get at it, and Class to decide what to do:
+//
+//	var apiErr plaid.ApiError
+//	if errors.As(err, &apiErr) && apiErr.Class() == plaid.ClassItemLoginRequired {
+//		...
+//	}
+type ApiError struct {
+	Response   *ErrorResponse
+	StatusCode int
+
+	// RetryAfter is the server's Retry-After hint, if it sent one.
+	RetryAfter time.Duration
+}
+
+func (e ApiError) Error() string {
+	return fmt.Sprintf("%+v", e.Response)
+}
+
+func (e ApiError) Class() ErrorClass {
+	if e.Response != nil {
+		switch e.Response.Type {
+		case "RATE_LIMIT_EXCEEDED":
+			return ClassRateLimit
+		case "INSTITUTION_ERROR":
+			return ClassInstitutionDown
+		case "INVALID_INPUT", "INVALID_REQUEST":
+			return ClassInvalidInput
+		case "API_ERROR":
+			return ClassServer
+		case "ITEM_ERROR":
+			if e.Response.Code == "ITEM_LOGIN_REQUIRED" {
+				return ClassItemLoginRequired
+			}
+		}
+	}
+
+	switch {
+	case e.StatusCode == http.StatusTooManyRequests:
+		return ClassRateLimit
+	case e.StatusCode >= 500:
+		return ClassServer
+	}
+
+	return ClassUnknown
+}
+
+// Retryable reports whether sending the same request again may succeed.
+func (e ApiError) Retryable() bool {
+	switch e.Class() {
+	case ClassRateLimit, ClassInstitutionDown, ClassServer:
+		return true
+	}
+	return false
+}
+
+// ErrorClassOf returns the class of err if it wraps an ApiError, and
+// ClassUnknown otherwise.
+func ErrorClassOf(err error) ErrorClass {
+	var apiErr ApiError
+	if errors.As(err, &apiErr) {
+		return apiErr.Class()
+	}
+	return ClassUnknown
+}
+
+// maxErrorBody caps how much of a non-JSON error body ends up in a message.
+const maxErrorBody = 512
+
+// errorFromBody builds an ApiError from a non-200 response, whether or not
+// the body is Plaid's JSON error envelope.
+func errorFromBody(resp *http.Response, body []byte) ApiError {
+	apiErr := ApiError{
+		StatusCode: resp.StatusCode,
+		RetryAfter: retryAfter(resp.Header.Get("Retry-After")),
+	}
+
+	errResp := ErrorResponse{}
+	if err := json.Unmarshal(body, &errResp); err == nil && errResp.Type != "" {
+		apiErr.Response = &errResp
+		return apiErr
+	}
+
+	// Proxies and load balancers in front of Plaid answer in HTML or plain
+	// text, so keep a snippet instead of failing to decode it.
+	msg := string(body)
+	if len(msg) > maxErrorBody {
+		msg = msg[:maxErrorBody] + "..."
+	}
+
+	apiErr.Response = &ErrorResponse{
+		Code:    fmt.Sprintf("HTTP_%d", resp.StatusCode),
+		Message: fmt.Sprintf("%s: %s", http.StatusText(resp.StatusCode), msg),
+	}
+	return apiErr
+}
+
+func retryAfter(header string) time.Duration {
+	if header == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(header); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if at, err := http.ParseTime(header); err == nil {
+		if wait := time.Until(at); wait > 0 {
+			return wait
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,117 @@
+package plaid
+
+import (
+	"context"
+	"errors"
+)
+
+type ItemRequest struct {
+	ClientID    string `json:"client_id"`
+	Secret      string `json:"secret"`
+	AccessToken string `json:"access_token"`
+}
+
+type ItemResponse struct {
+	Item      Item   `json:"item"`
+	RequestID string `json:"request_id"`
+}
+
+// Item fetches the item behind accessToken from /item/get.  An item that
+// needs attention still comes back successfully, with Item.Error set.
+func (c *Client) Item(ctx context.Context, accessToken string) (ItemResponse, error) {
+	endpoint := "/item/get"
+
+	request := ItemRequest{
+		ClientID:    c.clientID,
+		Secret:      c.secret,
+		AccessToken: accessToken,
+	}
+
+	resp := ItemResponse{}
+	err := c.post(ctx, endpoint, request, &resp)
+	if err != nil {
+		return resp, err
+	}
+
+	return resp, nil
+}
+
+type RemoveItemResponse struct {
+	RequestID string `json:"request_id"`
+}
+
+// RemoveItem deletes the item behind accessToken from Plaid, which stops
+// its billing and webhooks and invalidates the token.
+func (c *Client) RemoveItem(ctx context.Context, accessToken string) error {
+	endpoint := "/item/remove"
+
+	request := ItemRequest{
+		ClientID:    c.clientID,
+		Secret:      c.secret,
+		AccessToken: accessToken,
+	}
+
+	resp := RemoveItemResponse{}
+	return c.post(ctx, endpoint, request, &resp)
+}
+
+type ItemState string
+
+const (
+	ItemHealthy       ItemState = "healthy"
+	ItemLoginRequired ItemState = "login-required"
+	ItemErrored       ItemState = "error"
+)
+
+// ItemHealth is a summary of whether an item can be used right now.
+type ItemHealth struct {
+	ItemID        string         `json:"item_id"`
+	InstitutionID string         `json:"institution_id"`
+	State         ItemState      `json:"state"`
+	Error         *ErrorResponse `json:"error,omitempty"`
+}
+
+// NeedsUpdate reports whether the user has to go through Link's update
+// mode (see CreatePublicToken) to repair the item.
+func (h ItemHealth) NeedsUpdate() bool {
+	return h.State == ItemLoginRequired
+}
+
+// CheckItem reports the health of the item behind accessToken.  Problems
+// with the item itself are reported in the ItemHealth; the error is only
+// for failures to ask, such as an unknown token or Plaid being down.
+func (c *Client) CheckItem(ctx context.Context, accessToken string) (ItemHealth, error) {
+	resp, err := c.Item(ctx, accessToken)
+
+	var apiErr ApiError
+	if errors.As(err, &apiErr) && apiErr.Response != nil && apiErr.Response.Type == "ITEM_ERROR" {
+		return healthFromError(ItemHealth{}, *apiErr.Response), nil
+	}
+
+	if err != nil {
+		return ItemHealth{}, err
+	}
+
+	health := ItemHealth{
+		ItemID:        resp.Item.ItemID,
+		InstitutionID: resp.Item.InstitutionID,
+		State:         ItemHealthy,
+	}
+
+	if resp.Item.Error.Code == "" {
+		return health, nil
+	}
+
+	return healthFromError(health, resp.Item.Error), nil
+}
+
+func healthFromError(health ItemHealth, errResp ErrorResponse) ItemHealth {
+	health.Error = &errResp
+	health.State = ItemErrored
+
+	if (ApiError{Response: &errResp}).Class() == ClassItemLoginRequired {
+		health.State = ItemLoginRequired
+	}
+
+	return health
+}
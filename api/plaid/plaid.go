@@ -2,17 +2,23 @@ package plaid
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"io/ioutil"
 	"net/http"
 	"time"
+
+	"github.com/pcarleton/cashcoach/api/money"
 )
 
 const (
 	DevURL     = "https://development.plaid.com"
 	SandboxURL = "https://sandbox.plaid.com"
 	DateFmt    = "2006-01-02"
+
+	// MaxTransactionCount is the largest page /transactions/get will return.
+	MaxTransactionCount = 500
 )
 
 type Client struct {
@@ -20,17 +26,39 @@ type Client struct {
 	client   *http.Client
 	clientID string
 	secret   string
+	retry    RetryPolicy
+	limiter  *rateLimiter
 }
 
 func NewClient(clientID, secret, baseURL string) Client {
+	return NewClientWithTransport(clientID, secret, baseURL, nil)
+}
+
+// NewClientWithTransport is like NewClient but sends every request through
+// transport, e.g. a cassette.Recorder.  A nil transport uses
+// http.DefaultTransport.
+func NewClientWithTransport(clientID, secret, baseURL string, transport http.RoundTripper) Client {
 	return Client{
 		baseURL:  baseURL,
-		client:   new(http.Client),
+		client:   &http.Client{Transport: transport},
 		clientID: clientID,
 		secret:   secret,
+		retry:    DefaultRetryPolicy,
+		limiter:  newRateLimiter(DefaultRatePerSecond, DefaultRateBurst),
 	}
 }
 
+// SetRetryPolicy replaces DefaultRetryPolicy for this client.
+func (c *Client) SetRetryPolicy(policy RetryPolicy) {
+	c.retry = policy
+}
+
+// SetRateLimit allows perSecond requests a second, in bursts of up to
+// burst.  A perSecond of zero or less turns off client-side limiting.
+func (c *Client) SetRateLimit(perSecond float64, burst int) {
+	c.limiter = newRateLimiter(perSecond, burst)
+}
+
 type UpdateAccessTokenRequest struct {
 	ClientID    string `json:"client_id"`
 	Secret      string `json:"secret"`
@@ -42,13 +70,13 @@ type UpdateAccessTokenResponse struct {
 	RequestID   string `json:"request_id"`
 }
 
-func (c *Client) UpdateAccessToken(accessToken string) (UpdateAccessTokenResponse, error) {
+func (c *Client) UpdateAccessToken(ctx context.Context, accessToken string) (UpdateAccessTokenResponse, error) {
 	endpoint := "/item/access_token/update_version"
 
 	req := UpdateAccessTokenRequest{c.clientID, c.secret, accessToken}
 
 	resp := UpdateAccessTokenResponse{}
-	err := c.post(endpoint, req, &resp)
+	err := c.post(ctx, endpoint, req, &resp)
 
 	if err != nil {
 		return resp, err
@@ -57,30 +85,62 @@ func (c *Client) UpdateAccessToken(accessToken string) (UpdateAccessTokenRespons
 	return resp, nil
 }
 
-type ApiError struct {
-	Response *ErrorResponse
-}
-
-func (e ApiError) Error() string {
-	return fmt.Sprintf("%+v", e.Response)
-}
-
-func (c *Client) post(endpoint string, req interface{}, resp interface{}) error {
+// post sends req to endpoint and decodes the reply into resp, retrying
+// retryable errors according to c.retry.
+func (c *Client) post(ctx context.Context, endpoint string, req interface{}, resp interface{}) error {
 
 	jsonText, err := json.Marshal(req)
 	if err != nil {
 		return err
 	}
 
+	maxAttempts := c.retry.MaxAttempts
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+
+	for attempt := 1; ; attempt++ {
+		if err := c.limiter.wait(ctx); err != nil {
+			return err
+		}
+
+		err = c.postOnce(ctx, endpoint, jsonText, resp)
+
+		if err == nil || !retryable(err) || attempt >= maxAttempts || ctx.Err() != nil {
+			return err
+		}
+
+		delay := c.retry.backoff(attempt - 1)
+		var apiErr ApiError
+		if errors.As(err, &apiErr) && apiErr.RetryAfter > delay {
+			delay = apiErr.RetryAfter
+		}
+
+		if err := sleep(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+func (c *Client) postOnce(ctx context.Context, endpoint string, jsonText []byte, resp interface{}) error {
 	target := c.baseURL + endpoint
 
-	postResp, err := c.client.Post(target, "application/json",
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, target,
 		bytes.NewReader(jsonText))
 
 	if err != nil {
 		return err
 	}
 
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	postResp, err := c.client.Do(httpReq)
+
+	if err != nil {
+		return err
+	}
+	defer postResp.Body.Close()
+
 	respBody, err := ioutil.ReadAll(postResp.Body)
 
 	if err != nil {
@@ -88,12 +148,7 @@ func (c *Client) post(endpoint string, req interface{}, resp interface{}) error
 	}
 
 	if postResp.StatusCode != 200 {
-		errResp := ErrorResponse{}
-		if err := json.Unmarshal(respBody, &errResp); err != nil {
-			return err
-		}
-
-		return ApiError{&errResp}
+		return errorFromBody(postResp, respBody)
 	}
 
 	if err := json.Unmarshal(respBody, resp); err != nil {
@@ -104,17 +159,27 @@ func (c *Client) post(endpoint string, req interface{}, resp interface{}) error
 }
 
 type TransactionRequest struct {
-	ClientID    string `json:"client_id"`
-	Secret      string `json:"secret"`
-	AccessToken string `json:"access_token"`
-	StartDate   string `json:"start_date"`
-	EndDate     string `json:"end_date"`
+	ClientID    string              `json:"client_id"`
+	Secret      string              `json:"secret"`
+	AccessToken string              `json:"access_token"`
+	StartDate   string              `json:"start_date"`
+	EndDate     string              `json:"end_date"`
+	Options     *TransactionOptions `json:"options,omitempty"`
+}
+
+// TransactionOptions are the optional paging and filtering parameters
+// for /transactions/get.  Plaid defaults to 100 transactions per page.
+type TransactionOptions struct {
+	Count      int      `json:"count,omitempty"`
+	Offset     int      `json:"offset,omitempty"`
+	AccountIDs []string `json:"account_ids,omitempty"`
 }
 
 type Balance struct {
-	Available float64 `json:"available"`
-	Current   float64 `json:"current"`
-	Limit     float64 `json:"limit"`
+	Available money.Amount `json:"available"`
+	Current   money.Amount `json:"current"`
+	Limit     money.Amount `json:"limit"`
+	Currency  string       `json:"iso_currency_code"`
 }
 
 type Account struct {
@@ -153,16 +218,21 @@ type Item struct {
 }
 
 type Transaction struct {
-	ID           string   `json:"transaction_id"`
-	AccountID    string   `json:"account_id"`
-	Category     []string `json:"category"`
-	CategoryID   string   `json:"category_id"`
-	Type         string   `json:"transaction_type"`
-	Amount       float64  `json:"amount"`
-	Date         string   `json:"date"`
-	Pending      bool     `json:"pending"`
-	AccountOwner string   `json:"account_owner"`
-	Name         string   `json:"name"`
+	ID         string   `json:"transaction_id"`
+	AccountID  string   `json:"account_id"`
+	Category   []string `json:"category"`
+	CategoryID string   `json:"category_id"`
+	Type       string   `json:"transaction_type"`
+	// Amount is positive for money leaving the account.
+	Amount   money.Amount `json:"amount"`
+	Currency string       `json:"iso_currency_code,omitempty"`
+	Date     string       `json:"date"`
+	Pending  bool         `json:"pending"`
+	// PendingTransactionID is set on a posted transaction to the ID of
+	// the pending one it replaces.
+	PendingTransactionID string `json:"pending_transaction_id,omitempty"`
+	AccountOwner         string `json:"account_owner"`
+	Name                 string `json:"name"`
 }
 
 type PublicTokenRequest struct {
@@ -172,11 +242,19 @@ type PublicTokenRequest struct {
 }
 
 type PublicTokenResponse struct {
-	RequestID         string        `json:"request_id"`
-	PublicToken string        `json:"public_token"`
+	RequestID   string `json:"request_id"`
+	PublicToken string `json:"public_token"`
+}
+
+// Transactions fetches a single page of transactions using Plaid's default
+// page size.  Use AllTransactions to get every transaction in the window.
+func (c *Client) Transactions(ctx context.Context, accessToken string, startDate, endDate time.Time) (TransactionResponse, error) {
+	return c.TransactionsWithOptions(ctx, accessToken, startDate, endDate, nil)
 }
 
-func (c *Client) Transactions(accessToken string, startDate, endDate time.Time) (TransactionResponse, error) {
+// TransactionsWithOptions fetches a single page of transactions, passing opts
+// through to Plaid.  A nil opts uses Plaid's defaults.
+func (c *Client) TransactionsWithOptions(ctx context.Context, accessToken string, startDate, endDate time.Time, opts *TransactionOptions) (TransactionResponse, error) {
 	endpoint := "/transactions/get"
 
 	request := TransactionRequest{
@@ -185,10 +263,11 @@ func (c *Client) Transactions(accessToken string, startDate, endDate time.Time)
 		AccessToken: accessToken,
 		StartDate:   startDate.Format(DateFmt),
 		EndDate:     endDate.Format(DateFmt),
+		Options:     opts,
 	}
 
 	resp := TransactionResponse{}
-	err := c.post(endpoint, request, &resp)
+	err := c.post(ctx, endpoint, request, &resp)
 	if err != nil {
 		return resp, err
 	}
@@ -196,8 +275,40 @@ func (c *Client) Transactions(accessToken string, startDate, endDate time.Time)
 	return resp, nil
 }
 
+// AllTransactions pages through /transactions/get until it has collected
+// TotalTransactions, so the result holds every transaction in the window.
+// Only AccountIDs is used from opts; Count and Offset are managed here.
+func (c *Client) AllTransactions(ctx context.Context, accessToken string, startDate, endDate time.Time, opts *TransactionOptions) (TransactionResponse, error) {
+	pageOpts := TransactionOptions{Count: MaxTransactionCount}
+	if opts != nil {
+		pageOpts.AccountIDs = opts.AccountIDs
+	}
+
+	resp, err := c.TransactionsWithOptions(ctx, accessToken, startDate, endDate, &pageOpts)
+	if err != nil {
+		return resp, err
+	}
+
+	for len(resp.Transactions) < int(resp.TotalTransactions) {
+		pageOpts.Offset = len(resp.Transactions)
+
+		page, err := c.TransactionsWithOptions(ctx, accessToken, startDate, endDate, &pageOpts)
+		if err != nil {
+			return resp, err
+		}
+
+		// Guard against looping forever if the total shrinks underneath us.
+		if len(page.Transactions) == 0 {
+			break
+		}
+
+		resp.Transactions = append(resp.Transactions, page.Transactions...)
+	}
+
+	return resp, nil
+}
 
-func (c *Client) CreatePublicToken(accessToken string) (PublicTokenResponse, error) {
+func (c *Client) CreatePublicToken(ctx context.Context, accessToken string) (PublicTokenResponse, error) {
 	endpoint := "/item/public_token/create"
 
 	request := PublicTokenRequest{
@@ -207,7 +318,7 @@ func (c *Client) CreatePublicToken(accessToken string) (PublicTokenResponse, err
 	}
 
 	resp := PublicTokenResponse{}
-	err := c.post(endpoint, request, &resp)
+	err := c.post(ctx, endpoint, request, &resp)
 	if err != nil {
 		return resp, err
 	}
@@ -227,7 +338,7 @@ type ExchangeResponse struct {
 	ErrorResponse
 }
 
-func (c *Client) Exchange(publicToken string) (ExchangeResponse, error) {
+func (c *Client) Exchange(ctx context.Context, publicToken string) (ExchangeResponse, error) {
 	endpoint := "/item/public_token/exchange"
 
 	request := ExchangeRequest{
@@ -237,13 +348,13 @@ func (c *Client) Exchange(publicToken string) (ExchangeResponse, error) {
 	}
 
 	resp := ExchangeResponse{}
-	err := c.post(endpoint, request, &resp)
+	err := c.post(ctx, endpoint, request, &resp)
 	if err != nil {
 		return resp, err
 	}
 
 	if resp.Message != "" {
-		return resp, fmt.Errorf(resp.Message)
+		return resp, errors.New(resp.Message)
 	}
 
 	return resp, nil
@@ -0,0 +1,146 @@
+package plaid_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pcarleton/cashcoach/api/money"
+	"github.com/pcarleton/cashcoach/api/plaid"
+	"github.com/pcarleton/cashcoach/api/plaid/plaidtest"
+)
+
+const testToken = "access-sandbox-paging"
+
+// pagingFixtures has one item with n transactions, one a day going back
+// from 2017-12-31.
+func pagingFixtures(n int) *plaidtest.Fixtures {
+	item := plaidtest.ItemFixture{
+		AccessToken: testToken,
+		Item:        plaid.Item{ItemID: "item-paging"},
+		Accounts:    []plaid.Account{{ID: "acct-1"}},
+	}
+
+	last := time.Date(2017, 12, 31, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < n; i++ {
+		item.Transactions = append(item.Transactions, plaid.Transaction{
+			ID:        fmt.Sprintf("txn-%04d", i),
+			AccountID: "acct-1",
+			Amount:    money.Cents(int64(i + 1)),
+			Date:      last.AddDate(0, 0, -i).Format(plaid.DateFmt),
+		})
+	}
+
+	return &plaidtest.Fixtures{ClientID: "id", Secret: "secret", Items: []plaidtest.ItemFixture{item}}
+}
+
+func testClient(url string) plaid.Client {
+	client := plaid.NewClient("id", "secret", url)
+	client.SetRetryPolicy(plaid.NoRetries)
+	client.SetRateLimit(0, 0)
+	return client
+}
+
+var (
+	windowStart = time.Date(2010, 1, 1, 0, 0, 0, 0, time.UTC)
+	windowEnd   = time.Date(2017, 12, 31, 0, 0, 0, 0, time.UTC)
+)
+
+func checkUnique(t *testing.T, txns []plaid.Transaction) {
+	t.Helper()
+
+	seen := make(map[string]bool)
+	for _, txn := range txns {
+		if seen[txn.ID] {
+			t.Fatalf("transaction %s returned twice", txn.ID)
+		}
+		seen[txn.ID] = true
+	}
+}
+
+func TestAllTransactionsPages(t *testing.T) {
+	srv := plaidtest.Start(pagingFixtures(1203))
+	defer srv.Close()
+
+	client := testClient(srv.URL)
+	resp, err := client.AllTransactions(context.Background(), testToken, windowStart, windowEnd, nil)
+	if err != nil {
+		t.Fatalf("AllTransactions: %v", err)
+	}
+
+	if len(resp.Transactions) != 1203 || resp.TotalTransactions != 1203 {
+		t.Fatalf("got %d transactions of %d, want 1203", len(resp.Transactions), resp.TotalTransactions)
+	}
+	checkUnique(t, resp.Transactions)
+}
+
+func TestAllTransactionsSinglePage(t *testing.T) {
+	requests := 0
+	fake := plaidtest.NewServer(pagingFixtures(3))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		fake.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	client := testClient(srv.URL)
+	resp, err := client.AllTransactions(context.Background(), testToken, windowStart, windowEnd, nil)
+	if err != nil {
+		t.Fatalf("AllTransactions: %v", err)
+	}
+
+	if len(resp.Transactions) != 3 || requests != 1 {
+		t.Errorf("got %d transactions in %d requests, want 3 in 1", len(resp.Transactions), requests)
+	}
+}
+
+// TestAllTransactionsTotalShrinks removes transactions after the first
+// page, the way Plaid can when pending transactions drop off mid-paging.
+// AllTransactions has to stop instead of asking for pages forever.
+func TestAllTransactionsTotalShrinks(t *testing.T) {
+	fixtures := pagingFixtures(1200)
+	fake := plaidtest.NewServer(fixtures)
+
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		fake.ServeHTTP(w, r)
+		if requests == 1 {
+			item := &fixtures.Items[0]
+			item.Transactions = item.Transactions[:600]
+		}
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	client := testClient(srv.URL)
+	resp, err := client.AllTransactions(ctx, testToken, windowStart, windowEnd, nil)
+	if err != nil {
+		t.Fatalf("AllTransactions: %v", err)
+	}
+
+	if len(resp.Transactions) != 600 {
+		t.Errorf("got %d transactions, want 600", len(resp.Transactions))
+	}
+	if requests != 3 {
+		t.Errorf("made %d requests, want 3", requests)
+	}
+	checkUnique(t, resp.Transactions)
+}
+
+func TestAllTransactionsError(t *testing.T) {
+	srv := plaidtest.Start(pagingFixtures(1))
+	defer srv.Close()
+
+	client := testClient(srv.URL)
+	_, err := client.AllTransactions(context.Background(), "access-sandbox-unknown", windowStart, windowEnd, nil)
+
+	if plaid.ErrorClassOf(err) != plaid.ClassInvalidInput {
+		t.Errorf("got %v (%s), want an invalid input error", err, plaid.ErrorClassOf(err))
+	}
+}
@@ -0,0 +1,495 @@
+// Package plaidtest is a fake Plaid API server for offline development.
+//
+// It serves the subset of endpoints that the plaid package uses out of a
+// fixtures file, so the CLI and API server can be pointed at it instead of
+// DevURL or SandboxURL:
+//
+//	srv := plaidtest.Start(fixtures)
+//	defer srv.Close()
+//	client := plaid.NewClient("id", "secret", srv.URL)
+package plaidtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pcarleton/cashcoach/api/plaid"
+)
+
+const (
+	defaultCount = 100
+	maxCount     = 500
+)
+
+// ItemFixture is everything the server knows about one linked item.
+type ItemFixture struct {
+	AccessToken string `json:"access_token"`
+
+	// PublicToken is accepted by /item/public_token/exchange and handed
+	// out by /item/public_token/create.
+	PublicToken string `json:"public_token"`
+
+	// LegacyToken is an old-style token accepted by
+	// /item/access_token/update_version.
+	LegacyToken string `json:"legacy_token,omitempty"`
+
+	Item         plaid.Item          `json:"item"`
+	Accounts     []plaid.Account     `json:"accounts"`
+	Transactions []plaid.Transaction `json:"transactions"`
+
+	// Error, if set, is returned by every endpoint for this item, e.g. to
+	// simulate ITEM_LOGIN_REQUIRED.
+	Error *plaid.ErrorResponse `json:"error,omitempty"`
+}
+
+type Fixtures struct {
+	// ClientID and Secret, if set, must match the credentials in every
+	// request.
+	ClientID string        `json:"client_id,omitempty"`
+	Secret   string        `json:"secret,omitempty"`
+	Items    []ItemFixture `json:"items"`
+
+	// WebhookKeys are served by /webhook_verification_key/get.
+	WebhookKeys []plaid.WebhookKey `json:"webhook_keys,omitempty"`
+}
+
+// LoadFixtures reads a fixtures file like testdata/fixtures.json.
+func LoadFixtures(path string) (*Fixtures, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	fixtures := &Fixtures{}
+	if err := json.Unmarshal(data, fixtures); err != nil {
+		return nil, fmt.Errorf("invalid fixtures in %s: %v", path, err)
+	}
+
+	return fixtures, nil
+}
+
+// Server is an http.Handler that behaves like the Plaid API.
+type Server struct {
+	mu       sync.Mutex
+	fixtures *Fixtures
+	mux      *http.ServeMux
+	requests int
+}
+
+func NewServer(fixtures *Fixtures) *Server {
+	s := &Server{fixtures: fixtures, mux: http.NewServeMux()}
+
+	s.handle("/transactions/get", s.transactionsGet)
+	s.handle("/transactions/sync", s.transactionsSync)
+	s.handle("/item/public_token/exchange", s.publicTokenExchange)
+	s.handle("/item/public_token/create", s.publicTokenCreate)
+	s.handle("/item/access_token/update_version", s.updateVersion)
+	s.handle("/accounts/get", s.accountsGet)
+	s.handle("/item/get", s.itemGet)
+	s.handle("/item/remove", s.itemRemove)
+	s.handle("/accounts/balance/get", s.accountsGet)
+	s.handle("/webhook_verification_key/get", s.webhookKeyGet)
+
+	return s
+}
+
+// Start runs a Server on a local httptest port.  Callers must Close it.
+func Start(fixtures *Fixtures) *httptest.Server {
+	return httptest.NewServer(NewServer(fixtures))
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// request is the union of every request body the server understands.
+type request struct {
+	ClientID      string                    `json:"client_id"`
+	Secret        string                    `json:"secret"`
+	AccessToken   string                    `json:"access_token"`
+	AccessTokenV1 string                    `json:"access_token_v1"`
+	PublicToken   string                    `json:"public_token"`
+	StartDate     string                    `json:"start_date"`
+	EndDate       string                    `json:"end_date"`
+	Cursor        string                    `json:"cursor"`
+	Count         int                       `json:"count"`
+	KeyID         string                    `json:"key_id"`
+	Options       *plaid.TransactionOptions `json:"options"`
+
+	requestID string
+}
+
+type endpoint func(req *request) (interface{}, *plaid.ErrorResponse)
+
+func (s *Server) handle(path string, fn endpoint) {
+	s.mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		req := &request{requestID: s.nextRequestID()}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			s.respond(w, req, nil, invalidRequest("request body is not valid JSON"))
+			return
+		}
+
+		if errResp := s.checkKeys(req); errResp != nil {
+			s.respond(w, req, nil, errResp)
+			return
+		}
+
+		s.mu.Lock()
+		resp, errResp := fn(req)
+		s.mu.Unlock()
+
+		s.respond(w, req, resp, errResp)
+	})
+}
+
+func (s *Server) nextRequestID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests++
+	return fmt.Sprintf("fake-%d", s.requests)
+}
+
+// respond writes resp, or errResp in the same envelope Plaid uses for
+// errors.
+func (s *Server) respond(w http.ResponseWriter, req *request, resp interface{}, errResp *plaid.ErrorResponse) {
+	status := http.StatusOK
+	body := resp
+	if errResp != nil {
+		status = http.StatusBadRequest
+		if errResp.Type == "RATE_LIMIT_EXCEEDED" {
+			status = http.StatusTooManyRequests
+		}
+		body = struct {
+			*plaid.ErrorResponse
+			RequestID string `json:"request_id"`
+		}{errResp, req.requestID}
+	}
+
+	js, err := json.Marshal(body)
+	if err != nil {
+		log.Printf("plaidtest: unable to marshal response: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(js)
+}
+
+func (s *Server) checkKeys(req *request) *plaid.ErrorResponse {
+	if s.fixtures.ClientID == "" && s.fixtures.Secret == "" {
+		return nil
+	}
+
+	if req.ClientID != s.fixtures.ClientID || req.Secret != s.fixtures.Secret {
+		return &plaid.ErrorResponse{
+			Type:    "INVALID_INPUT",
+			Code:    "INVALID_API_KEYS",
+			Message: "invalid client_id or secret provided",
+		}
+	}
+
+	return nil
+}
+
+func invalidRequest(msg string) *plaid.ErrorResponse {
+	return &plaid.ErrorResponse{
+		Type:    "INVALID_REQUEST",
+		Code:    "INVALID_FIELD",
+		Message: msg,
+	}
+}
+
+func (s *Server) itemByAccessToken(token string) (*ItemFixture, *plaid.ErrorResponse) {
+	for i := range s.fixtures.Items {
+		item := &s.fixtures.Items[i]
+		if item.AccessToken != token {
+			continue
+		}
+		if item.Error != nil {
+			return nil, item.Error
+		}
+		return item, nil
+	}
+
+	return nil, &plaid.ErrorResponse{
+		Type:    "INVALID_INPUT",
+		Code:    "INVALID_ACCESS_TOKEN",
+		Message: "provided access token is in an invalid format. expected format: access-<environment>-<identifier>",
+	}
+}
+
+func (s *Server) transactionsGet(req *request) (interface{}, *plaid.ErrorResponse) {
+	item, errResp := s.itemByAccessToken(req.AccessToken)
+	if errResp != nil {
+		return nil, errResp
+	}
+
+	start, err := time.Parse(plaid.DateFmt, req.StartDate)
+	if err != nil {
+		return nil, invalidRequest("start_date must be a valid date")
+	}
+	end, err := time.Parse(plaid.DateFmt, req.EndDate)
+	if err != nil {
+		return nil, invalidRequest("end_date must be a valid date")
+	}
+
+	opts := plaid.TransactionOptions{}
+	if req.Options != nil {
+		opts = *req.Options
+	}
+	if opts.Count == 0 {
+		opts.Count = defaultCount
+	}
+	if opts.Count < 0 || opts.Count > maxCount || opts.Offset < 0 {
+		return nil, invalidRequest("options.count must be between 1 and 500")
+	}
+
+	accountIDs := make(map[string]bool)
+	for _, id := range opts.AccountIDs {
+		accountIDs[id] = true
+	}
+
+	matched := make([]plaid.Transaction, 0)
+	for _, t := range sortedTransactions(item) {
+		date, err := time.Parse(plaid.DateFmt, t.Date)
+		if err != nil || date.Before(start) || date.After(end) {
+			continue
+		}
+		if len(accountIDs) > 0 && !accountIDs[t.AccountID] {
+			continue
+		}
+		matched = append(matched, t)
+	}
+
+	return &plaid.TransactionResponse{
+		Accounts:          item.Accounts,
+		Transactions:      page(matched, opts.Offset, opts.Count),
+		Item:              item.Item,
+		RequestID:         req.requestID,
+		TotalTransactions: int32(len(matched)),
+	}, nil
+}
+
+// transactionsSync treats the fixture transactions as an append-only
+// history, with the cursor being an offset into it.
+func (s *Server) transactionsSync(req *request) (interface{}, *plaid.ErrorResponse) {
+	item, errResp := s.itemByAccessToken(req.AccessToken)
+	if errResp != nil {
+		return nil, errResp
+	}
+
+	offset := 0
+	if req.Cursor != "" {
+		var err error
+		offset, err = strconv.Atoi(req.Cursor)
+		if err != nil || offset < 0 {
+			return nil, invalidRequest("cursor is not valid")
+		}
+	}
+
+	count := req.Count
+	if count == 0 {
+		count = defaultCount
+	}
+	if count < 0 || count > maxCount {
+		return nil, invalidRequest("count must be between 1 and 500")
+	}
+
+	all := sortedTransactions(item)
+	added := page(all, offset, count)
+	next := offset + len(added)
+
+	return &plaid.SyncResponse{
+		Accounts:   item.Accounts,
+		Added:      added,
+		Modified:   []plaid.Transaction{},
+		Removed:    []plaid.RemovedTransaction{},
+		NextCursor: strconv.Itoa(next),
+		HasMore:    next < len(all),
+		RequestID:  req.requestID,
+	}, nil
+}
+
+// itemGet succeeds even for items in an error state, reporting the error
+// on the item like Plaid does.
+func (s *Server) itemGet(req *request) (interface{}, *plaid.ErrorResponse) {
+	item := s.itemIgnoringError(req.AccessToken)
+	if item == nil {
+		_, errResp := s.itemByAccessToken(req.AccessToken)
+		return nil, errResp
+	}
+
+	resp := &plaid.ItemResponse{Item: item.Item, RequestID: req.requestID}
+	if item.Error != nil {
+		resp.Item.Error = *item.Error
+	}
+
+	return resp, nil
+}
+
+// itemRemove drops the item from the fixtures, so its access token stops
+// working.
+func (s *Server) itemRemove(req *request) (interface{}, *plaid.ErrorResponse) {
+	for i, item := range s.fixtures.Items {
+		if item.AccessToken == req.AccessToken {
+			s.fixtures.Items = append(s.fixtures.Items[:i:i], s.fixtures.Items[i+1:]...)
+			return &plaid.RemoveItemResponse{RequestID: req.requestID}, nil
+		}
+	}
+
+	_, errResp := s.itemByAccessToken(req.AccessToken)
+	return nil, errResp
+}
+
+// accountsGet serves both /accounts/get and /accounts/balance/get; the
+// fixtures only have one set of balances.
+func (s *Server) accountsGet(req *request) (interface{}, *plaid.ErrorResponse) {
+	item, errResp := s.itemByAccessToken(req.AccessToken)
+	if errResp != nil {
+		return nil, errResp
+	}
+
+	accounts := item.Accounts
+	if req.Options != nil && len(req.Options.AccountIDs) > 0 {
+		wanted := make(map[string]bool)
+		for _, id := range req.Options.AccountIDs {
+			wanted[id] = true
+		}
+
+		accounts = make([]plaid.Account, 0, len(wanted))
+		for _, acct := range item.Accounts {
+			if wanted[acct.ID] {
+				accounts = append(accounts, acct)
+			}
+		}
+	}
+
+	return &plaid.AccountsResponse{
+		Accounts:  accounts,
+		Item:      item.Item,
+		RequestID: req.requestID,
+	}, nil
+}
+
+type exchangeResponse struct {
+	AccessToken string `json:"access_token"`
+	ItemID      string `json:"item_id"`
+	RequestID   string `json:"request_id"`
+}
+
+func (s *Server) publicTokenExchange(req *request) (interface{}, *plaid.ErrorResponse) {
+	for _, item := range s.fixtures.Items {
+		if item.PublicToken != "" && item.PublicToken == req.PublicToken {
+			return &exchangeResponse{
+				AccessToken: item.AccessToken,
+				ItemID:      item.Item.ItemID,
+				RequestID:   req.requestID,
+			}, nil
+		}
+	}
+
+	return nil, &plaid.ErrorResponse{
+		Type:    "INVALID_INPUT",
+		Code:    "INVALID_PUBLIC_TOKEN",
+		Message: "provided public token is in an invalid format. expected format: public-<environment>-<identifier>",
+	}
+}
+
+func (s *Server) publicTokenCreate(req *request) (interface{}, *plaid.ErrorResponse) {
+	item, errResp := s.itemByAccessToken(req.AccessToken)
+	if errResp != nil {
+		// Update mode exists to repair broken items, so don't fail just
+		// because the item is in an error state.
+		item = s.itemIgnoringError(req.AccessToken)
+		if item == nil {
+			return nil, errResp
+		}
+	}
+
+	return &plaid.PublicTokenResponse{
+		RequestID:   req.requestID,
+		PublicToken: item.PublicToken,
+	}, nil
+}
+
+func (s *Server) itemIgnoringError(token string) *ItemFixture {
+	for i := range s.fixtures.Items {
+		if s.fixtures.Items[i].AccessToken == token {
+			return &s.fixtures.Items[i]
+		}
+	}
+	return nil
+}
+
+func (s *Server) updateVersion(req *request) (interface{}, *plaid.ErrorResponse) {
+	for _, item := range s.fixtures.Items {
+		if item.LegacyToken != "" && item.LegacyToken == req.AccessTokenV1 {
+			return &plaid.UpdateAccessTokenResponse{
+				AccessToken: item.AccessToken,
+				RequestID:   req.requestID,
+			}, nil
+		}
+	}
+
+	return nil, &plaid.ErrorResponse{
+		Type:    "INVALID_INPUT",
+		Code:    "INVALID_ACCESS_TOKEN",
+		Message: "provided access_token_v1 is not a valid legacy access token",
+	}
+}
+
+func (s *Server) webhookKeyGet(req *request) (interface{}, *plaid.ErrorResponse) {
+	for _, key := range s.fixtures.WebhookKeys {
+		if key.KeyID == req.KeyID {
+			return &plaid.WebhookKeyResponse{Key: key, RequestID: req.requestID}, nil
+		}
+	}
+
+	return nil, &plaid.ErrorResponse{
+		Type:    "INVALID_INPUT",
+		Code:    "INVALID_WEBHOOK_VERIFICATION_KEY_ID",
+		Message: "invalid key_id provided",
+	}
+}
+
+// sortedTransactions returns the item's transactions newest first, the
+// same order Plaid uses.
+func sortedTransactions(item *ItemFixture) []plaid.Transaction {
+	trans := make([]plaid.Transaction, len(item.Transactions))
+	copy(trans, item.Transactions)
+
+	sort.SliceStable(trans, func(i, j int) bool {
+		return trans[i].Date > trans[j].Date
+	})
+
+	return trans
+}
+
+func page(trans []plaid.Transaction, offset, count int) []plaid.Transaction {
+	if offset >= len(trans) {
+		return []plaid.Transaction{}
+	}
+
+	end := offset + count
+	if end > len(trans) {
+		end = len(trans)
+	}
+
+	return trans[offset:end]
+}
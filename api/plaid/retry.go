@@ -0,0 +1,160 @@
+package plaid
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/url"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy controls how retryable errors (see ApiError.Retryable) and
+// transport failures, like timeouts and reset connections, are retried.
+// Delays grow exponentially from BaseDelay up to MaxDelay, with full
+// jitter so many clients don't retry in lockstep.
+type RetryPolicy struct {
+	// MaxAttempts includes the first try; 1 disables retries.
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 4,
+	BaseDelay:   500 * time.Millisecond,
+	MaxDelay:    10 * time.Second,
+}
+
+// NoRetries sends every request exactly once.
+var NoRetries = RetryPolicy{MaxAttempts: 1}
+
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	ceiling := p.MaxDelay
+	if shift := uint(attempt); shift < 32 {
+		if d := p.BaseDelay << shift; d > 0 && d < ceiling {
+			ceiling = d
+		}
+	}
+
+	if ceiling <= 0 {
+		return 0
+	}
+
+	return time.Duration(rand.Int63n(int64(ceiling)))
+}
+
+// transient reports whether err is a transport failure that may not
+// happen again, like a timeout, a reset connection or a response cut off
+// part way.  Errors from the transport itself, like a cassette with no
+// matching interaction, aren't.
+func transient(err error) bool {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		// url.Error is a net.Error whatever it wraps, so look inside.
+		err = urlErr.Err
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return !dnsErr.IsNotFound
+	}
+
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// retryable reports whether the request that failed with err should be
+// sent again.
+func retryable(err error) bool {
+	var apiErr ApiError
+	if errors.As(err, &apiErr) {
+		return apiErr.Retryable()
+	}
+	return transient(err)
+}
+
+// sleep waits for d or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// The default rate limit keeps a single client comfortably under Plaid's
+// per-item limits.
+const (
+	DefaultRatePerSecond = 5
+	DefaultRateBurst     = 5
+)
+
+// rateLimiter is a token bucket shared by every copy of a Client.
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	burst    int
+	tokens   float64
+	last     time.Time
+}
+
+// newRateLimiter allows perSecond requests a second with bursts of burst.
+// A perSecond of zero or less disables limiting.
+func newRateLimiter(perSecond float64, burst int) *rateLimiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &rateLimiter{
+		interval: time.Duration(float64(time.Second) / perSecond),
+		burst:    burst,
+		tokens:   float64(burst),
+		last:     time.Now(),
+	}
+}
+
+// wait blocks until a request may be sent or ctx is done.
+func (l *rateLimiter) wait(ctx context.Context) error {
+	if l == nil {
+		return ctx.Err()
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += float64(now.Sub(l.last)) / float64(l.interval)
+	if l.tokens > float64(l.burst) {
+		l.tokens = float64(l.burst)
+	}
+	l.last = now
+
+	// Take the token now, even if it goes negative, so waiters queue up
+	// behind each other instead of racing.
+	l.tokens--
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens * float64(l.interval))
+	}
+	l.mu.Unlock()
+
+	if delay == 0 {
+		return ctx.Err()
+	}
+
+	return sleep(ctx, delay)
+}
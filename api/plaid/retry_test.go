@@ -0,0 +1,126 @@
+package plaid_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pcarleton/cashcoach/api/plaid"
+)
+
+var fastRetries = plaid.RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   time.Millisecond,
+	MaxDelay:    time.Millisecond,
+}
+
+// hangUp closes the connection without answering, the way a proxy or
+// load balancer dropping it would look.
+func hangUp(t *testing.T, w http.ResponseWriter) {
+	conn, _, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		t.Fatalf("Hijack: %v", err)
+	}
+	conn.Close()
+}
+
+func TestPostRetriesDroppedConnections(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests < 3 {
+			hangUp(t, w)
+			return
+		}
+		json.NewEncoder(w).Encode(plaid.AccountsResponse{
+			Accounts: []plaid.Account{{ID: "acct-1"}},
+		})
+	}))
+	defer srv.Close()
+
+	client := plaid.NewClient("id", "secret", srv.URL)
+	client.SetRetryPolicy(fastRetries)
+	client.SetRateLimit(0, 0)
+
+	resp, err := client.Accounts(context.Background(), "token")
+	if err != nil {
+		t.Fatalf("Accounts: %v", err)
+	}
+	if len(resp.Accounts) != 1 {
+		t.Errorf("got %d accounts, want 1", len(resp.Accounts))
+	}
+	if requests != 3 {
+		t.Errorf("sent %d requests, want 3", requests)
+	}
+}
+
+func TestPostGivesUpOnDroppedConnections(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		hangUp(t, w)
+	}))
+	defer srv.Close()
+
+	client := plaid.NewClient("id", "secret", srv.URL)
+	client.SetRetryPolicy(fastRetries)
+	client.SetRateLimit(0, 0)
+
+	if _, err := client.Accounts(context.Background(), "token"); err == nil {
+		t.Fatal("Accounts succeeded with every connection dropped")
+	}
+	if requests != fastRetries.MaxAttempts {
+		t.Errorf("sent %d requests, want %d", requests, fastRetries.MaxAttempts)
+	}
+}
+
+func TestPostDoesNotRetryCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		cancel()
+		hangUp(t, w)
+	}))
+	defer srv.Close()
+
+	client := plaid.NewClient("id", "secret", srv.URL)
+	client.SetRetryPolicy(fastRetries)
+	client.SetRateLimit(0, 0)
+
+	if _, err := client.Accounts(ctx, "token"); err == nil {
+		t.Fatal("Accounts succeeded after being canceled")
+	}
+	if requests != 1 {
+		t.Errorf("sent %d requests, want 1", requests)
+	}
+}
+
+func TestPostDoesNotRetryInvalidInput(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(plaid.ErrorResponse{
+			Type: "INVALID_INPUT",
+			Code: "INVALID_ACCESS_TOKEN",
+		})
+	}))
+	defer srv.Close()
+
+	client := plaid.NewClient("id", "secret", srv.URL)
+	client.SetRetryPolicy(fastRetries)
+	client.SetRateLimit(0, 0)
+
+	_, err := client.Accounts(context.Background(), "token")
+	if plaid.ErrorClassOf(err) != plaid.ClassInvalidInput {
+		t.Errorf("got %v, want an invalid input error", err)
+	}
+	if requests != 1 {
+		t.Errorf("sent %d requests, want 1", requests)
+	}
+}
@@ -0,0 +1,105 @@
+package plaid
+
+import (
+	"context"
+	"errors"
+)
+
+const (
+	// MaxSyncCount is the largest page /transactions/sync will return.
+	MaxSyncCount = 500
+
+	// syncMutationCode is returned when the item changes while we page
+	// through updates; Plaid asks that pagination restart from the
+	// original cursor.
+	syncMutationCode = "TRANSACTIONS_SYNC_MUTATION_DURING_PAGINATION"
+
+	// MaxSyncRestarts is how many times SyncAll restarts pagination after
+	// the item changes underneath it.  Restarting is needed for a
+	// consistent result, so it doesn't depend on the client's RetryPolicy.
+	MaxSyncRestarts = 5
+)
+
+type SyncRequest struct {
+	ClientID    string `json:"client_id"`
+	Secret      string `json:"secret"`
+	AccessToken string `json:"access_token"`
+	Cursor      string `json:"cursor,omitempty"`
+	Count       int    `json:"count,omitempty"`
+}
+
+type RemovedTransaction struct {
+	ID string `json:"transaction_id"`
+}
+
+type SyncResponse struct {
+	Accounts   []Account            `json:"accounts"`
+	Added      []Transaction        `json:"added"`
+	Modified   []Transaction        `json:"modified"`
+	Removed    []RemovedTransaction `json:"removed"`
+	NextCursor string               `json:"next_cursor"`
+	HasMore    bool                 `json:"has_more"`
+	RequestID  string               `json:"request_id"`
+}
+
+// TransactionsSync fetches one page of changes since cursor.  An empty
+// cursor starts from the beginning of the item's history.
+func (c *Client) TransactionsSync(ctx context.Context, accessToken, cursor string, count int) (SyncResponse, error) {
+	endpoint := "/transactions/sync"
+
+	request := SyncRequest{
+		ClientID:    c.clientID,
+		Secret:      c.secret,
+		AccessToken: accessToken,
+		Cursor:      cursor,
+		Count:       count,
+	}
+
+	resp := SyncResponse{}
+	err := c.post(ctx, endpoint, request, &resp)
+	if err != nil {
+		return resp, err
+	}
+
+	return resp, nil
+}
+
+// SyncAll follows HasMore until every change since cursor has been
+// collected.  The returned NextCursor is the one to persist for the next
+// call.  Nothing should be persisted if an error is returned.  If the item
+// changes while paging, it starts over from cursor, up to MaxSyncRestarts
+// times.
+func (c *Client) SyncAll(ctx context.Context, accessToken, cursor string) (SyncResponse, error) {
+	for restarts := 0; ; restarts++ {
+		resp, err := c.syncFrom(ctx, accessToken, cursor)
+
+		var apiErr ApiError
+		if errors.As(err, &apiErr) && apiErr.Response.Code == syncMutationCode &&
+			restarts < MaxSyncRestarts && ctx.Err() == nil {
+			continue
+		}
+
+		return resp, err
+	}
+}
+
+func (c *Client) syncFrom(ctx context.Context, accessToken, cursor string) (SyncResponse, error) {
+	result := SyncResponse{NextCursor: cursor, HasMore: true}
+
+	for result.HasMore {
+		page, err := c.TransactionsSync(ctx, accessToken, result.NextCursor, MaxSyncCount)
+		if err != nil {
+			return result, err
+		}
+
+		result.Accounts = page.Accounts
+		result.Added = append(result.Added, page.Added...)
+		result.Modified = append(result.Modified, page.Modified...)
+		result.Removed = append(result.Removed, page.Removed...)
+		result.NextCursor = page.NextCursor
+		result.HasMore = page.HasMore
+		result.RequestID = page.RequestID
+	}
+
+	return result, nil
+}
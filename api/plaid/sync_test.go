@@ -0,0 +1,94 @@
+package plaid_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pcarleton/cashcoach/api/plaid"
+)
+
+// TestSyncAllRestartsWithoutRetries checks that a mutation during
+// pagination restarts from the original cursor even when the client
+// doesn't retry failed requests.
+func TestSyncAllRestartsWithoutRetries(t *testing.T) {
+	var cursors []string
+	mutations := 2
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req plaid.SyncRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		cursors = append(cursors, req.Cursor)
+
+		if req.Cursor == "page-2" && mutations > 0 {
+			mutations--
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(plaid.ErrorResponse{
+				Type: "TRANSACTIONS_ERROR",
+				Code: "TRANSACTIONS_SYNC_MUTATION_DURING_PAGINATION",
+			})
+			return
+		}
+
+		resp := plaid.SyncResponse{NextCursor: "page-2", HasMore: true,
+			Added: []plaid.Transaction{{ID: "txn-" + req.Cursor}}}
+		if req.Cursor == "page-2" {
+			resp.NextCursor, resp.HasMore = "done", false
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer srv.Close()
+
+	client := plaid.NewClient("id", "secret", srv.URL)
+	client.SetRetryPolicy(plaid.NoRetries)
+	client.SetRateLimit(0, 0)
+
+	resp, err := client.SyncAll(context.Background(), "token", "start")
+	if err != nil {
+		t.Fatalf("SyncAll: %v", err)
+	}
+
+	if resp.NextCursor != "done" {
+		t.Errorf("NextCursor = %q, want done", resp.NextCursor)
+	}
+	// Only the last pass's changes count.
+	if len(resp.Added) != 2 {
+		t.Errorf("got %d added, want 2: %+v", len(resp.Added), resp.Added)
+	}
+
+	want := []string{"start", "page-2", "start", "page-2", "start", "page-2"}
+	if len(cursors) != len(want) {
+		t.Fatalf("requested cursors %v, want %v", cursors, want)
+	}
+	for i := range want {
+		if cursors[i] != want[i] {
+			t.Fatalf("requested cursors %v, want %v", cursors, want)
+		}
+	}
+}
+
+func TestSyncAllGivesUpAfterMaxRestarts(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(plaid.ErrorResponse{
+			Type: "TRANSACTIONS_ERROR",
+			Code: "TRANSACTIONS_SYNC_MUTATION_DURING_PAGINATION",
+		})
+	}))
+	defer srv.Close()
+
+	client := plaid.NewClient("id", "secret", srv.URL)
+	client.SetRetryPolicy(plaid.NoRetries)
+	client.SetRateLimit(0, 0)
+
+	if _, err := client.SyncAll(context.Background(), "token", ""); err == nil {
+		t.Fatal("SyncAll succeeded, want the mutation error")
+	}
+	if requests != plaid.MaxSyncRestarts+1 {
+		t.Errorf("made %d requests, want %d", requests, plaid.MaxSyncRestarts+1)
+	}
+}
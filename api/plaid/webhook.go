@@ -0,0 +1,98 @@
+package plaid
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+)
+
+type WebhookKeyRequest struct {
+	ClientID string `json:"client_id"`
+	Secret   string `json:"secret"`
+	KeyID    string `json:"key_id"`
+}
+
+// WebhookKey is a JSON Web Key that Plaid signs webhooks with.
+type WebhookKey struct {
+	Alg       string `json:"alg"`
+	Crv       string `json:"crv"`
+	KeyID     string `json:"kid"`
+	Kty       string `json:"kty"`
+	Use       string `json:"use"`
+	X         string `json:"x"`
+	Y         string `json:"y"`
+	CreatedAt int64  `json:"created_at"`
+
+	// ExpiredAt is set once Plaid has stopped signing with the key.
+	ExpiredAt *int64 `json:"expired_at"`
+}
+
+type WebhookKeyResponse struct {
+	Key       WebhookKey `json:"key"`
+	RequestID string     `json:"request_id"`
+}
+
+// WebhookVerificationKey fetches the key with the ID from the kid header
+// of a webhook's Plaid-Verification JWT.
+func (c *Client) WebhookVerificationKey(ctx context.Context, keyID string) (WebhookKey, error) {
+	endpoint := "/webhook_verification_key/get"
+
+	request := WebhookKeyRequest{
+		ClientID: c.clientID,
+		Secret:   c.secret,
+		KeyID:    keyID,
+	}
+
+	resp := WebhookKeyResponse{}
+	err := c.post(ctx, endpoint, request, &resp)
+	if err != nil {
+		return WebhookKey{}, err
+	}
+
+	return resp.Key, nil
+}
+
+// PublicKey decodes k, which Plaid only issues as ES256 P-256 keys.
+func (k WebhookKey) PublicKey() (*ecdsa.PublicKey, error) {
+	if k.Kty != "EC" || k.Crv != "P-256" {
+		return nil, fmt.Errorf("webhook key %s is %s %s, expected EC P-256", k.KeyID, k.Kty, k.Crv)
+	}
+
+	x, err := base64.RawURLEncoding.DecodeString(k.X)
+	if err != nil {
+		return nil, fmt.Errorf("webhook key %s has invalid x: %v", k.KeyID, err)
+	}
+	y, err := base64.RawURLEncoding.DecodeString(k.Y)
+	if err != nil {
+		return nil, fmt.Errorf("webhook key %s has invalid y: %v", k.KeyID, err)
+	}
+
+	key := &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(x),
+		Y:     new(big.Int).SetBytes(y),
+	}
+	if !key.Curve.IsOnCurve(key.X, key.Y) {
+		return nil, fmt.Errorf("webhook key %s isn't a point on P-256", k.KeyID)
+	}
+
+	return key, nil
+}
+
+// NewWebhookKey encodes key as a JWK, like the ones Plaid serves.  It's
+// for fakes like plaidtest.
+func NewWebhookKey(keyID string, key *ecdsa.PublicKey) WebhookKey {
+	size := (key.Curve.Params().BitSize + 7) / 8
+	return WebhookKey{
+		Alg:   "ES256",
+		Crv:   "P-256",
+		KeyID: keyID,
+		Kty:   "EC",
+		Use:   "sig",
+		X:     base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, size))),
+		Y:     base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, size))),
+	}
+}
@@ -0,0 +1,309 @@
+// Package rules assigns our own categories, tags and splits to
+// transactions, in place of the category Plaid guessed.
+//
+// Rules are tried in order and the first one whose conditions all hold
+// wins.  A rule with no match conditions is rejected, so a catch-all has
+// to say so, e.g. with name_regex: ".".
+//
+//	rules:
+//	  - name: groceries
+//	    name_regex: "safeway|trader joe"
+//	    category: Food:Groceries
+//	    split: [paul, taylor]
+//	  - name: weekend dining
+//	    plaid_category: "Food and Drink:Restaurants"
+//	    weekdays: [sat, sun]
+//	    min_amount: 20
+//	    category: Food:Dining Out
+//	    tags: [weekend]
+//
+// Rules are only read from the rules key of the config, which the API
+// server and the cash commands each load.  They aren't kept in storage,
+// so they're shared by everyone using a server, and changing them means
+// editing the config and restarting.
+package rules
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pcarleton/cashcoach/api/money"
+	"github.com/pcarleton/cashcoach/api/plaid"
+)
+
+// Rule is one entry in the rules config.  Amounts are Plaid's: positive
+// for money leaving the account.  Both ends of the amount range are
+// inclusive.
+type Rule struct {
+	Name string `json:"name" mapstructure:"name"`
+
+	// Conditions.  Text comparisons ignore case.
+	NameRegex     string   `json:"name_regex,omitempty" mapstructure:"name_regex"`
+	MinAmount     *float64 `json:"min_amount,omitempty" mapstructure:"min_amount"`
+	MaxAmount     *float64 `json:"max_amount,omitempty" mapstructure:"max_amount"`
+	Accounts      []string `json:"accounts,omitempty" mapstructure:"accounts"`
+	PlaidCategory string   `json:"plaid_category,omitempty" mapstructure:"plaid_category"`
+	Weekdays      []string `json:"weekdays,omitempty" mapstructure:"weekdays"`
+
+	// Actions.  Category is a colon-separated path like Food:Groceries.
+	// Split names the people who share the transaction.
+	Category string   `json:"category,omitempty" mapstructure:"category"`
+	Tags     []string `json:"tags,omitempty" mapstructure:"tags"`
+	Split    []string `json:"split,omitempty" mapstructure:"split"`
+}
+
+// Transaction is what rules look at.
+type Transaction struct {
+	Name   string
+	Amount money.Amount
+
+	// Account is the nickname of the account the transaction is in.
+	Account string
+
+	// Category is Plaid's category path, most general first.
+	Category []string
+	Date     time.Time
+}
+
+// FromPlaid adapts a Plaid transaction from the account with the given
+// nickname.
+func FromPlaid(t plaid.Transaction, account string) Transaction {
+	date, _ := time.Parse(plaid.DateFmt, t.Date)
+
+	return Transaction{
+		Name:     t.Name,
+		Amount:   t.Amount,
+		Account:  account,
+		Category: t.Category,
+		Date:     date,
+	}
+}
+
+// Result is how a transaction was categorized.  Rule is empty when no
+// rule matched, in which case Category is Plaid's.
+type Result struct {
+	Rule     string   `json:"rule,omitempty"`
+	Category string   `json:"category"`
+	Tags     []string `json:"tags"`
+	Split    []string `json:"split"`
+}
+
+// Engine holds a compiled rule list.  A nil *Engine has no rules.
+type Engine struct {
+	rules    []Rule
+	compiled []compiled
+}
+
+type compiled struct {
+	name     *regexp.Regexp
+	min      *money.Amount
+	max      *money.Amount
+	category []string
+	weekdays map[time.Weekday]bool
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+func parseWeekday(day string) (time.Weekday, error) {
+	day = strings.ToLower(strings.TrimSpace(day))
+	if len(day) >= 3 {
+		if wd, ok := weekdays[day[:3]]; ok && strings.HasPrefix(strings.ToLower(wd.String()), day) {
+			return wd, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown weekday %q", day)
+}
+
+// New checks and compiles rules.  Errors name the rule by its position
+// and name.  Unnamed rules are called "rule N".  Names must be unique,
+// since a Result only says which rule matched by name.
+func New(rules []Rule) (*Engine, error) {
+	e := &Engine{rules: make([]Rule, len(rules)), compiled: make([]compiled, len(rules))}
+	copy(e.rules, rules)
+
+	seen := make(map[string]int)
+	for i := range e.rules {
+		r := &e.rules[i]
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("rule %d", i+1)
+		}
+
+		if prev, ok := seen[r.Name]; ok {
+			return nil, fmt.Errorf("rule %d (%s): name already used by rule %d", i+1, r.Name, prev)
+		}
+		seen[r.Name] = i + 1
+
+		c, err := compile(*r)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d (%s): %v", i+1, r.Name, err)
+		}
+		e.compiled[i] = c
+	}
+
+	return e, nil
+}
+
+func compile(r Rule) (compiled, error) {
+	c := compiled{}
+
+	if r.NameRegex == "" && r.MinAmount == nil && r.MaxAmount == nil && len(r.Accounts) == 0 &&
+		r.PlaidCategory == "" && len(r.Weekdays) == 0 {
+		return c, fmt.Errorf("no conditions to match on")
+	}
+
+	if r.Category == "" && len(r.Tags) == 0 && len(r.Split) == 0 {
+		return c, fmt.Errorf("no category, tags or split to assign")
+	}
+
+	if r.MinAmount != nil && r.MaxAmount != nil && *r.MinAmount > *r.MaxAmount {
+		return c, fmt.Errorf("min_amount %v is more than max_amount %v", *r.MinAmount, *r.MaxAmount)
+	}
+
+	if r.MinAmount != nil {
+		min := money.FromFloat(*r.MinAmount)
+		c.min = &min
+	}
+
+	if r.MaxAmount != nil {
+		max := money.FromFloat(*r.MaxAmount)
+		c.max = &max
+	}
+
+	if r.NameRegex != "" {
+		re, err := regexp.Compile("(?i)" + r.NameRegex)
+		if err != nil {
+			return c, fmt.Errorf("bad name_regex: %v", err)
+		}
+		c.name = re
+	}
+
+	if r.PlaidCategory != "" {
+		c.category = strings.Split(r.PlaidCategory, ":")
+	}
+
+	if len(r.Weekdays) > 0 {
+		c.weekdays = make(map[time.Weekday]bool)
+		for _, day := range r.Weekdays {
+			wd, err := parseWeekday(day)
+			if err != nil {
+				return c, err
+			}
+			c.weekdays[wd] = true
+		}
+	}
+
+	return c, nil
+}
+
+// Rules returns the rules, in the order they are tried.
+func (e *Engine) Rules() []Rule {
+	if e == nil {
+		return nil
+	}
+	return e.rules
+}
+
+// Match returns the first rule that matches t, or nil.
+func (e *Engine) Match(t Transaction) *Rule {
+	if e == nil {
+		return nil
+	}
+
+	for i := range e.rules {
+		if e.matches(i, t) {
+			return &e.rules[i]
+		}
+	}
+	return nil
+}
+
+func (e *Engine) matches(i int, t Transaction) bool {
+	r, c := e.rules[i], e.compiled[i]
+
+	if c.name != nil && !c.name.MatchString(t.Name) {
+		return false
+	}
+
+	if c.min != nil && t.Amount < *c.min {
+		return false
+	}
+
+	if c.max != nil && t.Amount > *c.max {
+		return false
+	}
+
+	if len(r.Accounts) > 0 && !containsFold(r.Accounts, t.Account) {
+		return false
+	}
+
+	if c.category != nil && !hasPrefixFold(t.Category, c.category) {
+		return false
+	}
+
+	if c.weekdays != nil && (t.Date.IsZero() || !c.weekdays[t.Date.Weekday()]) {
+		return false
+	}
+
+	return true
+}
+
+// Categorize applies the first matching rule to t.  Without a match, the
+// result carries Plaid's category.
+func (e *Engine) Categorize(t Transaction) Result {
+	result := Result{
+		Category: strings.Join(t.Category, ":"),
+		Tags:     []string{},
+		Split:    []string{},
+	}
+
+	r := e.Match(t)
+	if r == nil {
+		return result
+	}
+
+	result.Rule = r.Name
+	if r.Category != "" {
+		result.Category = r.Category
+	}
+	// Copied, so changing a result can't change the rule.
+	if r.Tags != nil {
+		result.Tags = append([]string{}, r.Tags...)
+	}
+	if r.Split != nil {
+		result.Split = append([]string{}, r.Split...)
+	}
+
+	return result
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasPrefixFold(path, prefix []string) bool {
+	if len(prefix) > len(path) {
+		return false
+	}
+
+	for i := range prefix {
+		if !strings.EqualFold(strings.TrimSpace(path[i]), strings.TrimSpace(prefix[i])) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,167 @@
+package rules_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pcarleton/cashcoach/api/money"
+	"github.com/pcarleton/cashcoach/api/rules"
+)
+
+func TestDuplicateNames(t *testing.T) {
+	cases := []struct {
+		name  string
+		rules []rules.Rule
+	}{
+		{"same name", []rules.Rule{
+			{Name: "coffee", NameRegex: "starbucks", Category: "Food:Coffee"},
+			{Name: "coffee", NameRegex: "peets", Category: "Food:Coffee"},
+		}},
+		{"default name", []rules.Rule{
+			{NameRegex: "starbucks", Category: "Food:Coffee"},
+			{Name: "rule 1", NameRegex: "peets", Category: "Food:Coffee"},
+		}},
+	}
+
+	for _, c := range cases {
+		_, err := rules.New(c.rules)
+		if err == nil || !strings.Contains(err.Error(), "already used by rule 1") {
+			t.Errorf("%s: got %v, want a duplicate name error", c.name, err)
+		}
+	}
+}
+
+func TestCategorizeNamesRule(t *testing.T) {
+	engine, err := rules.New([]rules.Rule{
+		{Name: "coffee", NameRegex: "starbucks", Category: "Food:Coffee"},
+		{NameRegex: "peets", Category: "Food:Coffee"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]string{"STARBUCKS 123": "coffee", "Peets": "rule 2", "Safeway": ""} {
+		if got := engine.Categorize(rules.Transaction{Name: name}).Rule; got != want {
+			t.Errorf("%s matched %q, want %q", name, got, want)
+		}
+	}
+}
+
+func amount(f float64) *float64 {
+	return &f
+}
+
+// 2018-03-03 was a Saturday.
+var saturday = time.Date(2018, 3, 3, 0, 0, 0, 0, time.UTC)
+
+func TestMatch(t *testing.T) {
+	engine, err := rules.New([]rules.Rule{
+		{Name: "big coffee", NameRegex: "starbucks", MinAmount: amount(20), Category: "Food:Catering"},
+		{Name: "coffee", NameRegex: "starbucks|peets", Category: "Food:Coffee"},
+		{Name: "small", MinAmount: amount(-5), MaxAmount: amount(5), Accounts: []string{"Visa"}, Category: "Misc"},
+		{Name: "weekend dining", PlaidCategory: "food and drink:restaurants", Weekdays: []string{"sat", "Sunday"},
+			Category: "Food:Dining Out"},
+		{Name: "refunds", MaxAmount: amount(-0.01), Category: "Refunds"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name string
+		txn  rules.Transaction
+		want string
+	}{
+		// The first matching rule wins, even if a later one matches too.
+		{"earlier rule wins", rules.Transaction{Name: "STARBUCKS", Amount: money.MustParse("25")}, "big coffee"},
+		{"falls through", rules.Transaction{Name: "Starbucks", Amount: money.MustParse("4.50")}, "coffee"},
+
+		// Both ends of an amount range are inclusive.
+		{"at min", rules.Transaction{Name: "x", Amount: money.MustParse("-5"), Account: "visa"}, "small"},
+		{"at max", rules.Transaction{Name: "x", Amount: money.MustParse("5"), Account: "visa"}, "small"},
+		{"over max", rules.Transaction{Name: "x", Amount: money.MustParse("5.01"), Account: "visa"}, ""},
+		{"other account", rules.Transaction{Name: "x", Amount: money.MustParse("1"), Account: "checking"}, ""},
+
+		// Plaid categories match by prefix, ignoring case.
+		{"weekend", rules.Transaction{Name: "x", Amount: money.MustParse("30"), Date: saturday,
+			Category: []string{"Food and Drink", "Restaurants", "Sushi"}}, "weekend dining"},
+		{"weekday", rules.Transaction{Name: "x", Amount: money.MustParse("30"), Date: saturday.AddDate(0, 0, 2),
+			Category: []string{"Food and Drink", "Restaurants"}}, ""},
+		{"no date", rules.Transaction{Name: "x", Amount: money.MustParse("30"),
+			Category: []string{"Food and Drink", "Restaurants"}}, ""},
+		{"other category", rules.Transaction{Name: "x", Amount: money.MustParse("30"), Date: saturday,
+			Category: []string{"Food and Drink"}}, ""},
+
+		{"refund", rules.Transaction{Name: "x", Amount: money.MustParse("-20")}, "refunds"},
+	}
+
+	for _, c := range cases {
+		got := ""
+		if r := engine.Match(c.txn); r != nil {
+			got = r.Name
+		}
+		if got != c.want {
+			t.Errorf("%s: matched %q, want %q", c.name, got, c.want)
+		}
+	}
+}
+
+func TestCategorizeResult(t *testing.T) {
+	engine, err := rules.New([]rules.Rule{
+		{Name: "groceries", NameRegex: "safeway", Category: "Food:Groceries", Tags: []string{"food"},
+			Split: []string{"paul", "taylor"}},
+		{Name: "tag only", NameRegex: "amazon", Tags: []string{"online"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := engine.Categorize(rules.Transaction{Name: "SAFEWAY #12"})
+	want := rules.Result{Rule: "groceries", Category: "Food:Groceries", Tags: []string{"food"},
+		Split: []string{"paul", "taylor"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	// Changing a result leaves the rule alone.
+	got.Tags[0] = "changed"
+	got.Split[0] = "changed"
+	if again := engine.Categorize(rules.Transaction{Name: "SAFEWAY #12"}); !reflect.DeepEqual(again, want) {
+		t.Errorf("after changing a result got %+v", again)
+	}
+
+	// A rule without a category keeps Plaid's.
+	got = engine.Categorize(rules.Transaction{Name: "Amazon", Category: []string{"Shops", "Digital"}})
+	if got.Category != "Shops:Digital" || !reflect.DeepEqual(got.Tags, []string{"online"}) || len(got.Split) != 0 {
+		t.Errorf("tag-only rule gave %+v", got)
+	}
+
+	// No match, and a nil engine, give Plaid's category and empty lists.
+	var none *rules.Engine
+	for _, e := range []*rules.Engine{engine, none} {
+		got := e.Categorize(rules.Transaction{Name: "Shell", Category: []string{"Travel", "Gas"}})
+		if got.Rule != "" || got.Category != "Travel:Gas" || got.Tags == nil || got.Split == nil {
+			t.Errorf("unmatched gave %+v", got)
+		}
+	}
+}
+
+func TestNewRejects(t *testing.T) {
+	cases := map[string]rules.Rule{
+		"no conditions":  {Name: "x", Category: "Misc"},
+		"no actions":     {Name: "x", NameRegex: "x"},
+		"bad regex":      {Name: "x", NameRegex: "(", Category: "Misc"},
+		"min over max":   {Name: "x", MinAmount: amount(10), MaxAmount: amount(5), Category: "Misc"},
+		"unknown day":    {Name: "x", Weekdays: []string{"someday"}, Category: "Misc"},
+		"ambiguous day":  {Name: "x", Weekdays: []string{"s"}, Category: "Misc"},
+		"misspelled day": {Name: "x", Weekdays: []string{"satruday"}, Category: "Misc"},
+	}
+
+	for name, r := range cases {
+		if _, err := rules.New([]rules.Rule{r}); err == nil {
+			t.Errorf("%s: New passed", name)
+		}
+	}
+}
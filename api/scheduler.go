@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pcarleton/cashcoach/api/auth"
+	"github.com/pcarleton/cashcoach/api/clock"
+	"github.com/pcarleton/cashcoach/api/plaid"
+	"github.com/pcarleton/cashcoach/api/storage"
+)
+
+// schedulerTick is how often the scheduler looks for accounts that are
+// due.  It only bounds how late a sync can start; Interval decides how
+// often each account is synced.
+const schedulerTick = time.Minute
+
+// scheduleConfig is read from the sync.* config keys.
+type scheduleConfig struct {
+	// Interval is how long after a successful sync an account is synced
+	// again.
+	Interval time.Duration
+
+	// Workers bounds how many syncs run at once.
+	Workers int
+
+	// Backoff is the wait after an account's first failed sync.  It
+	// doubles with each failure in a row, up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+var defaultScheduleConfig = scheduleConfig{
+	Interval:   6 * time.Hour,
+	Workers:    4,
+	Backoff:    5 * time.Minute,
+	MaxBackoff: 24 * time.Hour,
+}
+
+// scheduler periodically queues a sync for every person's accounts that
+// are due one, and leaves the syncing to the syncer.
+type scheduler struct {
+	store  storage.Storage
+	syncs  *syncer
+	clock  clock.Clock
+	config scheduleConfig
+
+	mu       sync.Mutex
+	lastTick time.Time
+	tickErr  string
+}
+
+func newScheduler(store storage.Storage, syncs *syncer, clk clock.Clock, config scheduleConfig) *scheduler {
+	return &scheduler{store: store, syncs: syncs, clock: clk, config: config}
+}
+
+// run checks for due accounts straight away and then every schedulerTick,
+// until ctx is done.
+func (s *scheduler) run(ctx context.Context) {
+	for {
+		s.tick()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.clock.After(schedulerTick):
+		}
+	}
+}
+
+func (s *scheduler) tick() {
+	now := s.clock.Now()
+
+	people, err := s.store.All()
+
+	s.mu.Lock()
+	s.lastTick = now
+	s.tickErr = ""
+	if err != nil {
+		s.tickErr = err.Error()
+	}
+	s.mu.Unlock()
+
+	if err != nil {
+		log.Printf("Scheduler unable to list people: %v", err)
+		return
+	}
+
+	for _, p := range people {
+		for _, acct := range p.Accounts {
+			if acct.ItemID == "" || now.Before(s.nextRun(p.Email, acct)) {
+				continue
+			}
+
+			job := syncJob{Email: p.Email, ItemID: acct.ItemID}
+			if queued, running := s.syncs.state(job); queued || running {
+				continue
+			}
+
+			if !s.syncs.enqueue(job) {
+				log.Printf("Sync queue is full; %s for %s will be retried next tick", acct.Name, p.Email)
+				return
+			}
+		}
+	}
+}
+
+// lastRun is what the scheduler knows about an account's last sync: the
+// syncer's status if it has synced since startup, or else what was saved
+// on the account.
+func (s *scheduler) lastRun(email string, acct storage.Account) syncStatus {
+	status, ok := s.syncs.lastStatus(syncJob{Email: email, ItemID: acct.ItemID})
+	if ok {
+		return status
+	}
+
+	status = syncStatus{
+		LastRun: acct.LastSync,
+		Error:   acct.SyncError,
+		Class:   plaid.ParseErrorClass(acct.SyncErrorClass),
+	}
+	if acct.SyncError != "" {
+		status.Failures = 1
+	}
+	return status
+}
+
+// nextRun is when the account is next due.  Accounts that have never
+// synced are due straight away.
+func (s *scheduler) nextRun(email string, acct storage.Account) time.Time {
+	status := s.lastRun(email, acct)
+
+	if status.LastRun.IsZero() {
+		return time.Time{}
+	}
+
+	return status.LastRun.Add(s.wait(status))
+}
+
+// wait is how long to leave an account after a sync that ended in status.
+func (s *scheduler) wait(status syncStatus) time.Duration {
+	if status.Failures == 0 {
+		return s.config.Interval
+	}
+
+	// Retrying won't help until the user logs in again through Link, so
+	// only check back occasionally.
+	if status.Class == plaid.ClassItemLoginRequired {
+		return s.config.MaxBackoff
+	}
+
+	wait := s.config.Backoff
+	for i := 1; i < status.Failures && wait < s.config.MaxBackoff; i++ {
+		wait *= 2
+	}
+
+	if wait > s.config.MaxBackoff {
+		wait = s.config.MaxBackoff
+	}
+
+	return wait
+}
+
+// SyncStatusView is one account's row on the admin sync page.
+type SyncStatusView struct {
+	Email       string     `json:"email"`
+	Account     string     `json:"account"`
+	AccountName string     `json:"account_name"`
+	ItemID      string     `json:"item_id"`
+	LastRun     *time.Time `json:"last_run,omitempty"`
+	DurationMS  int64      `json:"duration_ms"`
+	Error       string     `json:"error,omitempty"`
+	Class       string     `json:"class,omitempty"`
+	Failures    int        `json:"failures"`
+	NextRun     time.Time  `json:"next_run"`
+	Queued      bool       `json:"queued"`
+	Running     bool       `json:"running"`
+}
+
+type SchedulerView struct {
+	LastTick  *time.Time       `json:"last_tick,omitempty"`
+	TickError string           `json:"tick_error,omitempty"`
+	Interval  string           `json:"interval"`
+	Workers   int              `json:"workers"`
+	Accounts  []SyncStatusView `json:"accounts"`
+}
+
+func (s *scheduler) view() (SchedulerView, error) {
+	s.mu.Lock()
+	view := SchedulerView{
+		TickError: s.tickErr,
+		Interval:  s.config.Interval.String(),
+		Workers:   s.config.Workers,
+		Accounts:  make([]SyncStatusView, 0),
+	}
+	if !s.lastTick.IsZero() {
+		lastTick := s.lastTick
+		view.LastTick = &lastTick
+	}
+	s.mu.Unlock()
+
+	people, err := s.store.All()
+	if err != nil {
+		return view, err
+	}
+
+	for _, p := range people {
+		for _, acct := range p.Accounts {
+			status := s.lastRun(p.Email, acct)
+			queued, running := s.syncs.state(syncJob{Email: p.Email, ItemID: acct.ItemID})
+
+			row := SyncStatusView{
+				Email:       p.Email,
+				Account:     acct.ID,
+				AccountName: acct.Name,
+				ItemID:      acct.ItemID,
+				DurationMS:  int64(status.Duration / time.Millisecond),
+				Error:       status.Error,
+				Failures:    status.Failures,
+				NextRun:     s.nextRun(p.Email, acct),
+				Queued:      queued,
+				Running:     running,
+			}
+
+			if !status.LastRun.IsZero() {
+				lastRun := status.LastRun
+				row.LastRun = &lastRun
+			}
+
+			if status.Error != "" {
+				row.Class = status.Class.String()
+			}
+
+			view.Accounts = append(view.Accounts, row)
+		}
+	}
+
+	sort.SliceStable(view.Accounts, func(i, j int) bool {
+		a, b := view.Accounts[i], view.Accounts[j]
+		if a.Email != b.Email {
+			return a.Email < b.Email
+		}
+		return a.AccountName < b.AccountName
+	})
+
+	return view, nil
+}
+
+func syncStatusHandler(profile *auth.Profile, w http.ResponseWriter, r *http.Request) *appError {
+	view, err := schedule.view()
+
+	if err != nil {
+		return appErrorf(err, "problem loading sync status")
+	}
+
+	return respondJson(w, view)
+}
@@ -1,19 +1,32 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/pcarleton/cashcoach/api/auth"
+	"github.com/pcarleton/cashcoach/api/clock"
 	"github.com/pcarleton/cashcoach/api/storage"
 )
 
 var config *Config
 
+// shutdownTimeout is how long requests in flight get to finish.
+const shutdownTimeout = 10 * time.Second
+
+var syncs *syncer
+
+var schedule *scheduler
+
 func logHandler(msg string) func(w http.ResponseWriter, r *http.Request) *appError {
 	return func(w http.ResponseWriter, r *http.Request) *appError {
 		log.Printf("request from %v\n", r.RemoteAddr)
@@ -69,6 +82,18 @@ func handleAuth(handler authorizedHandler) appHandler {
 	}
 }
 
+// handleAdmin is handleAuth for people listed in the admins config key.
+func handleAdmin(handler authorizedHandler) appHandler {
+	return handleAuth(func(profile *auth.Profile, w http.ResponseWriter, r *http.Request) *appError {
+		for _, admin := range config.Admins {
+			if admin == profile.Email {
+				return handler(profile, w, r)
+			}
+		}
+		return &appError{nil, "admins only", http.StatusForbidden}
+	})
+}
+
 func respondJson(w http.ResponseWriter, v interface{}) *appError {
 	js, err := json.Marshal(v)
 	if err != nil {
@@ -84,26 +109,6 @@ func meHandler(profile *auth.Profile, w http.ResponseWriter, r *http.Request) *a
 	return respondJson(w, profile)
 }
 
-func transactionsHandler(profile *auth.Profile, w http.ResponseWriter, r *http.Request) *appError {
-	person, err := config.Get(profile.Email)
-
-	if err != nil {
-		return appErrorf(err, "Error loading bank info from database for %s.", profile.Email)
-	}
-
-	now := time.Now()
-	lastMonth := now.AddDate(0, -1, 0)
-
-	transactions, err := config.Plaid.Transactions(
-		person.Accounts[0].Token, lastMonth, now)
-
-	if err != nil {
-		return appErrorf(err, "Error getting transactions")
-	}
-
-	return respondJson(w, transactions)
-}
-
 type JwtRequest struct {
 	IDToken string `json:"idtoken"`
 }
@@ -165,58 +170,6 @@ func jwtHandler(w http.ResponseWriter, r *http.Request) *appError {
 	return respondJson(w, message)
 }
 
-func accountsHandler(profile *auth.Profile, w http.ResponseWriter, r *http.Request) *appError {
-	result, err := config.Get(profile.Email)
-
-	if err != nil {
-		return appErrorf(err, "couldn't find %s", profile.Email)
-	}
-
-
-  // TODO: Don't just take the first one...
-
-  // TODO: I should never send the raw API token to the client.
-	return respondJson(w, result)
-}
-
-type AddAccountRequest struct {
-	Name        string `json:"name"`
-	PublicToken string `json:"public_token"`
-}
-
-func addAccount(profile *auth.Profile, w http.ResponseWriter, r *http.Request) *appError {
-	person, err := config.Get(profile.Email)
-
-	if err != nil {
-		return appErrorf(err, "couldn't find %s", profile.Email)
-	}
-
-	req := AddAccountRequest{}
-	err = unmarshal(&req, r)
-
-	if err != nil {
-		return appErrorf(err, "bad request")
-	}
-
-	resp, err := config.Plaid.Exchange(req.PublicToken)
-
-	if err != nil {
-		return appErrorf(err, "problem exchanging public token")
-	}
-
-	acct := storage.Account{Name: req.Name, Token: resp.AccessToken}
-
-	person.Accounts = append(person.Accounts, acct)
-
-	err = config.Update(person)
-
-	if err != nil {
-		return appErrorf(err, "problem saving")
-	}
-
-	return respondJson(w, "saved new account")
-}
-
 func main() {
 	v, err := parseConfig()
 	if err != nil {
@@ -227,12 +180,87 @@ func main() {
 		panic(fmt.Errorf("Error setting up environment: %s", err))
 	}
 
+	// Old keys stay readable, so re-encrypting can happen while serving.
+	if encrypted, ok := config.Storage.(*storage.EncryptedStorage); ok {
+		go func() {
+			count, err := encrypted.Reencrypt()
+			if err != nil {
+				log.Printf("Re-encrypting access tokens failed: %v", err)
+				return
+			}
+			log.Printf("Re-encrypted access tokens for %d people", count)
+		}()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// Accounts linked before item IDs were saved can't be synced until
+	// they have one; their transactions are fetched live until then.
+	go func() {
+		count, err := backfillAllItems(ctx, config.Storage, config.Plaid)
+		if err != nil {
+			log.Printf("Looking up Plaid items failed: %v", err)
+			return
+		}
+		if count > 0 {
+			log.Printf("Looked up Plaid items for %d people", count)
+		}
+	}()
+
+	syncs = newSyncer(config.Storage, config.Transactions, config.Plaid,
+		config.Schedule.Workers, clock.Real)
+	schedule = newScheduler(config.Storage, syncs, clock.Real, config.Schedule)
+
+	var background sync.WaitGroup
+	background.Add(2)
+	go func() {
+		defer background.Done()
+		syncs.run(ctx)
+	}()
+	go func() {
+		defer background.Done()
+		schedule.run(ctx)
+	}()
+
 	http.Handle("/api/me", appHandler(handleAuth(meHandler)))
 	http.Handle("/api/transactions", appHandler(handleAuth(transactionsHandler)))
+	http.Handle("/api/balances", appHandler(handleAuth(balancesHandler)))
 	http.Handle("/api/jwt", appHandler(jwtHandler))
 	http.Handle("/api/accounts", appHandler(handleAuth(accountsHandler)))
 	http.Handle("/api/accounts/add", appHandler(handleAuth(addAccount)))
+	http.Handle("/api/accounts/update", appHandler(handleAuth(updateAccount)))
+	http.Handle("/api/accounts/rename", appHandler(handleAuth(renameAccount)))
+	http.Handle("/api/accounts/delete", appHandler(handleAuth(deleteAccount)))
+	http.Handle("/api/webhooks/plaid", appHandler(plaidWebhookHandler))
+	http.Handle("/api/admin/sync", handleAdmin(syncStatusHandler))
+
+	server := &http.Server{Addr: ":5001"}
+
+	// On SIGINT or SIGTERM, stop taking requests, then let any syncs in
+	// progress finish before exiting.
+	stopped := make(chan struct{})
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		<-signals
+
+		log.Println("Shutting down...")
+		shutdownCtx, done := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer done()
+
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Shutdown: %v", err)
+		}
+
+		cancel()
+		background.Wait()
+		close(stopped)
+	}()
 
 	log.Println("Serving...")
-	log.Fatal(http.ListenAndServe(":5001", nil))
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+
+	<-stopped
 }
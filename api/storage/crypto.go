@@ -0,0 +1,312 @@
+package storage
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// KeySize is the length of master and data keys: AES-256.
+const KeySize = 32
+
+// KeyProvider supplies the master keys that wrap each token's data key.
+// Keys are never deleted while envelopes sealed with them exist, which is
+// what lets a new key be rolled out while old records are still readable.
+type KeyProvider interface {
+	// CurrentKeyID names the key new envelopes are sealed with.
+	CurrentKeyID() string
+
+	// Key returns the master key with the given ID.
+	Key(id string) ([]byte, error)
+}
+
+// Keyring is a KeyProvider kept in a JSON file or environment variable:
+//
+//	{"current": "k2", "keys": {"k1": "<hex>", "k2": "<hex>"}}
+type Keyring struct {
+	Current string            `json:"current"`
+	Keys    map[string]string `json:"keys"`
+}
+
+func NewKeyring() (*Keyring, error) {
+	k := &Keyring{Keys: make(map[string]string)}
+	if _, err := k.Rotate(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// ParseKeyring reads a keyring from JSON, or from a single hex key, which
+// becomes a keyring whose only key is named "default".
+func ParseKeyring(data []byte) (*Keyring, error) {
+	text := strings.TrimSpace(string(data))
+
+	if !strings.HasPrefix(text, "{") {
+		k := &Keyring{Current: "default", Keys: map[string]string{"default": text}}
+		if err := k.validate(); err != nil {
+			return nil, err
+		}
+		return k, nil
+	}
+
+	k := &Keyring{}
+	if err := json.Unmarshal([]byte(text), k); err != nil {
+		return nil, err
+	}
+
+	if err := k.validate(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+func LoadKeyring(path string) (*Keyring, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	k, err := ParseKeyring(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid keyring %s: %v", path, err)
+	}
+
+	return k, nil
+}
+
+func (k *Keyring) validate() error {
+	if _, ok := k.Keys[k.Current]; !ok {
+		return fmt.Errorf("current key %q is not in the keyring", k.Current)
+	}
+
+	for id := range k.Keys {
+		if _, err := k.Key(id); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (k *Keyring) CurrentKeyID() string {
+	return k.Current
+}
+
+func (k *Keyring) Key(id string) ([]byte, error) {
+	encoded, ok := k.Keys[id]
+	if !ok {
+		return nil, fmt.Errorf("no key %q in keyring", id)
+	}
+
+	key, err := hex.DecodeString(encoded)
+	if err != nil || len(key) != KeySize {
+		return nil, fmt.Errorf("key %q must be %d hex-encoded bytes", id, KeySize)
+	}
+
+	return key, nil
+}
+
+// Rotate adds a new random key and makes it current, returning its ID.
+// Older keys stay so existing envelopes can still be opened.
+func (k *Keyring) Rotate() (string, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+
+	id := time.Now().UTC().Format("20060102T150405Z")
+	for n := 2; k.Keys[id] != ""; n++ {
+		id = fmt.Sprintf("%s-%d", time.Now().UTC().Format("20060102T150405Z"), n)
+	}
+
+	k.Keys[id] = hex.EncodeToString(key)
+	k.Current = id
+
+	return id, nil
+}
+
+// IDs lists the key IDs in the ring, oldest first.
+func (k *Keyring) IDs() []string {
+	ids := make([]string, 0, len(k.Keys))
+	for id := range k.Keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Save writes the keyring readable only by its owner.
+func (k *Keyring) Save(path string) error {
+	data, err := json.MarshalIndent(k, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// Envelope is a secret sealed with a random data key, which is itself
+// sealed with a master key.  Rotating master keys only rewraps DataKey.
+type Envelope struct {
+	KeyID      string `json:"key_id" bson:"key_id"`
+	DataKey    []byte `json:"data_key" bson:"data_key"`
+	Ciphertext []byte `json:"ciphertext" bson:"ciphertext"`
+}
+
+// Seal encrypts plaintext under a fresh data key wrapped with the
+// provider's current key.
+func Seal(keys KeyProvider, plaintext []byte) (*Envelope, error) {
+	keyID := keys.CurrentKeyID()
+	master, err := keys.Key(keyID)
+	if err != nil {
+		return nil, err
+	}
+
+	dataKey := make([]byte, KeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+
+	wrapped, err := gcmSeal(master, dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	ciphertext, err := gcmSeal(dataKey, plaintext)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Envelope{KeyID: keyID, DataKey: wrapped, Ciphertext: ciphertext}, nil
+}
+
+// Open decrypts an envelope sealed with any key the provider still has.
+func Open(keys KeyProvider, env *Envelope) ([]byte, error) {
+	dataKey, err := unwrap(keys, env)
+	if err != nil {
+		return nil, err
+	}
+
+	return gcmOpen(dataKey, env.Ciphertext)
+}
+
+// Rewrap re-seals the envelope's data key with the current master key,
+// leaving the ciphertext alone.
+func Rewrap(keys KeyProvider, env *Envelope) (*Envelope, error) {
+	dataKey, err := unwrap(keys, env)
+	if err != nil {
+		return nil, err
+	}
+
+	keyID := keys.CurrentKeyID()
+	master, err := keys.Key(keyID)
+	if err != nil {
+		return nil, err
+	}
+
+	wrapped, err := gcmSeal(master, dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Envelope{KeyID: keyID, DataKey: wrapped, Ciphertext: env.Ciphertext}, nil
+}
+
+func unwrap(keys KeyProvider, env *Envelope) ([]byte, error) {
+	master, err := keys.Key(env.KeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	return gcmOpen(master, env.DataKey)
+}
+
+// copyEnvelope returns a copy of env that shares nothing with it.
+func copyEnvelope(env *Envelope) *Envelope {
+	if env == nil {
+		return nil
+	}
+	return &Envelope{
+		KeyID:      env.KeyID,
+		DataKey:    append([]byte(nil), env.DataKey...),
+		Ciphertext: append([]byte(nil), env.Ciphertext...),
+	}
+}
+
+// EncodeEnvelope packs an envelope into a single string, for places like
+// the CLI config file that can only hold text.
+func EncodeEnvelope(env *Envelope) (string, error) {
+	data, err := json.Marshal(env)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func DecodeEnvelope(encoded string) (*Envelope, error) {
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	env := &Envelope{}
+	if err := json.Unmarshal(data, env); err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
+var errShortCiphertext = errors.New("ciphertext too short")
+
+// gcmSeal returns nonce || AES-GCM(key, plaintext).
+func gcmSeal(key, plaintext []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func gcmOpen(key, sealed []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < aead.NonceSize() {
+		return nil, errShortCiphertext
+	}
+
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,164 @@
+package storage
+
+import "fmt"
+
+// EncryptedStorage wraps a Storage so access tokens are only ever written
+// sealed.  Callers keep using Account.Token; it is sealed into
+// Account.SealedToken on the way in and opened on the way out.
+//
+// Tokens saved in plaintext before encryption was turned on are still
+// read, and get sealed the next time the person is updated.
+type EncryptedStorage struct {
+	Storage
+	Keys KeyProvider
+}
+
+func (s *EncryptedStorage) Get(email string) (*Person, error) {
+	p, err := s.Storage.Get(email)
+	if err != nil {
+		return nil, err
+	}
+	return p, s.open(p)
+}
+
+func (s *EncryptedStorage) FindByItemID(itemID string) (*Person, error) {
+	p, err := s.Storage.FindByItemID(itemID)
+	if err != nil {
+		return nil, err
+	}
+	return p, s.open(p)
+}
+
+func (s *EncryptedStorage) All() ([]*Person, error) {
+	people, err := s.Storage.All()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range people {
+		if err := s.open(p); err != nil {
+			return nil, err
+		}
+	}
+
+	return people, nil
+}
+
+// Update seals every token with the current key.  p itself is left
+// untouched, so callers can keep using its tokens.
+func (s *EncryptedStorage) Update(p *Person) error {
+	sealed := *p
+	sealed.Accounts = make([]Account, len(p.Accounts))
+
+	for i, acct := range p.Accounts {
+		if acct.Token != "" {
+			env, err := Seal(s.Keys, []byte(acct.Token))
+			if err != nil {
+				return fmt.Errorf("unable to seal token for %s: %v", acct.Name, err)
+			}
+			acct.SealedToken = env
+			acct.Token = ""
+		}
+		sealed.Accounts[i] = acct
+	}
+
+	return s.Storage.Update(&sealed)
+}
+
+func (s *EncryptedStorage) open(p *Person) error {
+	for i := range p.Accounts {
+		acct := &p.Accounts[i]
+		if acct.SealedToken == nil {
+			continue
+		}
+
+		token, err := Open(s.Keys, acct.SealedToken)
+		if err != nil {
+			return fmt.Errorf("unable to open token for %s: %v", acct.Name, err)
+		}
+
+		acct.Token = string(token)
+		acct.SealedToken = nil
+	}
+
+	return nil
+}
+
+// needsReencrypt reports whether any of the stored person's tokens are in
+// plaintext or sealed with an old key.
+func (s *EncryptedStorage) needsReencrypt(stored *Person) bool {
+	for _, acct := range stored.Accounts {
+		if acct.Token != "" {
+			return true
+		}
+		if acct.SealedToken != nil && acct.SealedToken.KeyID != s.Keys.CurrentKeyID() {
+			return true
+		}
+	}
+	return false
+}
+
+// Reencrypt seals every token that is still in plaintext with the current
+// key, rewraps the data keys of tokens sealed with an old key, and returns
+// how many people were rewritten.  Old keys keep working throughout, so
+// this can run while the server is serving requests; once it finishes they
+// can be dropped from the ring.
+func (s *EncryptedStorage) Reencrypt() (int, error) {
+	stored, err := s.Storage.All()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, p := range stored {
+		if !s.needsReencrypt(p) {
+			continue
+		}
+
+		// Re-read just before writing to keep the window for clobbering
+		// a concurrent update small.
+		fresh, err := s.Storage.Get(p.Email)
+		if err != nil {
+			return count, err
+		}
+
+		if err := s.reencrypt(fresh); err != nil {
+			return count, err
+		}
+
+		if err := s.Storage.Update(fresh); err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	return count, nil
+}
+
+// reencrypt brings the stored person's tokens up to the current key.
+// Sealed tokens are only rewrapped, so they're never in memory in
+// plaintext.
+func (s *EncryptedStorage) reencrypt(stored *Person) error {
+	for i := range stored.Accounts {
+		acct := &stored.Accounts[i]
+
+		switch {
+		case acct.Token != "":
+			env, err := Seal(s.Keys, []byte(acct.Token))
+			if err != nil {
+				return fmt.Errorf("unable to seal token for %s: %v", acct.Name, err)
+			}
+			acct.SealedToken = env
+			acct.Token = ""
+
+		case acct.SealedToken != nil && acct.SealedToken.KeyID != s.Keys.CurrentKeyID():
+			env, err := Rewrap(s.Keys, acct.SealedToken)
+			if err != nil {
+				return fmt.Errorf("unable to rewrap token for %s: %v", acct.Name, err)
+			}
+			acct.SealedToken = env
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestReencrypt(t *testing.T) {
+	keys, err := NewKeyring()
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := keys.CurrentKeyID()
+
+	sealed, err := Seal(keys, []byte("access-sealed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backend := NewFakeStorage()
+	backend.Create("someone@example.com")
+	backend.Update(&Person{
+		Email: "someone@example.com",
+		Accounts: []Account{
+			{ID: "plain", Name: "plain", Token: "access-plain"},
+			{ID: "sealed", Name: "sealed", SealedToken: sealed},
+		},
+	})
+
+	current, err := keys.Rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &EncryptedStorage{Storage: backend, Keys: keys}
+	count, err := s.Reencrypt()
+	if err != nil {
+		t.Fatalf("Reencrypt: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("rewrote %d people, want 1", count)
+	}
+
+	stored, err := backend.Get("someone@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, acct := range stored.Accounts {
+		if acct.Token != "" {
+			t.Errorf("%s: token stored in plaintext", acct.ID)
+		}
+		if acct.SealedToken == nil || acct.SealedToken.KeyID != current {
+			t.Errorf("%s: got %+v, want sealed with %s", acct.ID, acct.SealedToken, current)
+		}
+	}
+
+	// Rewrapping keeps the ciphertext and only replaces the data key.
+	rewrapped := stored.AccountByID("sealed").SealedToken
+	if !bytes.Equal(rewrapped.Ciphertext, sealed.Ciphertext) {
+		t.Error("sealed token was re-sealed rather than rewrapped")
+	}
+	if sealed.KeyID != old {
+		t.Errorf("Reencrypt changed the caller's envelope to %s", sealed.KeyID)
+	}
+
+	opened, err := s.Get("someone@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := opened.AccountByID("plain").Token; got != "access-plain" {
+		t.Errorf("plain token opened as %q", got)
+	}
+	if got := opened.AccountByID("sealed").Token; got != "access-sealed" {
+		t.Errorf("sealed token opened as %q", got)
+	}
+
+	// Nothing is left to do.
+	if count, err := s.Reencrypt(); err != nil || count != 0 {
+		t.Errorf("second Reencrypt = %d, %v; want 0, nil", count, err)
+	}
+}
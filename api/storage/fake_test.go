@@ -0,0 +1,14 @@
+package storage_test
+
+import (
+	"testing"
+
+	"github.com/pcarleton/cashcoach/api/storage"
+	"github.com/pcarleton/cashcoach/api/storage/storagetest"
+)
+
+func TestFakeStorage(t *testing.T) {
+	s := storage.NewFakeStorage()
+	storagetest.Run(t, s)
+	storagetest.RunTransactions(t, s)
+}
@@ -0,0 +1,609 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// FileStorage keeps people and their transactions in a single file, so a
+// household install doesn't need a database server.  The file is a log of
+// JSON lines: a header with the schema version, then one record per
+// change.  A change is appended and synced, so a sync writes only the
+// transactions it touched.  Everything is held in memory.  Opening the
+// file replays the log and compacts it into a snapshot, which atomically
+// replaces the file; a log that grows well past its snapshot is compacted
+// the same way while it's open.
+//
+// An embedded database like SQLite or Bolt would do the same job, but
+// SQLite needs cgo and neither is among the vendored dependencies.
+//
+// Only one process may have the file open at a time.
+type FileStorage struct {
+	path string
+
+	mu           sync.Mutex
+	file         *os.File
+	size         int64
+	records      int
+	version      int
+	people       []*filePerson
+	transactions transactionMap
+
+	// legacy holds what was read from a file written before everything
+	// was kept in one log, until the migrations have moved it.
+	legacy *fileDoc
+}
+
+// fileFormat marks a log written by FileStorage.
+const fileFormat = "cashcoach-log"
+
+// compactMinRecords and compactRatio decide when a log is compacted while
+// it's open: once it has more than compactMinRecords records and
+// compactRatio times as many as its snapshot would.
+const (
+	compactMinRecords = 1000
+	compactRatio      = 4
+)
+
+// fileHeader is the first line of the log.  Version is the number of
+// migrations that have been applied.
+type fileHeader struct {
+	Format  string `json:"format"`
+	Version int    `json:"version"`
+}
+
+// fileRecord is one change in the log.  Exactly one field is set.
+type fileRecord struct {
+	// Person is a person's new state, replacing any before it.
+	Person *filePerson `json:"person,omitempty"`
+
+	Upsert        *fileTransactions `json:"upsert,omitempty"`
+	Remove        *fileRemove       `json:"remove,omitempty"`
+	RemoveAccount *fileRemove       `json:"remove_account,omitempty"`
+}
+
+// fileTransactions are transactions upserted for one person.
+type fileTransactions struct {
+	Email        string        `json:"email"`
+	Transactions []Transaction `json:"transactions"`
+}
+
+// fileRemove names transactions to remove, by ID or by account.
+type fileRemove struct {
+	Email   string   `json:"email"`
+	IDs     []string `json:"ids,omitempty"`
+	Account string   `json:"account,omitempty"`
+}
+
+// fileDoc is the layout of the JSON file people were kept in before the
+// log.  Transactions were kept in it up to version 3, and from version 4
+// in a file per person in the directory path + ".transactions".
+type fileDoc struct {
+	Version      int            `json:"version"`
+	People       []*filePerson  `json:"people"`
+	Transactions transactionMap `json:"transactions,omitempty"`
+}
+
+type filePerson struct {
+	Email    string        `json:"email"`
+	Accounts []fileAccount `json:"accounts"`
+}
+
+// fileAccount stores the fields Account keeps out of JSON, since they
+// must never reach the browser but do need to reach the disk.
+type fileAccount struct {
+	Account
+	Token       string    `json:"token,omitempty"`
+	SealedToken *Envelope `json:"sealed_token,omitempty"`
+	Cursor      string    `json:"cursor,omitempty"`
+}
+
+// migration upgrades the storage by one version.  Migrations are only
+// ever appended; the position of each one is the version it produces.
+type migration struct {
+	Name  string
+	Apply func(*FileStorage) error
+}
+
+var fileMigrations = []migration{
+	{"create people", func(s *FileStorage) error {
+		if s.people == nil {
+			s.people = []*filePerson{}
+		}
+		return nil
+	}},
+	{"assign account IDs", func(s *FileStorage) error {
+		for _, fp := range s.people {
+			p := fp.person()
+			if _, err := p.EnsureAccountIDs(); err != nil {
+				return err
+			}
+			*fp = *newFilePerson(p)
+		}
+		return nil
+	}},
+	{"create transactions", func(s *FileStorage) error {
+		if s.transactions == nil {
+			s.transactions = make(transactionMap)
+		}
+		return nil
+	}},
+	{"move transactions out of the people file", func(s *FileStorage) error {
+		if s.legacy != nil {
+			for email, byID := range s.legacy.Transactions {
+				s.transactions[email] = byID
+			}
+		}
+		return nil
+	}},
+	// Everything read is already in memory; compacting writes it out.
+	{"keep everything in one file", func(s *FileStorage) error {
+		return nil
+	}},
+}
+
+// OpenFileStorage opens the file at path, creating it if needed, and
+// brings it up to the current schema version.  Files in the layout used
+// before the log are converted.
+func OpenFileStorage(path string) (*FileStorage, error) {
+	s := &FileStorage{path: path}
+
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	if err := s.migrate(); err != nil {
+		return nil, fmt.Errorf("unable to migrate %s: %v", path, err)
+	}
+
+	if err := s.compact(); err != nil {
+		return nil, err
+	}
+
+	// Once the log is written the old transaction files are only a copy.
+	if s.legacy != nil {
+		s.legacy = nil
+		if err := os.RemoveAll(s.legacyDir()); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// Close closes the file.  The storage can't be used afterwards.
+func (s *FileStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.file.Close()
+}
+
+func (s *FileStorage) load() error {
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		// Start from version 0 and let the migrations build it.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	first, err := r.ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	var header fileHeader
+	if json.Unmarshal(first, &header) != nil || header.Format != fileFormat {
+		return s.loadLegacy()
+	}
+	s.version = header.Version
+	s.people = []*filePerson{}
+	s.transactions = make(transactionMap)
+
+	for line := 2; ; line++ {
+		data, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			// A last line without its newline was cut off by a crash
+			// before its write was acknowledged, so it's dropped.
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var rec fileRecord
+		if err := json.Unmarshal(data, &rec); err != nil {
+			return fmt.Errorf("%s:%d: %v", s.path, line, err)
+		}
+		s.apply(rec)
+		s.records++
+	}
+}
+
+// loadLegacy reads a people file and the transaction files from before
+// the log.
+func (s *FileStorage) loadLegacy() error {
+	data, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+
+	doc := &fileDoc{}
+	if err := json.Unmarshal(data, doc); err != nil {
+		return fmt.Errorf("unable to read %s: %v", s.path, err)
+	}
+
+	s.legacy = doc
+	s.version = doc.Version
+	s.people = doc.People
+	s.transactions = make(transactionMap)
+
+	files, err := ioutil.ReadDir(s.legacyDir())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, info := range files {
+		// Skip temporary files left by a crash.
+		if info.IsDir() || !strings.HasSuffix(info.Name(), ".json") {
+			continue
+		}
+
+		path := filepath.Join(s.legacyDir(), info.Name())
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		var ft fileTransactions
+		if err := json.Unmarshal(data, &ft); err != nil {
+			return fmt.Errorf("unable to read %s: %v", path, err)
+		}
+		s.transactions.upsert(ft.Email, ft.Transactions)
+	}
+
+	return nil
+}
+
+func (s *FileStorage) legacyDir() string {
+	return s.path + ".transactions"
+}
+
+func (s *FileStorage) migrate() error {
+	if s.version > len(fileMigrations) {
+		return fmt.Errorf("schema version %d is newer than this server supports (%d)",
+			s.version, len(fileMigrations))
+	}
+
+	for _, m := range fileMigrations[s.version:] {
+		if err := m.Apply(s); err != nil {
+			return fmt.Errorf("migration %d (%s): %v", s.version+1, m.Name, err)
+		}
+		s.version++
+	}
+
+	return nil
+}
+
+// apply replays one record into memory.
+func (s *FileStorage) apply(rec fileRecord) {
+	switch {
+	case rec.Person != nil:
+		if i := s.find(rec.Person.Email); i >= 0 {
+			s.people[i] = rec.Person
+		} else {
+			s.people = append(s.people, rec.Person)
+		}
+	case rec.Upsert != nil:
+		s.transactions.upsert(rec.Upsert.Email, rec.Upsert.Transactions)
+	case rec.Remove != nil:
+		s.transactions.remove(rec.Remove.Email, rec.Remove.IDs)
+	case rec.RemoveAccount != nil:
+		s.transactions.removeAccount(rec.RemoveAccount.Email, rec.RemoveAccount.Account)
+	}
+}
+
+// snapshot is the records that rebuild what's in memory.
+func (s *FileStorage) snapshot() []fileRecord {
+	recs := make([]fileRecord, 0, len(s.people)+len(s.transactions))
+	for _, fp := range s.people {
+		recs = append(recs, fileRecord{Person: fp})
+	}
+
+	for email := range s.transactions {
+		txns := s.transactions.query(email, TransactionQuery{})
+		if len(txns) > 0 {
+			recs = append(recs, fileRecord{Upsert: &fileTransactions{Email: email, Transactions: txns}})
+		}
+	}
+
+	return recs
+}
+
+// compact writes the snapshot to a temporary file and renames it over the
+// log, so a crash leaves either the old log or the new one.  The new file
+// is kept open for appending.
+func (s *FileStorage) compact() error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+
+	if err := enc.Encode(fileHeader{Format: fileFormat, Version: s.version}); err != nil {
+		return err
+	}
+	recs := s.snapshot()
+	for _, rec := range recs {
+		if err := enc.Encode(rec); err != nil {
+			return err
+		}
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	_, err = tmp.Write(buf.Bytes())
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err == nil {
+		err = tmp.Chmod(0600)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), s.path)
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if s.file != nil {
+		s.file.Close()
+	}
+	s.file = tmp
+	s.size = int64(buf.Len())
+	s.records = len(recs)
+	return nil
+}
+
+// append writes rec to the end of the log and syncs it.  A failed write
+// is cut back off, so the log never holds half a record followed by
+// others.
+func (s *FileStorage) append(rec fileRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	_, err = s.file.Write(data)
+	if err == nil {
+		err = s.file.Sync()
+	}
+	if err != nil {
+		s.file.Truncate(s.size)
+		s.file.Seek(s.size, io.SeekStart)
+		return err
+	}
+
+	s.size += int64(len(data))
+	s.records++
+
+	if s.records > compactMinRecords && s.records > compactRatio*(len(s.people)+len(s.transactions)) {
+		// The record is already safe in the log, so a failed compaction
+		// only means it's tried again after the next change.
+		s.compact()
+	}
+
+	return nil
+}
+
+func (s *FileStorage) find(email string) int {
+	for i, fp := range s.people {
+		if fp.Email == email {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *FileStorage) Get(email string) (*Person, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.find(email)
+	if i < 0 {
+		return nil, ErrNotFound
+	}
+
+	return s.people[i].person(), nil
+}
+
+func (s *FileStorage) Exists(email string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.find(email) >= 0, nil
+}
+
+func (s *FileStorage) Create(email string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.find(email) >= 0 {
+		return false, nil
+	}
+
+	fp := &filePerson{Email: email, Accounts: []fileAccount{}}
+	if err := s.append(fileRecord{Person: fp}); err != nil {
+		return false, err
+	}
+
+	s.people = append(s.people, fp)
+	return true, nil
+}
+
+func (s *FileStorage) Update(p *Person) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.find(p.Email)
+	if i < 0 {
+		return ErrNotFound
+	}
+
+	fp := newFilePerson(p)
+	if err := s.append(fileRecord{Person: fp}); err != nil {
+		return err
+	}
+
+	s.people[i] = fp
+	return nil
+}
+
+func (s *FileStorage) UpdateSyncState(email, accountID string, state SyncState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.find(email)
+	if i < 0 {
+		return ErrNotFound
+	}
+
+	p := s.people[i].person()
+	acct := p.AccountByID(accountID)
+	if acct == nil {
+		return ErrNotFound
+	}
+	acct.SetSyncState(state)
+
+	fp := newFilePerson(p)
+	if err := s.append(fileRecord{Person: fp}); err != nil {
+		return err
+	}
+
+	s.people[i] = fp
+	return nil
+}
+
+func (s *FileStorage) FindByItemID(itemID string) (*Person, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, fp := range s.people {
+		for _, fa := range fp.Accounts {
+			if fa.ItemID == itemID {
+				return fp.person(), nil
+			}
+		}
+	}
+
+	return nil, ErrNotFound
+}
+
+func (s *FileStorage) All() ([]*Person, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	people := make([]*Person, 0, len(s.people))
+	for _, fp := range s.people {
+		people = append(people, fp.person())
+	}
+
+	return people, nil
+}
+
+func (s *FileStorage) UpsertTransactions(email string, txns []Transaction) error {
+	return s.updateTransactions(fileRecord{Upsert: &fileTransactions{Email: email, Transactions: txns}})
+}
+
+func (s *FileStorage) RemoveTransactions(email string, ids []string) error {
+	return s.updateTransactions(fileRecord{Remove: &fileRemove{Email: email, IDs: ids}})
+}
+
+func (s *FileStorage) RemoveAccountTransactions(email, account string) error {
+	return s.updateTransactions(fileRecord{RemoveAccount: &fileRemove{Email: email, Account: account}})
+}
+
+// updateTransactions logs a change to someone's transactions and then
+// applies it, so a failed write changes nothing.
+func (s *FileStorage) updateTransactions(rec fileRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.append(rec); err != nil {
+		return err
+	}
+
+	s.apply(rec)
+	return nil
+}
+
+func (s *FileStorage) Transactions(email string, q TransactionQuery) ([]Transaction, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.transactions.query(email, q), nil
+}
+
+// newFilePerson and person copy in both directions, so nothing handed to
+// or returned from FileStorage aliases what it holds.
+func newFilePerson(p *Person) *filePerson {
+	fp := &filePerson{Email: p.Email, Accounts: make([]fileAccount, len(p.Accounts))}
+
+	for i, acct := range p.Accounts {
+		fa := fileAccount{
+			Account:     acct,
+			Token:       acct.Token,
+			SealedToken: copyEnvelope(acct.SealedToken),
+			Cursor:      acct.Cursor,
+		}
+		fa.Account.Masks = copyStrings(acct.Masks)
+		fa.Account.SealedToken = nil
+		fp.Accounts[i] = fa
+	}
+
+	return fp
+}
+
+func (fp *filePerson) person() *Person {
+	p := &Person{Email: fp.Email}
+
+	if len(fp.Accounts) > 0 {
+		p.Accounts = make([]Account, len(fp.Accounts))
+	}
+
+	for i, fa := range fp.Accounts {
+		acct := fa.Account
+		acct.Token = fa.Token
+		acct.SealedToken = copyEnvelope(fa.SealedToken)
+		acct.Cursor = fa.Cursor
+		acct.Masks = copyStrings(fa.Account.Masks)
+		p.Accounts[i] = acct
+	}
+
+	return p
+}
+
+func copyStrings(s []string) []string {
+	if s == nil {
+		return nil
+	}
+	return append([]string(nil), s...)
+}
@@ -0,0 +1,335 @@
+package storage_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/pcarleton/cashcoach/api/plaid"
+	"github.com/pcarleton/cashcoach/api/storage"
+	"github.com/pcarleton/cashcoach/api/storage/storagetest"
+)
+
+func tempDir(t *testing.T) (string, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+func TestFileStorage(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	s, err := storage.OpenFileStorage(filepath.Join(dir, "cashcoach.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	storagetest.Run(t, s)
+	storagetest.RunTransactions(t, s)
+}
+
+func TestFileStorageReopen(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	path := filepath.Join(dir, "cashcoach.json")
+
+	s, err := storage.OpenFileStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Create("a@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	err = s.Update(&storage.Person{Email: "a@example.com", Accounts: []storage.Account{
+		{ID: "checking", Name: "checking", Token: "access-a", Cursor: "cursor-a"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	txns := []storage.Transaction{
+		{Account: "checking", Transaction: plaid.Transaction{ID: "txn-1", Date: "2017-09-01"}},
+		{Account: "checking", Transaction: plaid.Transaction{ID: "txn-2", Date: "2017-09-02"}},
+		{Account: "checking", Transaction: plaid.Transaction{ID: "txn-3", Date: "2017-09-03"}},
+	}
+	if err := s.UpsertTransactions("a@example.com", txns); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UpsertTransactions("b@example.com", txns[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RemoveTransactions("a@example.com", []string{"txn-3"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RemoveAccountTransactions("b@example.com", "checking"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Everything is in the one file.
+	if files := dirNames(t, dir); len(files) != 1 {
+		t.Errorf("got files %v, want only cashcoach.json", files)
+	}
+
+	s, err = storage.OpenFileStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	p, err := s.Get("a@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if acct := p.AccountByID("checking"); acct == nil || acct.Token != "access-a" || acct.Cursor != "cursor-a" {
+		t.Errorf("after reopening got account %+v", acct)
+	}
+
+	got, err := s.Transactions("a@example.com", storage.TransactionQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].ID != "txn-2" || got[1].ID != "txn-1" {
+		t.Errorf("after reopening got transactions %+v", got)
+	}
+
+	if got, _ := s.Transactions("b@example.com", storage.TransactionQuery{}); len(got) != 0 {
+		t.Errorf("b has transactions %+v after removing them", got)
+	}
+}
+
+func dirNames(t *testing.T, dir string) []string {
+	t.Helper()
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, len(files))
+	for i, f := range files {
+		names[i] = f.Name()
+	}
+	return names
+}
+
+// TestFileStorageAppends checks a change only adds to the end of the
+// file, and that a write cut off by a crash is dropped on reopening.
+func TestFileStorageAppends(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	path := filepath.Join(dir, "cashcoach.json")
+
+	s, err := storage.OpenFileStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Create("a@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	txn := storage.Transaction{Account: "checking", Transaction: plaid.Transaction{ID: "txn-1", Date: "2017-09-01"}}
+	if err := s.UpsertTransactions("a@example.com", []storage.Transaction{txn}); err != nil {
+		t.Fatal(err)
+	}
+
+	before, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	txn.ID = "txn-2"
+	if err := s.UpsertTransactions("a@example.com", []storage.Transaction{txn}); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	after, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(after, before) {
+		t.Fatal("a change rewrote the file rather than appending")
+	}
+	if added := string(after[len(before):]); strings.Contains(added, "txn-1") {
+		t.Errorf("upserting txn-2 wrote txn-1 again: %s", added)
+	}
+
+	// Cut the last record in half, as a crash part way through would.
+	if err := ioutil.WriteFile(path, after[:len(before)+10], 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = storage.OpenFileStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	got, err := s.Transactions("a@example.com", storage.TransactionQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].ID != "txn-1" {
+		t.Errorf("after a torn write got transactions %+v", got)
+	}
+}
+
+func TestFileStorageRejectsNewerVersion(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	path := filepath.Join(dir, "cashcoach.json")
+
+	if err := ioutil.WriteFile(path, []byte(`{"format": "cashcoach-log", "version": 1000}`+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storage.OpenFileStorage(path); err == nil || !strings.Contains(err.Error(), "newer") {
+		t.Errorf("got %v, want a newer version error", err)
+	}
+}
+
+// TestFileStorageMigratesPeopleFile opens a file from before the log,
+// when transactions were kept in the people file.
+func TestFileStorageMigratesPeopleFile(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	path := filepath.Join(dir, "cashcoach.json")
+
+	old := `{
+  "version": 3,
+  "people": [{"email": "a@example.com", "accounts": [{"id": "checking", "name": "checking", "token": "access-a"}]}],
+  "transactions": {
+    "a@example.com": {
+      "txn-1": {"email": "a@example.com", "account": "checking", "transaction_id": "txn-1", "date": "2017-09-01", "amount": 4.33}
+    }
+  }
+}`
+	if err := ioutil.WriteFile(path, []byte(old), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	checkMigrated(t, path)
+}
+
+// TestFileStorageMigratesTransactionFiles opens a file from before the
+// log, when each person's transactions had a file of their own.
+func TestFileStorageMigratesTransactionFiles(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	path := filepath.Join(dir, "cashcoach.json")
+
+	old := `{
+  "version": 4,
+  "people": [{"email": "a@example.com", "accounts": [{"id": "checking", "name": "checking", "token": "access-a"}]}]
+}`
+	if err := ioutil.WriteFile(path, []byte(old), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	txns := `{"email": "a@example.com", "transactions": [
+  {"email": "a@example.com", "account": "checking", "transaction_id": "txn-1", "date": "2017-09-01", "amount": 4.33}
+]}`
+	if err := os.Mkdir(path+".transactions", 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(path+".transactions", "a.json"), []byte(txns), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	checkMigrated(t, path)
+
+	if files := dirNames(t, dir); len(files) != 1 {
+		t.Errorf("got files %v after migrating, want only cashcoach.json", files)
+	}
+}
+
+// checkMigrated opens the old file at path, twice, and checks what was in
+// it survived.
+func checkMigrated(t *testing.T, path string) {
+	t.Helper()
+
+	for i := 0; i < 2; i++ {
+		s, err := storage.OpenFileStorage(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		p, err := s.Get("a@example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if acct := p.AccountByID("checking"); acct == nil || acct.Token != "access-a" {
+			t.Errorf("open %d: got account %+v", i+1, acct)
+		}
+
+		got, err := s.Transactions("a@example.com", storage.TransactionQuery{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 1 || got[0].ID != "txn-1" || got[0].Amount != 433 {
+			t.Errorf("open %d: got transactions %+v", i+1, got)
+		}
+		s.Close()
+	}
+}
+
+// TestFileStorageCompacts checks a log of many changes to little data is
+// compacted while it's open.
+func TestFileStorageCompacts(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	path := filepath.Join(dir, "cashcoach.json")
+
+	s, err := storage.OpenFileStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if _, err := s.Create("a@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	var largest, record int64
+	for i := 0; i < 2500; i++ {
+		err := s.Update(&storage.Person{Email: "a@example.com", Accounts: []storage.Account{
+			{ID: "checking", Name: "checking " + strconv.Itoa(i)},
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == 1 {
+			record = info.Size() - largest
+		}
+		if info.Size() > largest {
+			largest = info.Size()
+		}
+	}
+
+	// 2500 updates are far more than the log ever holds at once.
+	if largest > 1500*record {
+		t.Errorf("log grew to %d bytes, %d records' worth", largest, largest/record)
+	}
+
+	p, err := s.Get("a@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := p.AccountByID("checking").Name; name != "checking 2499" {
+		t.Errorf("got name %q after compacting", name)
+	}
+}
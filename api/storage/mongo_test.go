@@ -0,0 +1,38 @@
+package storage_test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2"
+
+	"github.com/pcarleton/cashcoach/api/storage"
+	"github.com/pcarleton/cashcoach/api/storage/storagetest"
+)
+
+// TestMongoStorage runs against the server in $MONGO_HOST, or localhost,
+// in a database it drops afterwards.  It's skipped if there's no server.
+func TestMongoStorage(t *testing.T) {
+	host := os.Getenv("MONGO_HOST")
+	if host == "" {
+		host = "localhost"
+	}
+
+	session, err := mgo.DialWithTimeout(host, time.Second)
+	if err != nil {
+		t.Skipf("no mongo at %s: %v", host, err)
+	}
+	defer session.Close()
+
+	id, err := storage.NewAccountID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := "storagetest-" + id
+	defer session.DB(db).DropDatabase()
+
+	s := &storage.MongoStorage{Session: session, DB: db}
+	storagetest.Run(t, s)
+	storagetest.RunTransactions(t, s)
+}
@@ -1,15 +1,69 @@
 package storage
 
 import (
+  "crypto/rand"
+  "encoding/hex"
+  "errors"
   "fmt"
   "log"
+  "sync"
+  "time"
 
 	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
 )
 
 type Account struct {
+  // ID is an opaque, stable identifier that is safe to hand to the
+  // browser in place of Token.
+  ID    string `json:"id"`
   Name  string `json:"name"`
-  Token string `json:"token"`
+
+  // Token is the Plaid access token.  It must never leave the server.
+  Token string `json:"-" bson:"token,omitempty"`
+
+  // SealedToken is Token encrypted at rest; see EncryptedStorage.
+  SealedToken *Envelope `json:"-" bson:"sealedtoken,omitempty"`
+
+  // ItemID is Plaid's ID for the item behind Token, which is how
+  // webhooks identify the account.
+  ItemID string `json:"item_id"`
+
+  InstitutionID string   `json:"institution_id"`
+  Masks         []string `json:"masks"`
+
+  // Cursor is the last /transactions/sync cursor for the item.
+  Cursor    string    `json:"-"`
+  LastSync  time.Time `json:"last_sync"`
+  SyncError string    `json:"sync_error"`
+
+  // SyncErrorClass is the plaid.ErrorClass of SyncError.
+  SyncErrorClass string `json:"sync_error_class"`
+}
+
+// SyncState is the part of an Account that syncing changes.
+type SyncState struct {
+  Cursor         string
+  LastSync       time.Time
+  SyncError      string
+  SyncErrorClass string
+}
+
+// SetSyncState copies state into the account.
+func (a *Account) SetSyncState(state SyncState) {
+  a.Cursor = state.Cursor
+  a.LastSync = state.LastSync
+  a.SyncError = state.SyncError
+  a.SyncErrorClass = state.SyncErrorClass
+}
+
+// NewAccountID returns a random opaque account ID.
+func NewAccountID() (string, error) {
+  b := make([]byte, 12)
+  if _, err := rand.Read(b); err != nil {
+    return "", err
+  }
+  return hex.EncodeToString(b), nil
 }
 
 type Person struct {
@@ -27,71 +81,328 @@ type Storage interface {
 
   // Update person
   Update(*Person) error
+
+  // UpdateSyncState saves one account's sync state, leaving the rest of
+  // the person as stored, so a slow sync can't undo changes made while it
+  // ran.  It returns ErrNotFound if there's no such person or account.
+  UpdateSyncState(email, accountID string, state SyncState) error
+
+  // FindByItemID returns the person who owns the Plaid item, or
+  // ErrNotFound.
+  FindByItemID(string) (*Person, error)
+
+  // All returns every person.
+  All() ([]*Person, error)
 }
 
+var ErrNotFound = errors.New("not found")
+
+// AccountByID returns the person's account with the opaque ID, or nil.
+func (p *Person) AccountByID(id string) *Account {
+  for i := range p.Accounts {
+    if p.Accounts[i].ID == id {
+      return &p.Accounts[i]
+    }
+  }
+  return nil
+}
+
+// EnsureAccountIDs gives an ID to accounts saved before IDs existed.  It
+// returns true if any were added, meaning the person should be saved.
+func (p *Person) EnsureAccountIDs() (bool, error) {
+  changed := false
+  for i := range p.Accounts {
+    if p.Accounts[i].ID != "" {
+      continue
+    }
+
+    id, err := NewAccountID()
+    if err != nil {
+      return false, err
+    }
+    p.Accounts[i].ID = id
+    changed = true
+  }
+  return changed, nil
+}
+
+// AccountByItemID returns the person's account for the Plaid item, or nil.
+func (p *Person) AccountByItemID(itemID string) *Account {
+  for i := range p.Accounts {
+    if p.Accounts[i].ItemID == itemID {
+      return &p.Accounts[i]
+    }
+  }
+  return nil
+}
+
+// FakeStorage keeps people in memory.  Everyone created gets an account
+// for each of Tokens, so the API can be run against Plaid's sandbox
+// without linking anything first.
 type FakeStorage struct {
-	Tokens []string
+  Tokens []string
+
+  mu           sync.Mutex
+  people       map[string]*Person
+  transactions transactionMap
+}
+
+func NewFakeStorage(tokens ...string) *FakeStorage {
+  return &FakeStorage{
+    Tokens:       tokens,
+    people:       make(map[string]*Person),
+    transactions: make(transactionMap),
+  }
 }
 
 func (f *FakeStorage) Get(email string) (*Person, error) {
-	p := &Person{
-		Email: email,
-		Accounts: []Account{Account{"bank1", f.Tokens[0]}},
-	}
-	return p, nil
+  f.mu.Lock()
+  defer f.mu.Unlock()
+
+  p, ok := f.people[email]
+  if !ok {
+    return nil, ErrNotFound
+  }
+  return copyPerson(p), nil
+}
+
+func (f *FakeStorage) FindByItemID(itemID string) (*Person, error) {
+  f.mu.Lock()
+  defer f.mu.Unlock()
+
+  for _, p := range f.people {
+    if p.AccountByItemID(itemID) != nil {
+      return copyPerson(p), nil
+    }
+  }
+  return nil, ErrNotFound
+}
+
+func (f *FakeStorage) All() ([]*Person, error) {
+  f.mu.Lock()
+  defer f.mu.Unlock()
+
+  people := make([]*Person, 0, len(f.people))
+  for _, p := range f.people {
+    people = append(people, copyPerson(p))
+  }
+  return people, nil
 }
 
 func (f *FakeStorage) Exists(email string) (bool, error) {
-  return true, nil
+  f.mu.Lock()
+  defer f.mu.Unlock()
+
+  _, ok := f.people[email]
+  return ok, nil
 }
 
 func (f *FakeStorage) Create(email string) (bool, error) {
-  return false, nil
+  f.mu.Lock()
+  defer f.mu.Unlock()
+
+  if _, ok := f.people[email]; ok {
+    return false, nil
+  }
+
+  if f.people == nil {
+    f.people = make(map[string]*Person)
+  }
+
+  p := &Person{Email: email}
+  for i, token := range f.Tokens {
+    name := fmt.Sprintf("bank%d", i+1)
+    p.Accounts = append(p.Accounts, Account{ID: name, Name: name, Token: token})
+  }
+  f.people[email] = p
+
+  return true, nil
 }
 
-func (f *FakeStorage) Update(*Person) error {
+func (f *FakeStorage) Update(p *Person) error {
+  f.mu.Lock()
+  defer f.mu.Unlock()
+
+  if _, ok := f.people[p.Email]; !ok {
+    return ErrNotFound
+  }
+  f.people[p.Email] = copyPerson(p)
   return nil
 }
 
+func (f *FakeStorage) UpdateSyncState(email, accountID string, state SyncState) error {
+  f.mu.Lock()
+  defer f.mu.Unlock()
+
+  p, ok := f.people[email]
+  if !ok {
+    return ErrNotFound
+  }
+
+  acct := p.AccountByID(accountID)
+  if acct == nil {
+    return ErrNotFound
+  }
+
+  acct.SetSyncState(state)
+  return nil
+}
+
+func (f *FakeStorage) UpsertTransactions(email string, txns []Transaction) error {
+  f.mu.Lock()
+  defer f.mu.Unlock()
+
+  if f.transactions == nil {
+    f.transactions = make(transactionMap)
+  }
+  f.transactions.upsert(email, txns)
+  return nil
+}
+
+func (f *FakeStorage) RemoveTransactions(email string, ids []string) error {
+  f.mu.Lock()
+  defer f.mu.Unlock()
+
+  f.transactions.remove(email, ids)
+  return nil
+}
+
+func (f *FakeStorage) RemoveAccountTransactions(email, account string) error {
+  f.mu.Lock()
+  defer f.mu.Unlock()
+
+  f.transactions.removeAccount(email, account)
+  return nil
+}
+
+func (f *FakeStorage) Transactions(email string, q TransactionQuery) ([]Transaction, error) {
+  f.mu.Lock()
+  defer f.mu.Unlock()
+
+  return f.transactions.query(email, q), nil
+}
+
+func copyPerson(p *Person) *Person {
+  c := &Person{Email: p.Email}
+  if p.Accounts != nil {
+    c.Accounts = make([]Account, len(p.Accounts))
+  }
+  for i, acct := range p.Accounts {
+    acct.Masks = copyStrings(acct.Masks)
+    acct.SealedToken = copyEnvelope(acct.SealedToken)
+    c.Accounts[i] = acct
+  }
+  return c
+}
+
+// MongoStorage keeps people in the "people" collection of DB, or of the
+// "test" database if DB is empty.
 type MongoStorage struct {
-	Session *mgo.Session
+  Session *mgo.Session
+  DB      string
+}
+
+func (s *MongoStorage) people() *mgo.Collection {
+  db := s.DB
+  if db == "" {
+    db = "test"
+  }
+  return s.Session.DB(db).C("people")
 }
 
 func (s *MongoStorage) Get(email string) (*Person, error) {
-	c := s.Session.DB("test").C("people")
+  c := s.people()
 
   person := Person{Email: email}
   result := new(Person)
-	err := c.Find(person).One(result)
+  err := c.Find(person).One(result)
 
-	if err != nil {
-		return nil, err
-	}
+  if err == mgo.ErrNotFound {
+    return nil, ErrNotFound
+  }
 
-	return result, nil
+  if err != nil {
+    return nil, err
+  }
+
+  return result, nil
 }
 
 
 func (s *MongoStorage) Exists(email string) (bool, error) {
-	c := s.Session.DB("test").C("people")
+  c := s.people()
   person := Person{Email: email}
-	count, err := c.Find(&person).Count()
+  count, err := c.Find(&person).Count()
 
-	if err != nil {
-		return false, err
-	}
+  if err != nil {
+    return false, err
+  }
 
   if count > 1 {
     return false, fmt.Errorf("multiple people found for %s", email)
   }
 
-	return count == 1, nil
+  return count == 1, nil
+}
+
+func (s *MongoStorage) FindByItemID(itemID string) (*Person, error) {
+  c := s.people()
+
+  result := new(Person)
+  err := c.Find(bson.M{"accounts.itemid": itemID}).One(result)
+
+  if err == mgo.ErrNotFound {
+    return nil, ErrNotFound
+  }
+
+  if err != nil {
+    return nil, err
+  }
+
+  return result, nil
+}
+
+func (s *MongoStorage) All() ([]*Person, error) {
+  c := s.people()
+
+  people := make([]*Person, 0)
+  err := c.Find(nil).All(&people)
+
+  if err != nil {
+    return nil, err
+  }
+
+  return people, nil
 }
 
 func (s *MongoStorage) Update(p *Person) error {
-	c := s.Session.DB("test").C("people")
+  c := s.people()
   selector := Person{Email: p.Email}
-  return c.Update(&selector, p)
+  err := c.Update(&selector, p)
+
+  if err == mgo.ErrNotFound {
+    return ErrNotFound
+  }
+
+  return err
+}
+
+func (s *MongoStorage) UpdateSyncState(email, accountID string, state SyncState) error {
+  c := s.people()
+  selector := bson.M{"email": email, "accounts.id": accountID}
+  update := bson.M{"$set": bson.M{
+    "accounts.$.cursor":         state.Cursor,
+    "accounts.$.lastsync":       state.LastSync,
+    "accounts.$.syncerror":      state.SyncError,
+    "accounts.$.syncerrorclass": state.SyncErrorClass,
+  }}
+  err := c.Update(selector, update)
+
+  if err == mgo.ErrNotFound {
+    return ErrNotFound
+  }
+
+  return err
 }
 
 func (s *MongoStorage) Create(email string) (bool, error) {
@@ -107,7 +418,7 @@ func (s *MongoStorage) Create(email string) (bool, error) {
 
   log.Printf("Create")
 
-	c := s.Session.DB("test").C("people")
+	c := s.people()
 	p := &Person{
 		Email: email,
 	}
@@ -119,3 +430,102 @@ func (s *MongoStorage) Create(email string) (bool, error) {
 
 	return true, nil
 }
+
+func (s *MongoStorage) transactions() (*mgo.Collection, error) {
+  db := s.DB
+  if db == "" {
+    db = "test"
+  }
+  c := s.Session.DB(db).C("transactions")
+
+  // mgo remembers which indexes it has ensured, so this is cheap after
+  // the first call.
+  err := c.EnsureIndex(mgo.Index{Key: []string{"email", "id"}, Unique: true})
+  if err != nil {
+    return nil, err
+  }
+
+  return c, nil
+}
+
+func (s *MongoStorage) UpsertTransactions(email string, txns []Transaction) error {
+  c, err := s.transactions()
+
+  if err != nil {
+    return err
+  }
+
+  for _, t := range txns {
+    t.Email = email
+
+    if t.PendingTransactionID != "" {
+      err := c.Remove(bson.M{"email": email, "id": t.PendingTransactionID})
+      if err != nil && err != mgo.ErrNotFound {
+        return err
+      }
+    }
+
+    _, err := c.Upsert(bson.M{"email": email, "id": t.ID}, &t)
+    if err != nil {
+      return err
+    }
+  }
+
+  return nil
+}
+
+func (s *MongoStorage) RemoveTransactions(email string, ids []string) error {
+  c, err := s.transactions()
+
+  if err != nil {
+    return err
+  }
+
+  _, err = c.RemoveAll(bson.M{"email": email, "id": bson.M{"$in": ids}})
+  return err
+}
+
+func (s *MongoStorage) RemoveAccountTransactions(email, account string) error {
+  c, err := s.transactions()
+
+  if err != nil {
+    return err
+  }
+
+  _, err = c.RemoveAll(bson.M{"email": email, "account": account})
+  return err
+}
+
+func (s *MongoStorage) Transactions(email string, q TransactionQuery) ([]Transaction, error) {
+  c, err := s.transactions()
+
+  if err != nil {
+    return nil, err
+  }
+
+  selector := bson.M{"email": email}
+
+  date := bson.M{}
+  if start := q.start(); start != "" {
+    date["$gte"] = start
+  }
+  if end := q.end(); end != "" {
+    date["$lte"] = end
+  }
+  if len(date) > 0 {
+    selector["date"] = date
+  }
+
+  if len(q.Accounts) > 0 {
+    selector["account"] = bson.M{"$in": q.Accounts}
+  }
+
+  txns := make([]Transaction, 0)
+  err = c.Find(selector).Sort("-date", "account", "id").All(&txns)
+
+  if err != nil {
+    return nil, err
+  }
+
+  return txns, nil
+}
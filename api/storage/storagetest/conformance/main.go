@@ -0,0 +1,124 @@
+// Command conformance runs the storagetest suite against a storage
+// backend:
+//
+//	conformance -driver file -path /tmp/cashcoach.json
+//	conformance -driver mongo -mongo localhost -db conformance
+//	conformance -driver fake
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/pcarleton/cashcoach/api/storage"
+	"github.com/pcarleton/cashcoach/api/storage/storagetest"
+	"gopkg.in/mgo.v2"
+)
+
+var (
+	driver    = flag.String("driver", "file", "backend to test: file, mongo or fake")
+	path      = flag.String("path", "", "file for the file driver; a temporary one if empty")
+	mongoHost = flag.String("mongo", "localhost", "host for the mongo driver")
+	mongoDB   = flag.String("db", "storagetest", "database for the mongo driver, dropped afterwards")
+)
+
+// logT reports failures on stderr and remembers whether there were any.
+type logT struct {
+	failed bool
+}
+
+// errFatal stops the suite after a Fatalf, like runtime.Goexit does for a
+// test, so main still gets to clean up.
+type errFatal struct{}
+
+func (t *logT) Errorf(format string, args ...interface{}) {
+	t.failed = true
+	log.Printf("FAIL: "+format, args...)
+}
+
+func (t *logT) Fatalf(format string, args ...interface{}) {
+	t.Errorf(format, args...)
+	panic(errFatal{})
+}
+
+// run runs the suites against s, stopping at the first Fatalf.
+func run(t *logT, s backend) {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(errFatal); !ok {
+				panic(r)
+			}
+		}
+	}()
+
+	storagetest.Run(t, s)
+	storagetest.RunTransactions(t, s)
+}
+
+// backend is what every driver implements.
+type backend interface {
+	storage.Storage
+	storage.TransactionStore
+}
+
+func open() (backend, func(), error) {
+	switch *driver {
+	case "file":
+		if *path != "" {
+			s, err := storage.OpenFileStorage(*path)
+			return s, func() {}, err
+		}
+
+		dir, err := ioutil.TempDir("", "storagetest")
+		if err != nil {
+			return nil, nil, err
+		}
+		cleanup := func() { os.RemoveAll(dir) }
+
+		s, err := storage.OpenFileStorage(filepath.Join(dir, "cashcoach.json"))
+		if err != nil {
+			cleanup()
+			return nil, nil, err
+		}
+		return s, cleanup, nil
+
+	case "mongo":
+		session, err := mgo.Dial(*mongoHost)
+		if err != nil {
+			return nil, nil, err
+		}
+		cleanup := func() {
+			session.DB(*mongoDB).DropDatabase()
+			session.Close()
+		}
+		return &storage.MongoStorage{Session: session, DB: *mongoDB}, cleanup, nil
+
+	case "fake":
+		return storage.NewFakeStorage(), func() {}, nil
+	}
+
+	return nil, nil, fmt.Errorf("unknown driver %q", *driver)
+}
+
+func main() {
+	flag.Parse()
+
+	s, cleanup, err := open()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	t := &logT{}
+	run(t, s)
+	cleanup()
+
+	if t.failed {
+		os.Exit(1)
+	}
+
+	fmt.Printf("%s: ok\n", *driver)
+}
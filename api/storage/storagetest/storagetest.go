@@ -0,0 +1,392 @@
+// Package storagetest is a conformance suite for storage.Storage and
+// storage.TransactionStore implementations.  Every backend should pass
+// it, so the API behaves the same whichever storage.driver is configured.
+package storagetest
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/pcarleton/cashcoach/api/money"
+	"github.com/pcarleton/cashcoach/api/plaid"
+	"github.com/pcarleton/cashcoach/api/storage"
+)
+
+// T is the part of *testing.T the suite uses, so it can also be run from
+// the conformance command.
+type T interface {
+	Errorf(format string, args ...interface{})
+	Fatalf(format string, args ...interface{})
+}
+
+// Run checks s against the Storage contract.  It only touches people whose
+// emails start with a random prefix, so it is safe to point at a backend
+// that already holds data, though the people it creates are left behind.
+func Run(t T, s storage.Storage) {
+	id, err := storage.NewAccountID()
+	if err != nil {
+		t.Fatalf("NewAccountID: %v", err)
+	}
+	prefix := "storagetest-" + id
+
+	email := prefix + "@example.com"
+	itemID := prefix + "-item"
+
+	// Missing people.
+	if _, err := s.Get(email); err != storage.ErrNotFound {
+		t.Errorf("Get(missing) = %v, want ErrNotFound", err)
+	}
+
+	if exists, err := s.Exists(email); err != nil || exists {
+		t.Errorf("Exists(missing) = %v, %v, want false, nil", exists, err)
+	}
+
+	if err := s.Update(&storage.Person{Email: email}); err != storage.ErrNotFound {
+		t.Errorf("Update(missing) = %v, want ErrNotFound", err)
+	}
+
+	if _, err := s.FindByItemID(itemID); err != storage.ErrNotFound {
+		t.Errorf("FindByItemID(missing) = %v, want ErrNotFound", err)
+	}
+
+	// Creating.
+	if created, err := s.Create(email); err != nil || !created {
+		t.Fatalf("Create = %v, %v, want true, nil", created, err)
+	}
+
+	if created, err := s.Create(email); err != nil || created {
+		t.Errorf("Create(existing) = %v, %v, want false, nil", created, err)
+	}
+
+	if exists, err := s.Exists(email); err != nil || !exists {
+		t.Errorf("Exists = %v, %v, want true, nil", exists, err)
+	}
+
+	p, err := s.Get(email)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+
+	if p.Email != email {
+		t.Errorf("Get returned %q, want %q", p.Email, email)
+	}
+
+	// Updating round-trips every account field, including the ones kept
+	// out of JSON.
+	want := fixtureAccount(prefix, itemID)
+	p.Accounts = append(p.Accounts, fixtureAccount(prefix, itemID))
+
+	if err := s.Update(p); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+
+	// Nor may changes to what was saved.
+	saved := &p.Accounts[len(p.Accounts)-1]
+	saved.Masks[0] = "9999"
+	saved.SealedToken.Ciphertext[0] = 'X'
+
+	p, err = s.Get(email)
+	if err != nil {
+		t.Fatalf("Get after Update: %v", err)
+	}
+
+	got := p.AccountByID(want.ID)
+	if got == nil {
+		t.Fatalf("account %s missing after Update", want.ID)
+	}
+	checkAccount(t, "Get", *got, want)
+
+	// Changes to what callers hold must not leak into the store.
+	got.Name = "changed"
+	got.Masks[0] = "9999"
+	got.SealedToken.KeyID = "changed"
+	got.SealedToken.DataKey[0] = 'X'
+
+	p, err = s.Get(email)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if acct := p.AccountByID(want.ID); acct != nil {
+		checkAccount(t, "Get after mutating a result", *acct, want)
+	}
+
+	// Saving sync state changes only that account's sync fields.
+	state := storage.SyncState{
+		Cursor:   "cursor-2",
+		LastSync: time.Date(2018, 4, 5, 6, 7, 8, 9e6, time.UTC),
+	}
+	if err := s.UpdateSyncState(email, want.ID, state); err != nil {
+		t.Fatalf("UpdateSyncState: %v", err)
+	}
+	want.SetSyncState(state)
+
+	p, err = s.Get(email)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if acct := p.AccountByID(want.ID); acct != nil {
+		checkAccount(t, "Get after UpdateSyncState", *acct, want)
+	} else {
+		t.Errorf("account %s missing after UpdateSyncState", want.ID)
+	}
+
+	if err := s.UpdateSyncState(email, prefix+"-missing", state); err != storage.ErrNotFound {
+		t.Errorf("UpdateSyncState(missing account) = %v, want ErrNotFound", err)
+	}
+	if err := s.UpdateSyncState(prefix+"-missing@example.com", want.ID, state); err != storage.ErrNotFound {
+		t.Errorf("UpdateSyncState(missing person) = %v, want ErrNotFound", err)
+	}
+
+	// Finding.
+	found, err := s.FindByItemID(itemID)
+	if err != nil {
+		t.Fatalf("FindByItemID: %v", err)
+	}
+	if found.Email != email {
+		t.Errorf("FindByItemID returned %q, want %q", found.Email, email)
+	}
+
+	other := prefix + "-other@example.com"
+	if _, err := s.Create(other); err != nil {
+		t.Fatalf("Create(%s): %v", other, err)
+	}
+
+	people, err := s.All()
+	if err != nil {
+		t.Fatalf("All: %v", err)
+	}
+
+	seen := map[string]bool{}
+	for _, p := range people {
+		seen[p.Email] = true
+	}
+	for _, e := range []string{email, other} {
+		if !seen[e] {
+			t.Errorf("All is missing %s", e)
+		}
+	}
+
+	// Removing accounts.
+	p.Accounts = nil
+	if err := s.Update(p); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+
+	if _, err := s.FindByItemID(itemID); err != storage.ErrNotFound {
+		t.Errorf("FindByItemID after removing the account = %v, want ErrNotFound", err)
+	}
+
+	p, err = s.Get(email)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if len(p.Accounts) != 0 {
+		t.Errorf("Get after removing accounts returned %d accounts", len(p.Accounts))
+	}
+}
+
+func fixtureAccount(prefix, itemID string) storage.Account {
+	return storage.Account{
+		ID:    prefix + "-account",
+		Name:  "checking",
+		Token: "access-sandbox-" + prefix,
+		SealedToken: &storage.Envelope{
+			KeyID:      "k1",
+			DataKey:    []byte("data key"),
+			Ciphertext: []byte("ciphertext"),
+		},
+		ItemID:        itemID,
+		InstitutionID: "ins_1",
+		Masks:         []string{"0000", "3333"},
+		Cursor:        "cursor-1",
+		// Mongo keeps milliseconds.
+		LastSync:       time.Date(2018, 3, 4, 5, 6, 7, 8e6, time.UTC),
+		SyncError:      "ITEM_LOGIN_REQUIRED",
+		SyncErrorClass: "item-login-required",
+	}
+}
+
+func checkAccount(t T, what string, got, want storage.Account) {
+	mismatch := func(field string, g, w interface{}) {
+		t.Errorf("%s: account %s = %v, want %v", what, field, g, w)
+	}
+
+	if got.ID != want.ID {
+		mismatch("ID", got.ID, want.ID)
+	}
+	if got.Name != want.Name {
+		mismatch("Name", got.Name, want.Name)
+	}
+	if got.Token != want.Token {
+		mismatch("Token", got.Token, want.Token)
+	}
+	if !reflect.DeepEqual(got.SealedToken, want.SealedToken) {
+		mismatch("SealedToken", describe(got.SealedToken), describe(want.SealedToken))
+	}
+	if got.ItemID != want.ItemID {
+		mismatch("ItemID", got.ItemID, want.ItemID)
+	}
+	if got.InstitutionID != want.InstitutionID {
+		mismatch("InstitutionID", got.InstitutionID, want.InstitutionID)
+	}
+	if !reflect.DeepEqual(got.Masks, want.Masks) {
+		mismatch("Masks", got.Masks, want.Masks)
+	}
+	if got.Cursor != want.Cursor {
+		mismatch("Cursor", got.Cursor, want.Cursor)
+	}
+	if !got.LastSync.Equal(want.LastSync) {
+		mismatch("LastSync", got.LastSync, want.LastSync)
+	}
+	if got.SyncError != want.SyncError {
+		mismatch("SyncError", got.SyncError, want.SyncError)
+	}
+	if got.SyncErrorClass != want.SyncErrorClass {
+		mismatch("SyncErrorClass", got.SyncErrorClass, want.SyncErrorClass)
+	}
+}
+
+func describe(env *storage.Envelope) string {
+	if env == nil {
+		return "nil"
+	}
+	return fmt.Sprintf("{%s %q %q}", env.KeyID, env.DataKey, env.Ciphertext)
+}
+
+// RunTransactions checks s against the TransactionStore contract, using
+// people whose emails start with a random prefix.
+func RunTransactions(t T, s storage.TransactionStore) {
+	id, err := storage.NewAccountID()
+	if err != nil {
+		t.Fatalf("NewAccountID: %v", err)
+	}
+	email := "storagetest-" + id + "@example.com"
+	other := "storagetest-" + id + "-other@example.com"
+
+	txn := func(id, account, date string, dollars int64) storage.Transaction {
+		return storage.Transaction{
+			Account: account,
+			Transaction: plaid.Transaction{
+				ID:       id,
+				Date:     date,
+				Amount:   money.Cents(dollars * 100),
+				Name:     "txn " + id,
+				Category: []string{"Shops"},
+			},
+		}
+	}
+
+	day := func(s string) time.Time {
+		d, err := time.Parse(plaid.DateFmt, s)
+		if err != nil {
+			t.Fatalf("bad date %s: %v", s, err)
+		}
+		return d
+	}
+
+	check := func(what string, q storage.TransactionQuery, want ...string) {
+		txns, err := s.Transactions(email, q)
+		if err != nil {
+			t.Fatalf("%s: %v", what, err)
+		}
+		got := transactionIDs(txns)
+		if len(got) != len(want) || len(want) > 0 && !reflect.DeepEqual(got, want) {
+			t.Errorf("%s = %v, want %v", what, got, want)
+		}
+	}
+
+	all := storage.TransactionQuery{}
+
+	check("Transactions(empty)", all)
+
+	err = s.UpsertTransactions(email, []storage.Transaction{
+		txn("t1", "a", "2018-01-01", 1),
+		txn("t2", "a", "2018-01-03", 2),
+		txn("t3", "b", "2018-01-02", 3),
+		txn("t4", "b", "2018-01-03", 4),
+	})
+	if err != nil {
+		t.Fatalf("UpsertTransactions: %v", err)
+	}
+
+	if err := s.UpsertTransactions(other, []storage.Transaction{txn("t1", "a", "2018-01-01", 9)}); err != nil {
+		t.Fatalf("UpsertTransactions(%s): %v", other, err)
+	}
+
+	check("Transactions", all, "t2", "t4", "t3", "t1")
+	check("Transactions by date",
+		storage.TransactionQuery{Start: day("2018-01-02"), End: day("2018-01-03")},
+		"t2", "t4", "t3")
+	check("Transactions from a date", storage.TransactionQuery{Start: day("2018-01-03")}, "t2", "t4")
+	check("Transactions until a date", storage.TransactionQuery{End: day("2018-01-01")}, "t1")
+	check("Transactions by account", storage.TransactionQuery{Accounts: []string{"b"}}, "t4", "t3")
+
+	txns, err := s.Transactions(email, storage.TransactionQuery{End: day("2018-01-01")})
+	if err != nil {
+		t.Fatalf("Transactions: %v", err)
+	}
+	if len(txns) == 1 {
+		got := txns[0]
+		if got.Email != email || got.Account != "a" || got.Amount != money.Cents(100) || got.Name != "txn t1" ||
+			!reflect.DeepEqual(got.Category, []string{"Shops"}) {
+			t.Errorf("Transactions returned %+v", got)
+		}
+	}
+
+	// Upserting replaces by ID.
+	if err := s.UpsertTransactions(email, []storage.Transaction{txn("t1", "a", "2018-01-01", 10)}); err != nil {
+		t.Fatalf("UpsertTransactions: %v", err)
+	}
+
+	txns, err = s.Transactions(email, storage.TransactionQuery{End: day("2018-01-01")})
+	if err != nil {
+		t.Fatalf("Transactions: %v", err)
+	}
+	if len(txns) != 1 || txns[0].Amount != money.Cents(1000) {
+		t.Errorf("after upserting t1 again, got %+v", txns)
+	}
+
+	// A posted transaction replaces its pending one.
+	pending := txn("p1", "a", "2018-01-04", 5)
+	pending.Pending = true
+	if err := s.UpsertTransactions(email, []storage.Transaction{pending}); err != nil {
+		t.Fatalf("UpsertTransactions: %v", err)
+	}
+	check("Transactions with pending", all, "p1", "t2", "t4", "t3", "t1")
+
+	posted := txn("p1-posted", "a", "2018-01-05", 5)
+	posted.PendingTransactionID = "p1"
+	if err := s.UpsertTransactions(email, []storage.Transaction{posted}); err != nil {
+		t.Fatalf("UpsertTransactions: %v", err)
+	}
+	check("Transactions after posting", all, "p1-posted", "t2", "t4", "t3", "t1")
+
+	// Removing.
+	if err := s.RemoveTransactions(email, []string{"t2", "missing"}); err != nil {
+		t.Fatalf("RemoveTransactions: %v", err)
+	}
+	check("Transactions after removing t2", all, "p1-posted", "t4", "t3", "t1")
+
+	if err := s.RemoveAccountTransactions(email, "b"); err != nil {
+		t.Fatalf("RemoveAccountTransactions: %v", err)
+	}
+	check("Transactions after removing account b", all, "p1-posted", "t1")
+
+	// Nothing above touched the other person.
+	txns, err = s.Transactions(other, all)
+	if err != nil {
+		t.Fatalf("Transactions(%s): %v", other, err)
+	}
+	if len(txns) != 1 || txns[0].Amount != money.Cents(900) {
+		t.Errorf("Transactions(%s) = %+v, want only its own t1", other, txns)
+	}
+}
+
+func transactionIDs(txns []storage.Transaction) []string {
+	ids := make([]string, 0, len(txns))
+	for _, t := range txns {
+		ids = append(ids, t.ID)
+	}
+	return ids
+}
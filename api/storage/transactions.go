@@ -0,0 +1,169 @@
+package storage
+
+import (
+	"sort"
+	"time"
+
+	"github.com/pcarleton/cashcoach/api/plaid"
+)
+
+// Transaction is a Plaid transaction saved for a person.  Transactions are
+// keyed by Email and the Plaid transaction ID.
+type Transaction struct {
+	Email string `json:"email" bson:"email"`
+
+	// Account is the ID of the Account the transaction came from.
+	Account string `json:"account" bson:"account"`
+
+	plaid.Transaction `bson:",inline"`
+}
+
+// TransactionQuery selects a person's transactions.  Dates are inclusive
+// and a zero Start or End leaves that side open.  An empty Accounts
+// matches every account.
+type TransactionQuery struct {
+	Accounts []string
+	Start    time.Time
+	End      time.Time
+}
+
+// TransactionStore holds the transactions synced from Plaid, so reading
+// them doesn't mean a round trip to the bank.
+type TransactionStore interface {
+	// UpsertTransactions adds transactions or replaces the stored ones
+	// with the same ID.  A posted transaction also replaces the pending
+	// transaction it names.
+	UpsertTransactions(email string, txns []Transaction) error
+
+	// RemoveTransactions deletes transactions by ID.  Unknown IDs are
+	// ignored.
+	RemoveTransactions(email string, ids []string) error
+
+	// RemoveAccountTransactions deletes every transaction from an account.
+	RemoveAccountTransactions(email, account string) error
+
+	// Transactions returns the matching transactions, newest first.
+	Transactions(email string, q TransactionQuery) ([]Transaction, error)
+}
+
+func (q TransactionQuery) start() string {
+	if q.Start.IsZero() {
+		return ""
+	}
+	return q.Start.Format(plaid.DateFmt)
+}
+
+func (q TransactionQuery) end() string {
+	if q.End.IsZero() {
+		return ""
+	}
+	return q.End.Format(plaid.DateFmt)
+}
+
+func (q TransactionQuery) matches(t Transaction) bool {
+	if start := q.start(); start != "" && t.Date < start {
+		return false
+	}
+
+	if end := q.end(); end != "" && t.Date > end {
+		return false
+	}
+
+	if len(q.Accounts) == 0 {
+		return true
+	}
+
+	for _, acct := range q.Accounts {
+		if t.Account == acct {
+			return true
+		}
+	}
+	return false
+}
+
+// SortTransactions orders transactions newest first, like Plaid, with a
+// stable order within a day.
+func SortTransactions(txns []Transaction) {
+	sort.SliceStable(txns, func(i, j int) bool {
+		a, b := txns[i], txns[j]
+		if a.Date != b.Date {
+			return a.Date > b.Date
+		}
+		if a.Account != b.Account {
+			return a.Account < b.Account
+		}
+		return a.ID < b.ID
+	})
+}
+
+// transactionMap is the in-memory store behind FakeStorage and
+// FileStorage: transactions by email, then by ID.
+type transactionMap map[string]map[string]Transaction
+
+func (m transactionMap) upsert(email string, txns []Transaction) {
+	byID := m[email]
+	if byID == nil {
+		byID = make(map[string]Transaction)
+		m[email] = byID
+	}
+
+	for _, t := range txns {
+		if t.PendingTransactionID != "" {
+			delete(byID, t.PendingTransactionID)
+		}
+
+		t.Email = email
+		t.Category = copyStrings(t.Category)
+		byID[t.ID] = t
+	}
+}
+
+func (m transactionMap) remove(email string, ids []string) {
+	for _, id := range ids {
+		delete(m[email], id)
+	}
+}
+
+func (m transactionMap) removeAccount(email, account string) {
+	for id, t := range m[email] {
+		if t.Account == account {
+			delete(m[email], id)
+		}
+	}
+}
+
+func (m transactionMap) query(email string, q TransactionQuery) []Transaction {
+	txns := make([]Transaction, 0)
+	for _, t := range m[email] {
+		if q.matches(t) {
+			t.Category = copyStrings(t.Category)
+			txns = append(txns, t)
+		}
+	}
+
+	SortTransactions(txns)
+	return txns
+}
+
+// snapshot copies one person's transactions, so a failed write can be
+// rolled back with restore.
+func (m transactionMap) snapshot(email string) map[string]Transaction {
+	byID, ok := m[email]
+	if !ok {
+		return nil
+	}
+
+	c := make(map[string]Transaction, len(byID))
+	for id, t := range byID {
+		c[id] = t
+	}
+	return c
+}
+
+func (m transactionMap) restore(email string, byID map[string]Transaction) {
+	if byID == nil {
+		delete(m, email)
+		return
+	}
+	m[email] = byID
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pcarleton/cashcoach/api/clock"
+	"github.com/pcarleton/cashcoach/api/plaid"
+	"github.com/pcarleton/cashcoach/api/storage"
+)
+
+// syncJob asks for one account's item to be synced.
+type syncJob struct {
+	Email  string
+	ItemID string
+}
+
+// syncer runs account syncs in the background on a fixed number of
+// workers, saving what it fetches to the transaction store.  Jobs for an
+// item that is already queued are dropped, since the queued sync will pick
+// up the same changes.  A job for an item that is being synced right now
+// is run again once that sync finishes, so no webhook is missed.
+type syncer struct {
+	store        storage.Storage
+	transactions storage.TransactionStore
+	plaid        plaid.Client
+	clock        clock.Clock
+	workers      int
+	jobs         chan syncJob
+
+	mu      sync.Mutex
+	pending map[syncJob]bool
+	running map[syncJob]bool
+	again   map[syncJob]bool
+	status  map[syncJob]syncStatus
+}
+
+// syncStatus is how an item's last sync went.  It is only kept in memory;
+// after a restart the account's LastSync and SyncError stand in for it.
+type syncStatus struct {
+	LastRun  time.Time
+	Duration time.Duration
+	Error    string
+	Class    plaid.ErrorClass
+
+	// Failures counts the syncs that have failed in a row.
+	Failures int
+}
+
+const syncQueueSize = 100
+
+func newSyncer(store storage.Storage, transactions storage.TransactionStore, client plaid.Client,
+	workers int, clk clock.Clock) *syncer {
+	if workers < 1 {
+		workers = 1
+	}
+
+	return &syncer{
+		store:        store,
+		transactions: transactions,
+		plaid:        client,
+		clock:        clk,
+		workers:      workers,
+		jobs:         make(chan syncJob, syncQueueSize),
+		pending:      make(map[syncJob]bool),
+		running:      make(map[syncJob]bool),
+		again:        make(map[syncJob]bool),
+		status:       make(map[syncJob]syncStatus),
+	}
+}
+
+// enqueue schedules job, returning false if the queue is full.
+func (s *syncer) enqueue(job syncJob) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.enqueueLocked(job)
+}
+
+func (s *syncer) enqueueLocked(job syncJob) bool {
+	if s.pending[job] {
+		return true
+	}
+
+	if s.running[job] {
+		s.again[job] = true
+		return true
+	}
+
+	select {
+	case s.jobs <- job:
+		s.pending[job] = true
+		return true
+	default:
+		return false
+	}
+}
+
+// lastStatus returns the item's last sync status, and whether it has been
+// synced since the server started.
+func (s *syncer) lastStatus(job syncJob) (syncStatus, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status, ok := s.status[job]
+	return status, ok
+}
+
+// state reports whether the job is waiting in the queue or being synced.
+func (s *syncer) state(job syncJob) (queued, running bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.pending[job], s.running[job]
+}
+
+// run processes jobs on the syncer's workers until ctx is done, and
+// returns once every sync in progress has finished.
+func (s *syncer) run(ctx context.Context) {
+	var wg sync.WaitGroup
+
+	for i := 0; i < s.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.work(ctx)
+		}()
+	}
+
+	wg.Wait()
+}
+
+func (s *syncer) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-s.jobs:
+			s.process(ctx, job)
+		}
+	}
+}
+
+// process runs one job taken off the queue.
+func (s *syncer) process(ctx context.Context, job syncJob) {
+	s.mu.Lock()
+	delete(s.pending, job)
+	s.running[job] = true
+	s.mu.Unlock()
+
+	start := s.clock.Now()
+	err := s.sync(ctx, job)
+	s.finish(job, start, err)
+
+	if err != nil && ctx.Err() == nil {
+		log.Printf("Sync of item %s for %s failed: %v", job.ItemID, job.Email, err)
+	}
+}
+
+// finish records how the job went and re-queues it if it was asked for
+// while it ran.
+func (s *syncer) finish(job syncJob, start time.Time, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.running, job)
+
+	// A sync cut short by shutdown says nothing about the item.
+	if err == context.Canceled {
+		return
+	}
+
+	status := s.status[job]
+	status.LastRun = start
+	status.Duration = s.clock.Now().Sub(start)
+	status.Error = ""
+	status.Class = plaid.ClassUnknown
+
+	if err != nil {
+		status.Error = err.Error()
+		status.Class = plaid.ErrorClassOf(err)
+		status.Failures++
+	} else {
+		status.Failures = 0
+	}
+	s.status[job] = status
+
+	if s.again[job] {
+		delete(s.again, job)
+		s.enqueueLocked(job)
+	}
+}
+
+// sync pulls changes for the job's item into the transaction store and
+// saves the new cursor, or the error if it failed.  The cursor only moves
+// once the changes are stored, so a failed sync is retried from the same
+// place.  An account that has never synced has no cursor, which fetches
+// its whole history.  Only the account's sync state is written back, so
+// renames and other changes made while Plaid was being asked are kept.
+func (s *syncer) sync(ctx context.Context, job syncJob) error {
+	person, err := s.store.Get(job.Email)
+	if err != nil {
+		return err
+	}
+
+	acct := person.AccountByItemID(job.ItemID)
+	if acct == nil {
+		return fmt.Errorf("%s has no account for item %s", job.Email, job.ItemID)
+	}
+
+	resp, syncErr := s.plaid.SyncAll(ctx, acct.Token, acct.Cursor)
+
+	// Leave the account as it was if the server is shutting down.
+	if ctx.Err() != nil {
+		return context.Canceled
+	}
+
+	if syncErr == nil {
+		syncErr = s.save(person.Email, acct.ID, resp)
+	}
+
+	state := storage.SyncState{
+		Cursor:   acct.Cursor,
+		LastSync: s.clock.Now(),
+	}
+	if syncErr != nil {
+		state.SyncError = syncErr.Error()
+		state.SyncErrorClass = plaid.ErrorClassOf(syncErr).String()
+	} else {
+		state.Cursor = resp.NextCursor
+		log.Printf("Synced %s for %s: %d added, %d modified, %d removed", acct.Name,
+			job.Email, len(resp.Added), len(resp.Modified), len(resp.Removed))
+	}
+
+	err = s.store.UpdateSyncState(person.Email, acct.ID, state)
+
+	// The account was deleted while it synced, so drop what was just
+	// stored for it.
+	if err == storage.ErrNotFound {
+		return s.transactions.RemoveAccountTransactions(person.Email, acct.ID)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return syncErr
+}
+
+// save stores one sync's worth of changes to an account.
+func (s *syncer) save(email, account string, resp plaid.SyncResponse) error {
+	changed := make([]storage.Transaction, 0, len(resp.Added)+len(resp.Modified))
+	for _, t := range resp.Added {
+		changed = append(changed, storage.Transaction{Account: account, Transaction: t})
+	}
+	for _, t := range resp.Modified {
+		changed = append(changed, storage.Transaction{Account: account, Transaction: t})
+	}
+
+	if err := s.transactions.UpsertTransactions(email, changed); err != nil {
+		return fmt.Errorf("unable to store transactions: %v", err)
+	}
+
+	removed := make([]string, len(resp.Removed))
+	for i, r := range resp.Removed {
+		removed[i] = r.ID
+	}
+
+	if len(removed) > 0 {
+		if err := s.transactions.RemoveTransactions(email, removed); err != nil {
+			return fmt.Errorf("unable to remove transactions: %v", err)
+		}
+	}
+
+	return nil
+}
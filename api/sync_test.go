@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pcarleton/cashcoach/api/clock"
+	"github.com/pcarleton/cashcoach/api/plaid"
+	"github.com/pcarleton/cashcoach/api/storage"
+)
+
+var syncStart = time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// fakeSync is a /transactions/sync endpoint that fails with Error if it's
+// set, and otherwise hands out one transaction per call.  During, if set,
+// runs while the request is being answered, like a user acting in the
+// middle of a slow sync.
+type fakeSync struct {
+	mu     sync.Mutex
+	Error  *plaid.ErrorResponse
+	During func()
+	calls  int
+}
+
+func (f *fakeSync) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.calls++
+	if f.During != nil {
+		f.During()
+	}
+
+	if f.Error != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(f.Error)
+		return
+	}
+
+	json.NewEncoder(w).Encode(plaid.SyncResponse{
+		Added:      []plaid.Transaction{{ID: "txn-" + strconv.Itoa(f.calls), Date: "2018-03-01"}},
+		NextCursor: "cursor-" + strconv.Itoa(f.calls),
+	})
+}
+
+func (f *fakeSync) setError(errResp *plaid.ErrorResponse) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Error = errResp
+}
+
+// setupSync makes a store holding someone with a checking account on
+// item-checking, and a syncer talking to fake over a fake clock.
+func setupSync(t *testing.T, fake *fakeSync) (*storage.FakeStorage, *syncer, *clock.Fake, func()) {
+	t.Helper()
+	srv := httptest.NewServer(fake)
+
+	client := plaid.NewClient("id", "secret", srv.URL)
+	client.SetRetryPolicy(plaid.NoRetries)
+	client.SetRateLimit(0, 0)
+
+	store := storage.NewFakeStorage()
+	store.Create("someone@example.com")
+	err := store.Update(&storage.Person{Email: "someone@example.com", Accounts: []storage.Account{
+		{ID: "checking", Name: "checking", Token: "access-checking", ItemID: "item-checking"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clk := clock.NewFake(syncStart)
+	return store, newSyncer(store, store, client, 1, clk), clk, srv.Close
+}
+
+var checkingJob = syncJob{Email: "someone@example.com", ItemID: "item-checking"}
+
+func TestSyncKeepsConcurrentChanges(t *testing.T) {
+	fake := &fakeSync{}
+	store, syncs, _, done := setupSync(t, fake)
+	defer done()
+
+	fake.During = func() {
+		p, _ := store.Get("someone@example.com")
+		p.AccountByID("checking").Name = "renamed"
+		p.Accounts = append(p.Accounts, storage.Account{ID: "savings", Name: "savings"})
+		store.Update(p)
+	}
+
+	if err := syncs.sync(context.Background(), checkingJob); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+
+	p, err := store.Get("someone@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	acct := p.AccountByID("checking")
+	if acct.Name != "renamed" {
+		t.Errorf("rename during the sync was lost: name is %q", acct.Name)
+	}
+	if p.AccountByID("savings") == nil {
+		t.Error("account added during the sync was lost")
+	}
+	if acct.Cursor != "cursor-1" || !acct.LastSync.Equal(syncStart) {
+		t.Errorf("got cursor %q and last sync %v", acct.Cursor, acct.LastSync)
+	}
+}
+
+func TestSyncDeletedAccount(t *testing.T) {
+	fake := &fakeSync{}
+	store, syncs, _, done := setupSync(t, fake)
+	defer done()
+
+	fake.During = func() {
+		store.Update(&storage.Person{Email: "someone@example.com"})
+	}
+
+	if err := syncs.sync(context.Background(), checkingJob); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+
+	txns, err := store.Transactions("someone@example.com", storage.TransactionQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(txns) != 0 {
+		t.Errorf("kept %d transactions for a deleted account", len(txns))
+	}
+}
+
+// runDue ticks the scheduler and runs whatever it queued, returning
+// whether the checking account was synced.
+func runDue(sched *scheduler, syncs *syncer) bool {
+	sched.tick()
+
+	ran := false
+	for {
+		select {
+		case job := <-syncs.jobs:
+			syncs.process(context.Background(), job)
+			ran = ran || job == checkingJob
+		default:
+			return ran
+		}
+	}
+}
+
+func TestSchedulerBackoff(t *testing.T) {
+	fake := &fakeSync{Error: &plaid.ErrorResponse{Type: "API_ERROR", Code: "INTERNAL_SERVER_ERROR"}}
+	store, syncs, clk, done := setupSync(t, fake)
+	defer done()
+
+	sched := newScheduler(store, syncs, clk, scheduleConfig{
+		Interval:   6 * time.Hour,
+		Workers:    1,
+		Backoff:    5 * time.Minute,
+		MaxBackoff: 30 * time.Minute,
+	})
+
+	// Never synced, so due straight away.
+	if !runDue(sched, syncs) {
+		t.Fatal("first tick didn't sync")
+	}
+
+	// Failures wait 5, 10, 20 and then at most 30 minutes.
+	for i, wait := range []time.Duration{5, 10, 20, 30, 30} {
+		wait *= time.Minute
+
+		clk.Advance(wait - time.Minute)
+		if runDue(sched, syncs) {
+			t.Fatalf("failure %d: synced after %v, want %v", i+1, wait-time.Minute, wait)
+		}
+
+		clk.Advance(time.Minute)
+		if !runDue(sched, syncs) {
+			t.Fatalf("failure %d: didn't sync after %v", i+1, wait)
+		}
+	}
+
+	p, _ := store.Get("someone@example.com")
+	if acct := p.AccountByID("checking"); acct.SyncErrorClass != plaid.ClassServer.String() {
+		t.Errorf("saved error class %q, want %q", acct.SyncErrorClass, plaid.ClassServer)
+	}
+
+	// Success goes back to the regular interval.
+	fake.setError(nil)
+	clk.Advance(30 * time.Minute)
+	if !runDue(sched, syncs) {
+		t.Fatal("didn't retry after the backoff")
+	}
+
+	clk.Advance(6*time.Hour - time.Minute)
+	if runDue(sched, syncs) {
+		t.Error("synced before the interval was up")
+	}
+	clk.Advance(time.Minute)
+	if !runDue(sched, syncs) {
+		t.Error("didn't sync once the interval was up")
+	}
+
+	p, _ = store.Get("someone@example.com")
+	if acct := p.AccountByID("checking"); acct.SyncError != "" {
+		t.Errorf("error %q kept after a successful sync", acct.SyncError)
+	}
+}
+
+func TestSchedulerLoginRequired(t *testing.T) {
+	fake := &fakeSync{Error: &plaid.ErrorResponse{Type: "ITEM_ERROR", Code: "ITEM_LOGIN_REQUIRED"}}
+	store, syncs, clk, done := setupSync(t, fake)
+	defer done()
+
+	sched := newScheduler(store, syncs, clk, scheduleConfig{
+		Interval:   6 * time.Hour,
+		Workers:    1,
+		Backoff:    5 * time.Minute,
+		MaxBackoff: 24 * time.Hour,
+	})
+
+	if !runDue(sched, syncs) {
+		t.Fatal("first tick didn't sync")
+	}
+
+	// Retrying can't help until the user logs in again.
+	clk.Advance(24*time.Hour - time.Minute)
+	if runDue(sched, syncs) {
+		t.Error("retried an item that needs a login before MaxBackoff")
+	}
+	clk.Advance(time.Minute)
+	if !runDue(sched, syncs) {
+		t.Error("didn't check back after MaxBackoff")
+	}
+}
+
+// TestSchedulerRestart checks that the backoff carries over a restart
+// through what was saved on the account.
+func TestSchedulerRestart(t *testing.T) {
+	fake := &fakeSync{Error: &plaid.ErrorResponse{Type: "API_ERROR", Code: "INTERNAL_SERVER_ERROR"}}
+	store, syncs, clk, done := setupSync(t, fake)
+	defer done()
+
+	cfg := scheduleConfig{Interval: 6 * time.Hour, Workers: 1, Backoff: 5 * time.Minute, MaxBackoff: time.Hour}
+	if !runDue(newScheduler(store, syncs, clk, cfg), syncs) {
+		t.Fatal("first tick didn't sync")
+	}
+
+	// A new syncer knows nothing, so the saved error stands in.
+	syncs = newSyncer(store, store, syncs.plaid, 1, clk)
+	sched := newScheduler(store, syncs, clk, cfg)
+
+	clk.Advance(4 * time.Minute)
+	if runDue(sched, syncs) {
+		t.Error("synced before the backoff was up")
+	}
+	clk.Advance(time.Minute)
+	if !runDue(sched, syncs) {
+		t.Error("didn't sync once the backoff was up")
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pcarleton/cashcoach/api/auth"
+	"github.com/pcarleton/cashcoach/api/plaid"
+	"github.com/pcarleton/cashcoach/api/rules"
+	"github.com/pcarleton/cashcoach/api/storage"
+)
+
+// AccountTransaction is a Plaid transaction tagged with the ID and name of
+// the account it came from, and categorized by the configured rules.
+type AccountTransaction struct {
+	plaid.Transaction
+	Account        string       `json:"account"`
+	AccountName    string       `json:"account_name"`
+	Categorization rules.Result `json:"categorization"`
+}
+
+// AccountError reports an account that couldn't be fetched.  Class is the
+// plaid.ErrorClass, so the UI can tell a re-login apart from an outage.
+type AccountError struct {
+	Account     string `json:"account"`
+	AccountName string `json:"account_name"`
+	Error       string `json:"error"`
+	Class       string `json:"class"`
+}
+
+type TransactionsResult struct {
+	Transactions []AccountTransaction `json:"transactions"`
+	Errors       []AccountError       `json:"errors"`
+}
+
+// transactionsQuery is parsed from the account, start and end query
+// parameters.  Account is an AccountView ID.  Dates are inclusive and
+// default to the last month.
+type transactionsQuery struct {
+	Account string
+	Start   time.Time
+	End     time.Time
+}
+
+func parseTransactionsQuery(r *http.Request) (transactionsQuery, error) {
+	values := r.URL.Query()
+
+	q := transactionsQuery{
+		Account: values.Get("account"),
+		End:     time.Now(),
+	}
+
+	if end := values.Get("end"); end != "" {
+		t, err := time.Parse(plaid.DateFmt, end)
+		if err != nil {
+			return q, fmt.Errorf("invalid end date %q", end)
+		}
+		q.End = t
+	}
+
+	q.Start = q.End.AddDate(0, -1, 0)
+	if start := values.Get("start"); start != "" {
+		t, err := time.Parse(plaid.DateFmt, start)
+		if err != nil {
+			return q, fmt.Errorf("invalid start date %q", start)
+		}
+		q.Start = t
+	}
+
+	if q.Start.After(q.End) {
+		return q, fmt.Errorf("start must not be after end")
+	}
+
+	return q, nil
+}
+
+// storedTransactions reads the accounts' transactions from the store.
+// Accounts whose last sync failed are reported in Errors; what was stored
+// before the failure is still returned.
+func storedTransactions(email string, accts []storage.Account, q transactionsQuery) (TransactionsResult, error) {
+	result := TransactionsResult{
+		Transactions: make([]AccountTransaction, 0),
+		Errors:       make([]AccountError, 0),
+	}
+
+	names := make(map[string]string, len(accts))
+	ids := make([]string, len(accts))
+	for i, acct := range accts {
+		names[acct.ID] = acct.Name
+		ids[i] = acct.ID
+
+		if acct.SyncError != "" {
+			result.Errors = append(result.Errors, AccountError{
+				Account:     acct.ID,
+				AccountName: acct.Name,
+				Error:       acct.SyncError,
+				Class:       acct.SyncErrorClass,
+			})
+		}
+	}
+
+	if len(ids) == 0 {
+		return result, nil
+	}
+
+	txns, err := config.Transactions.Transactions(email, storage.TransactionQuery{
+		Accounts: ids,
+		Start:    q.Start,
+		End:      q.End,
+	})
+
+	if err != nil {
+		return result, err
+	}
+
+	for _, t := range txns {
+		name := names[t.Account]
+		result.Transactions = append(result.Transactions, AccountTransaction{
+			Transaction:    t.Transaction,
+			Account:        t.Account,
+			AccountName:    name,
+			Categorization: config.Rules.Categorize(rules.FromPlaid(t.Transaction, name)),
+		})
+	}
+
+	return result, nil
+}
+
+// liveTransactions fetches accounts' transactions straight from Plaid,
+// for accounts that can't be synced because their item isn't known.
+// Accounts that fail are reported in Errors rather than failing the rest.
+func liveTransactions(ctx context.Context, accts []storage.Account, q transactionsQuery) TransactionsResult {
+	results := make([][]AccountTransaction, len(accts))
+	errs := make([]*AccountError, len(accts))
+
+	var wg sync.WaitGroup
+	for i, acct := range accts {
+		wg.Add(1)
+		go func(i int, acct storage.Account) {
+			defer wg.Done()
+
+			resp, err := config.Plaid.AllTransactions(ctx, acct.Token, q.Start, q.End, nil)
+			if err != nil {
+				errs[i] = &AccountError{
+					Account:     acct.ID,
+					AccountName: acct.Name,
+					Error:       err.Error(),
+					Class:       plaid.ErrorClassOf(err).String(),
+				}
+				return
+			}
+
+			trans := make([]AccountTransaction, len(resp.Transactions))
+			for j, t := range resp.Transactions {
+				trans[j] = AccountTransaction{
+					Transaction:    t,
+					Account:        acct.ID,
+					AccountName:    acct.Name,
+					Categorization: config.Rules.Categorize(rules.FromPlaid(t, acct.Name)),
+				}
+			}
+			results[i] = trans
+		}(i, acct)
+	}
+	wg.Wait()
+
+	result := TransactionsResult{
+		Transactions: make([]AccountTransaction, 0),
+		Errors:       make([]AccountError, 0),
+	}
+
+	for i := range accts {
+		result.Transactions = append(result.Transactions, results[i]...)
+		if errs[i] != nil {
+			result.Errors = append(result.Errors, *errs[i])
+		}
+	}
+
+	return result
+}
+
+// sortAccountTransactions orders transactions like
+// storage.SortTransactions.
+func sortAccountTransactions(txns []AccountTransaction) {
+	sort.SliceStable(txns, func(i, j int) bool {
+		a, b := txns[i], txns[j]
+		if a.Date != b.Date {
+			return a.Date > b.Date
+		}
+		if a.Account != b.Account {
+			return a.Account < b.Account
+		}
+		return a.ID < b.ID
+	})
+}
+
+// syncNew queues a first sync for accounts that have never had one, such
+// as accounts linked before transactions were stored.
+func syncNew(email string, accts []storage.Account) {
+	for _, acct := range accts {
+		if acct.LastSync.IsZero() && acct.ItemID != "" {
+			syncs.enqueue(syncJob{Email: email, ItemID: acct.ItemID})
+		}
+	}
+}
+
+func transactionsHandler(profile *auth.Profile, w http.ResponseWriter, r *http.Request) *appError {
+	person, appErr := loadPerson(profile)
+
+	if appErr != nil {
+		return appErr
+	}
+
+	q, err := parseTransactionsQuery(r)
+
+	if err != nil {
+		return &appError{err, err.Error(), http.StatusBadRequest}
+	}
+
+	accts := person.Accounts
+	if q.Account != "" {
+		acct := person.AccountByID(q.Account)
+
+		if acct == nil {
+			return accountNotFound(q.Account)
+		}
+
+		accts = []storage.Account{*acct}
+	}
+
+	if backfillItems(r.Context(), config.Plaid, person) {
+		if err := saveItems(config.Storage, person); err != nil {
+			log.Printf("Unable to save item IDs for %s: %v", person.Email, err)
+		}
+
+		for i := range accts {
+			if acct := person.AccountByID(accts[i].ID); acct != nil {
+				accts[i] = *acct
+			}
+		}
+	}
+
+	var synced, live []storage.Account
+	for _, acct := range accts {
+		if acct.ItemID == "" {
+			live = append(live, acct)
+		} else {
+			synced = append(synced, acct)
+		}
+	}
+
+	syncNew(person.Email, synced)
+
+	result, err := storedTransactions(person.Email, synced, q)
+
+	if err != nil {
+		return appErrorf(err, "problem loading transactions")
+	}
+
+	if len(live) > 0 {
+		fetched := liveTransactions(r.Context(), live, q)
+		result.Transactions = append(result.Transactions, fetched.Transactions...)
+		result.Errors = append(result.Errors, fetched.Errors...)
+		sortAccountTransactions(result.Transactions)
+	}
+
+	return respondJson(w, result)
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/pcarleton/cashcoach/api/auth"
+	"github.com/pcarleton/cashcoach/api/clock"
+	"github.com/pcarleton/cashcoach/api/plaid"
+	"github.com/pcarleton/cashcoach/api/rules"
+	"github.com/pcarleton/cashcoach/api/storage"
+)
+
+// setupTransactions is setupAccounts with the rules and a syncer that
+// isn't running, so jobs stay queued.
+func setupTransactions(t *testing.T, accounts ...storage.Account) (*auth.Profile, *httptest.Server) {
+	t.Helper()
+	profile, srv := setupAccounts(t, accounts...)
+
+	engine, err := rules.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Rules = engine
+
+	syncs = newSyncer(config.Storage, config.Transactions, config.Plaid, 1, clock.NewFake(time.Now()))
+	return profile, srv
+}
+
+func getTransactions(t *testing.T, profile *auth.Profile) TransactionsResult {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/api/transactions?start=2017-09-01&end=2017-09-30", nil)
+	w := httptest.NewRecorder()
+	appHandler(handleAuthAs(profile, transactionsHandler)).ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+
+	var result TransactionsResult
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestTransactionsBackfillsItems(t *testing.T) {
+	profile, srv := setupTransactions(t,
+		storage.Account{ID: "checking", Name: "checking", Token: "access-sandbox-checking"},
+	)
+	defer srv.Close()
+
+	getTransactions(t, profile)
+
+	person, err := config.Get(profile.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	acct := person.AccountByID("checking")
+	if acct.ItemID != "item-checking" || acct.InstitutionID != "ins_3" {
+		t.Errorf("got item %q at %q, want item-checking at ins_3", acct.ItemID, acct.InstitutionID)
+	}
+
+	job := syncJob{Email: profile.Email, ItemID: "item-checking"}
+	if !syncs.pending[job] {
+		t.Errorf("%+v wasn't queued once its item was known", job)
+	}
+}
+
+func TestTransactionsLiveWithoutItem(t *testing.T) {
+	profile, srv := setupTransactions(t,
+		storage.Account{ID: "checking", Name: "checking", Token: "access-sandbox-checking"},
+	)
+
+	// With Plaid down the item can't be looked up, so the account is
+	// fetched live, which fails too, rather than waiting for a sync.
+	srv.Close()
+
+	result := getTransactions(t, profile)
+	if len(result.Errors) != 1 || result.Errors[0].Account != "checking" {
+		t.Errorf("got errors %+v, want one for checking", result.Errors)
+	}
+	if len(syncs.pending) != 0 {
+		t.Errorf("queued %v without an item", syncs.pending)
+	}
+}
+
+func TestTransactionsLiveFetch(t *testing.T) {
+	_, srv := setupTransactions(t,
+		storage.Account{ID: "checking", Name: "checking", Token: "access-sandbox-checking"},
+	)
+	defer srv.Close()
+
+	// With no item to sync, transactions are fetched live.
+	result := liveTransactions(context.Background(), []storage.Account{
+		{ID: "checking", Name: "checking", Token: "access-sandbox-checking"},
+	}, transactionsQuery{
+		Start: time.Date(2017, 9, 1, 0, 0, 0, 0, time.UTC),
+		End:   time.Date(2017, 9, 30, 0, 0, 0, 0, time.UTC),
+	})
+
+	if len(result.Errors) != 0 {
+		t.Fatalf("got errors %+v", result.Errors)
+	}
+	if len(result.Transactions) == 0 {
+		t.Fatal("got no transactions")
+	}
+	for _, txn := range result.Transactions {
+		if txn.Account != "checking" || txn.AccountName != "checking" {
+			t.Errorf("transaction %s is from %q (%q)", txn.ID, txn.Account, txn.AccountName)
+		}
+	}
+}
+
+func TestBackfillAllItems(t *testing.T) {
+	_, srv := setupAccounts(t,
+		storage.Account{ID: "checking", Name: "checking", Token: "access-sandbox-checking"},
+		storage.Account{ID: "unknown", Name: "unknown", Token: "access-sandbox-unknown"},
+	)
+	defer srv.Close()
+
+	count, err := backfillAllItems(context.Background(), config.Storage, config.Plaid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("updated %d people, want 1", count)
+	}
+
+	person, err := config.Get("someone@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := person.AccountByID("checking").ItemID; id != "item-checking" {
+		t.Errorf("checking has item %q, want item-checking", id)
+	}
+	if id := person.AccountByID("unknown").ItemID; id != "" {
+		t.Errorf("unknown has item %q, want none", id)
+	}
+}
+
+func TestTransactionsBackfillKeepsSyncState(t *testing.T) {
+	profile, srv := setupTransactions(t,
+		storage.Account{ID: "checking", Name: "checking", Token: "access-sandbox-checking"},
+	)
+	defer srv.Close()
+
+	// A sync saves its state while the item is being looked up.
+	target, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/item/get" {
+			err := config.UpdateSyncState(profile.Email, "checking", storage.SyncState{Cursor: "cursor-1"})
+			if err != nil {
+				t.Error(err)
+			}
+		}
+		proxy.ServeHTTP(w, r)
+	}))
+	defer slow.Close()
+
+	client := plaid.NewClient("test-client-id", "test-secret", slow.URL)
+	client.SetRetryPolicy(plaid.NoRetries)
+	client.SetRateLimit(0, 0)
+	config.Plaid = client
+
+	getTransactions(t, profile)
+
+	person, err := config.Get(profile.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	acct := person.AccountByID("checking")
+	if acct.ItemID != "item-checking" {
+		t.Errorf("got item %q, want item-checking", acct.ItemID)
+	}
+	if acct.Cursor != "cursor-1" {
+		t.Errorf("cursor saved during the backfill was lost: got %q", acct.Cursor)
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+
+	"github.com/pcarleton/cashcoach/api/plaid"
+	"github.com/pcarleton/cashcoach/api/storage"
+)
+
+const (
+	webhookHeader  = "Plaid-Verification"
+	webhookMaxAge  = 5 * time.Minute
+	webhookMaxBody = 1 << 20
+
+	// webhookKeyTimeout bounds fetching a key, which happens while Plaid
+	// waits for the webhook's response.
+	webhookKeyTimeout = 10 * time.Second
+
+	// webhookKeyRefresh is how long a fetched key is used before it's
+	// fetched again to see whether Plaid has expired it.
+	webhookKeyRefresh = 24 * time.Hour
+)
+
+// PlaidWebhook is the union of the TRANSACTIONS and ITEM webhook bodies.
+type PlaidWebhook struct {
+	WebhookType         string               `json:"webhook_type"`
+	WebhookCode         string               `json:"webhook_code"`
+	ItemID              string               `json:"item_id"`
+	NewTransactions     int                  `json:"new_transactions"`
+	RemovedTransactions []string             `json:"removed_transactions"`
+	Error               *plaid.ErrorResponse `json:"error"`
+}
+
+type WebhookResult struct {
+	Status string `json:"status"`
+}
+
+// webhookVerifier checks the Plaid-Verification header: an ES256 JWT,
+// signed with Plaid's webhook key, whose request_body_sha256 claim must
+// match the body.
+//
+// The key is either configured, or fetched from Plaid by the kid in the
+// JWT's header and cached.
+type webhookVerifier struct {
+	key *ecdsa.PublicKey
+
+	fetch func(ctx context.Context, keyID string) (plaid.WebhookKey, error)
+	mu    sync.Mutex
+	keys  map[string]cachedWebhookKey
+
+	now func() time.Time
+}
+
+type cachedWebhookKey struct {
+	key     *ecdsa.PublicKey
+	fetched time.Time
+}
+
+func loadWebhookVerifier(pemPath string) (*webhookVerifier, error) {
+	pemBytes, err := ioutil.ReadFile(pemPath)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := jwt.ParseECPublicKeyFromPEM(pemBytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid webhook key in %s: %v", pemPath, err)
+	}
+
+	return &webhookVerifier{key: key, now: time.Now}, nil
+}
+
+// plaidWebhookVerifier fetches keys with client.WebhookVerificationKey.
+func plaidWebhookVerifier(client plaid.Client) *webhookVerifier {
+	return &webhookVerifier{
+		fetch: client.WebhookVerificationKey,
+		keys:  make(map[string]cachedWebhookKey),
+		now:   time.Now,
+	}
+}
+
+// keyFor returns the key to check token with.  Fetched keys are cached,
+// and fetched again after webhookKeyRefresh so a key Plaid has expired,
+// which it does when rotating keys, stops being accepted.  If Plaid can't
+// be reached then, the cached key is kept.  Fetches happen outside the
+// lock, so one slow fetch doesn't hold up webhooks signed with other keys.
+func (v *webhookVerifier) keyFor(token *jwt.Token) (*ecdsa.PublicKey, error) {
+	if v.key != nil {
+		return v.key, nil
+	}
+
+	keyID, _ := token.Header["kid"].(string)
+	if keyID == "" {
+		return nil, fmt.Errorf("webhook token has no kid header")
+	}
+
+	now := v.now()
+
+	v.mu.Lock()
+	cached, ok := v.keys[keyID]
+	v.mu.Unlock()
+
+	if ok && now.Sub(cached.fetched) < webhookKeyRefresh {
+		return cached.key, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), webhookKeyTimeout)
+	defer cancel()
+
+	jwk, err := v.fetch(ctx, keyID)
+	if err != nil {
+		if ok && plaid.ErrorClassOf(err) != plaid.ClassInvalidInput {
+			log.Printf("Unable to refresh webhook key %s, using the cached one: %v", keyID, err)
+			return cached.key, nil
+		}
+		v.forget(keyID)
+		return nil, fmt.Errorf("unable to fetch webhook key %s: %v", keyID, err)
+	}
+
+	if jwk.ExpiredAt != nil && !now.Before(time.Unix(*jwk.ExpiredAt, 0)) {
+		v.forget(keyID)
+		return nil, fmt.Errorf("webhook key %s has expired", keyID)
+	}
+
+	key, err := jwk.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+
+	v.mu.Lock()
+	v.keys[keyID] = cachedWebhookKey{key: key, fetched: now}
+	v.mu.Unlock()
+
+	return key, nil
+}
+
+func (v *webhookVerifier) forget(keyID string) {
+	v.mu.Lock()
+	delete(v.keys, keyID)
+	v.mu.Unlock()
+}
+
+func (v *webhookVerifier) verify(header string, body []byte) error {
+	if header == "" {
+		return fmt.Errorf("missing %s header", webhookHeader)
+	}
+
+	token, err := jwt.Parse(header, func(token *jwt.Token) (interface{}, error) {
+		if token.Method != jwt.SigningMethodES256 {
+			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
+		}
+		return v.keyFor(token)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return fmt.Errorf("Invalid webhook claims: %+v", token.Claims)
+	}
+
+	issued, ok := claims["iat"].(float64)
+	if !ok {
+		return fmt.Errorf("webhook token has no iat claim")
+	}
+
+	if age := v.now().Sub(time.Unix(int64(issued), 0)); age > webhookMaxAge {
+		return fmt.Errorf("webhook token is %s old", age)
+	}
+
+	want, ok := claims["request_body_sha256"].(string)
+	if !ok {
+		return fmt.Errorf("webhook token has no request_body_sha256 claim")
+	}
+
+	sum := sha256.Sum256(body)
+	got := hex.EncodeToString(sum[:])
+
+	if subtle.ConstantTimeCompare([]byte(got), []byte(want)) != 1 {
+		return fmt.Errorf("webhook body doesn't match its signature")
+	}
+
+	return nil
+}
+
+// plaidWebhookHandler queues a sync for the account behind a TRANSACTIONS
+// or ITEM webhook.  ITEM webhooks report errors, which the sync records on
+// the account.
+//
+// Set plaid.webhook_skip_verify to post the payloads in testdata/webhooks
+// by hand:
+//
+//	curl -d @testdata/webhooks/sync_updates_available.json localhost:5001/api/webhooks/plaid
+func plaidWebhookHandler(w http.ResponseWriter, r *http.Request) *appError {
+	if r.Method != http.MethodPost {
+		return &appError{nil, "method not allowed", http.StatusMethodNotAllowed}
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, webhookMaxBody))
+	if err != nil {
+		return &appError{err, "unable to read body", http.StatusBadRequest}
+	}
+
+	if config.Webhooks != nil {
+		if err := config.Webhooks.verify(r.Header.Get(webhookHeader), body); err != nil {
+			return &appError{err, "invalid webhook signature", http.StatusUnauthorized}
+		}
+	}
+
+	hook := PlaidWebhook{}
+	if err := json.Unmarshal(body, &hook); err != nil {
+		return &appError{err, "bad request", http.StatusBadRequest}
+	}
+
+	if hook.WebhookType != "TRANSACTIONS" && hook.WebhookType != "ITEM" {
+		return respondJson(w, WebhookResult{"ignored"})
+	}
+
+	person, err := config.FindByItemID(hook.ItemID)
+
+	// Plaid retries anything but a 200, which won't help for an item we
+	// no longer know about.
+	if err == storage.ErrNotFound {
+		log.Printf("Webhook %s/%s for unknown item %s", hook.WebhookType, hook.WebhookCode, hook.ItemID)
+		return respondJson(w, WebhookResult{"unknown item"})
+	}
+
+	if err != nil {
+		return appErrorf(err, "problem looking up item")
+	}
+
+	log.Printf("Webhook %s/%s for item %s of %s", hook.WebhookType, hook.WebhookCode,
+		hook.ItemID, person.Email)
+
+	if !syncs.enqueue(syncJob{Email: person.Email, ItemID: hook.ItemID}) {
+		return &appError{nil, "sync queue is full", http.StatusServiceUnavailable}
+	}
+
+	return respondJson(w, WebhookResult{"queued"})
+}
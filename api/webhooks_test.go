@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+
+	"github.com/pcarleton/cashcoach/api/clock"
+	"github.com/pcarleton/cashcoach/api/plaid"
+	"github.com/pcarleton/cashcoach/api/plaid/plaidtest"
+	"github.com/pcarleton/cashcoach/api/storage"
+)
+
+var webhookNow = time.Date(2017, 9, 2, 12, 0, 0, 0, time.UTC)
+
+func newWebhookKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// sign makes a Plaid-Verification header for body, the way Plaid does.
+func sign(t *testing.T, key *ecdsa.PrivateKey, keyID string, issued time.Time, body []byte) string {
+	t.Helper()
+	sum := sha256.Sum256(body)
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"iat":                 issued.Unix(),
+		"request_body_sha256": hex.EncodeToString(sum[:]),
+	})
+	token.Header["kid"] = keyID
+
+	header, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return header
+}
+
+func readWebhook(t *testing.T, name string) []byte {
+	t.Helper()
+	body, err := ioutil.ReadFile(filepath.Join("testdata", "webhooks", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+// setupWebhooks points the handler's globals at a store holding the items
+// in testdata/webhooks, and a syncer that isn't running, so jobs stay
+// queued.
+func setupWebhooks(t *testing.T, verifier *webhookVerifier) {
+	t.Helper()
+	store := storage.NewFakeStorage()
+	if _, err := store.Create("someone@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	err := store.Update(&storage.Person{
+		Email: "someone@example.com",
+		Accounts: []storage.Account{
+			{ID: "checking", Name: "checking", Token: "access-checking", ItemID: "item-checking"},
+			{ID: "relink", Name: "relink", Token: "access-relink", ItemID: "item-relink"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if verifier != nil {
+		verifier.now = func() time.Time { return webhookNow }
+	}
+
+	config = &Config{Storage: store, Transactions: store, Webhooks: verifier}
+	syncs = newSyncer(store, store, plaid.Client{}, 1, clock.NewFake(webhookNow))
+}
+
+func postWebhook(body []byte, header string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/api/webhooks/plaid", bytes.NewReader(body))
+	if header != "" {
+		req.Header.Set(webhookHeader, header)
+	}
+
+	w := httptest.NewRecorder()
+	appHandler(plaidWebhookHandler).ServeHTTP(w, req)
+	return w
+}
+
+func webhookStatus(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", w.Code, w.Body)
+	}
+	var result WebhookResult
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Fatalf("invalid response %q: %v", w.Body, err)
+	}
+	return result.Status
+}
+
+func TestWebhookPayloads(t *testing.T) {
+	key := newWebhookKey(t)
+
+	tests := []struct {
+		file   string
+		itemID string
+	}{
+		{"default_update.json", "item-checking"},
+		{"sync_updates_available.json", "item-checking"},
+		{"transactions_removed.json", "item-checking"},
+		{"item_login_required.json", "item-relink"},
+	}
+
+	for _, test := range tests {
+		setupWebhooks(t, &webhookVerifier{key: &key.PublicKey})
+		body := readWebhook(t, test.file)
+
+		w := postWebhook(body, sign(t, key, "", webhookNow.Add(-time.Minute), body))
+		if status := webhookStatus(t, w); status != "queued" {
+			t.Errorf("%s: got %q, want queued", test.file, status)
+		}
+
+		job := syncJob{Email: "someone@example.com", ItemID: test.itemID}
+		if !syncs.pending[job] {
+			t.Errorf("%s: %+v wasn't queued", test.file, job)
+		}
+	}
+}
+
+func TestWebhookUnknownItem(t *testing.T) {
+	setupWebhooks(t, nil)
+
+	w := postWebhook([]byte(`{"webhook_type": "TRANSACTIONS", "webhook_code": "DEFAULT_UPDATE", "item_id": "item-gone"}`), "")
+	if status := webhookStatus(t, w); status != "unknown item" {
+		t.Errorf("got %q, want unknown item", status)
+	}
+	if len(syncs.pending) != 0 {
+		t.Errorf("queued %v for an unknown item", syncs.pending)
+	}
+}
+
+func TestWebhookRejected(t *testing.T) {
+	key := newWebhookKey(t)
+	other := newWebhookKey(t)
+	body := readWebhook(t, "default_update.json")
+
+	tests := []struct {
+		name   string
+		header string
+	}{
+		{"missing header", ""},
+		{"bad signature", sign(t, other, "", webhookNow, body)},
+		{"stale iat", sign(t, key, "", webhookNow.Add(-webhookMaxAge-time.Minute), body)},
+		{"other body", sign(t, key, "", webhookNow, readWebhook(t, "transactions_removed.json"))},
+		{"garbage", "not-a-jwt"},
+	}
+
+	for _, test := range tests {
+		setupWebhooks(t, &webhookVerifier{key: &key.PublicKey})
+
+		w := postWebhook(body, test.header)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s: got status %d, want %d", test.name, w.Code, http.StatusUnauthorized)
+		}
+		if len(syncs.pending) != 0 {
+			t.Errorf("%s: queued %v", test.name, syncs.pending)
+		}
+	}
+}
+
+func TestWebhookFetchesKeys(t *testing.T) {
+	key := newWebhookKey(t)
+	expired := newWebhookKey(t)
+
+	expiredKey := plaid.NewWebhookKey("key-expired", &expired.PublicKey)
+	expiredAt := webhookNow.Add(-time.Hour).Unix()
+	expiredKey.ExpiredAt = &expiredAt
+
+	fixtures := &plaidtest.Fixtures{
+		WebhookKeys: []plaid.WebhookKey{
+			plaid.NewWebhookKey("key-1", &key.PublicKey),
+			expiredKey,
+		},
+	}
+	srv := plaidtest.Start(fixtures)
+
+	client := plaid.NewClient("id", "secret", srv.URL)
+	client.SetRetryPolicy(plaid.NoRetries)
+	client.SetRateLimit(0, 0)
+
+	verifier := plaidWebhookVerifier(client)
+	setupWebhooks(t, verifier)
+	body := readWebhook(t, "default_update.json")
+
+	if status := webhookStatus(t, postWebhook(body, sign(t, key, "key-1", webhookNow, body))); status != "queued" {
+		t.Errorf("got %q, want queued", status)
+	}
+
+	rejected := map[string]string{
+		"unknown kid": sign(t, key, "key-2", webhookNow, body),
+		"expired key": sign(t, expired, "key-expired", webhookNow, body),
+		"no kid":      sign(t, key, "", webhookNow, body),
+	}
+	for name, header := range rejected {
+		if w := postWebhook(body, header); w.Code != http.StatusUnauthorized {
+			t.Errorf("%s: got status %d, want %d", name, w.Code, http.StatusUnauthorized)
+		}
+	}
+
+	// Known keys are cached, so Plaid being unreachable doesn't matter.
+	srv.Close()
+	w := postWebhook(body, sign(t, key, "key-1", webhookNow, body))
+	if w.Code != http.StatusOK {
+		t.Errorf("with a cached key got status %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestWebhookKeyExpires(t *testing.T) {
+	key := newWebhookKey(t)
+	fixtures := &plaidtest.Fixtures{
+		WebhookKeys: []plaid.WebhookKey{plaid.NewWebhookKey("key-1", &key.PublicKey)},
+	}
+	srv := plaidtest.Start(fixtures)
+	defer srv.Close()
+
+	client := plaid.NewClient("id", "secret", srv.URL)
+	client.SetRetryPolicy(plaid.NoRetries)
+	client.SetRateLimit(0, 0)
+
+	verifier := plaidWebhookVerifier(client)
+	setupWebhooks(t, verifier)
+	now := webhookNow
+	verifier.now = func() time.Time { return now }
+	body := readWebhook(t, "default_update.json")
+
+	if w := postWebhook(body, sign(t, key, "key-1", now, body)); w.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", w.Code, w.Body)
+	}
+
+	// Plaid rotates the key out.  The cached key is used until it's due a
+	// refresh, and then turned away.
+	expiredAt := now.Add(time.Minute).Unix()
+	fixtures.WebhookKeys[0].ExpiredAt = &expiredAt
+
+	now = now.Add(time.Hour)
+	if w := postWebhook(body, sign(t, key, "key-1", now, body)); w.Code != http.StatusOK {
+		t.Errorf("before the refresh got status %d: %s", w.Code, w.Body)
+	}
+
+	now = now.Add(webhookKeyRefresh)
+	if w := postWebhook(body, sign(t, key, "key-1", now, body)); w.Code != http.StatusUnauthorized {
+		t.Errorf("after the key expired got status %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestWebhookKeyRefreshFails(t *testing.T) {
+	key := newWebhookKey(t)
+	srv := plaidtest.Start(&plaidtest.Fixtures{
+		WebhookKeys: []plaid.WebhookKey{plaid.NewWebhookKey("key-1", &key.PublicKey)},
+	})
+
+	client := plaid.NewClient("id", "secret", srv.URL)
+	client.SetRetryPolicy(plaid.NoRetries)
+	client.SetRateLimit(0, 0)
+
+	verifier := plaidWebhookVerifier(client)
+	setupWebhooks(t, verifier)
+	now := webhookNow
+	verifier.now = func() time.Time { return now }
+	body := readWebhook(t, "default_update.json")
+
+	if w := postWebhook(body, sign(t, key, "key-1", now, body)); w.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", w.Code, w.Body)
+	}
+
+	// An outage when the key is due a refresh doesn't stop webhooks.
+	srv.Close()
+	now = now.Add(2 * webhookKeyRefresh)
+	if w := postWebhook(body, sign(t, key, "key-1", now, body)); w.Code != http.StatusOK {
+		t.Errorf("with Plaid down got status %d: %s", w.Code, w.Body)
+	}
+}
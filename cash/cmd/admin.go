@@ -15,12 +15,17 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
+	"strings"
 
 	"github.com/spf13/cobra"
 
+	"github.com/pcarleton/cashcoach/api/plaid"
+	"github.com/pcarleton/cashcoach/api/plaid/plaidtest"
 	"github.com/pcarleton/cashcoach/cash/lib"
 )
 
@@ -42,7 +47,7 @@ var publicTokenCmd = &cobra.Command{
 
 		client := lib.GetClient()
 
-		resp, err := client.CreatePublicToken(acct.Token)
+		resp, err := client.CreatePublicToken(context.Background(), acct.Token)
 
 		if err != nil {
 			log.Fatal(err)
@@ -52,6 +57,133 @@ var publicTokenCmd = &cobra.Command{
 	},
 }
 
+// fatalPlaidError exits with err, pointing at `cash plaid update` when the
+// account's bank login needs repairing.
+func fatalPlaidError(name string, err error) {
+	if plaid.ErrorClassOf(err) == plaid.ClassItemLoginRequired {
+		log.Fatalf("The bank login for %s needs to be updated. Run `cash plaid update %s` to repair it.", name, name)
+	}
+
+	log.Fatalf("Plaid request for %s failed: %v", name, err)
+}
+
+var plaidStatusCmd = &cobra.Command{
+	Use:   "status [account...]",
+	Short: "Check whether each account's bank connection is working",
+	Long: `Asks Plaid about the item behind each account, or every configured account
+if none are named.  Exits non-zero if any account needs attention.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		accts := pickAccounts(args)
+		client := lib.GetClient()
+
+		headers := []string{"account", "item", "institution", "state", "error"}
+		fmt.Println(strings.Join(headers, "\t"))
+
+		healthy := true
+		for _, acct := range accts {
+			health, err := client.CheckItem(context.Background(), acct.Token)
+			if err != nil {
+				health = plaid.ItemHealth{
+					State: plaid.ItemErrored,
+					Error: &plaid.ErrorResponse{Message: err.Error()},
+				}
+			}
+
+			errMsg := ""
+			if health.Error != nil {
+				errMsg = strings.TrimSpace(health.Error.Code + " " + health.Error.Message)
+			}
+
+			if health.State != plaid.ItemHealthy {
+				healthy = false
+			}
+
+			fmt.Println(strings.Join([]string{
+				acct.Name,
+				health.ItemID,
+				health.InstitutionID,
+				string(health.State),
+				errMsg,
+			}, "\t"))
+
+			if health.NeedsUpdate() {
+				log.Printf("Run `cash plaid update %s` to log in to %s again.", acct.Name, acct.Name)
+			}
+		}
+
+		if !healthy {
+			os.Exit(1)
+		}
+	},
+}
+
+var plaidUpdateCmd = &cobra.Command{
+	Use:   "update <account>",
+	Short: "Get a Link token to repair an account's bank login",
+	Long: `Creates a public token for the account's existing item.  Opening Plaid Link
+with that token starts update mode, where the user logs in again without
+creating a new item, so the account's access token keeps working.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		acct, err := lib.GetAccount(args[0])
+
+		if err != nil {
+			panic(err)
+		}
+
+		if acct == nil {
+			fmt.Println("No account with that name.")
+			os.Exit(1)
+		}
+
+		client := lib.GetClient()
+		ctx := context.Background()
+
+		health, err := client.CheckItem(ctx, acct.Token)
+		if err != nil {
+			fatalPlaidError(acct.Name, err)
+		}
+
+		if !health.NeedsUpdate() {
+			log.Printf("%s is %s; update mode is only needed after a login error.", acct.Name, health.State)
+		}
+
+		resp, err := client.CreatePublicToken(ctx, acct.Token)
+
+		if err != nil {
+			fatalPlaidError(acct.Name, err)
+		}
+
+		log.Printf("Open Plaid Link with this token to log in to %s again:", acct.Name)
+		fmt.Println(resp.PublicToken)
+	},
+}
+
+var fakePlaidCmd = &cobra.Command{
+	Use:   "fake",
+	Short: "Run a fake Plaid API server from fixture files",
+	Long: `Serves the Plaid endpoints cash uses from a fixtures file (see
+api/plaid/plaidtest/testdata/fixtures.json).  Set plaid_url in your config to
+the printed address to use it instead of Plaid.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		addr := lib.StringFlagOrDie(cmd, "addr")
+		fixturesPath := lib.StringFlagOrDie(cmd, "fixtures")
+
+		if fixturesPath == "" {
+			log.Fatal("Must specify --fixtures")
+		}
+
+		fixtures, err := plaidtest.LoadFixtures(fixturesPath)
+		if err != nil {
+			log.Fatalf("Unable to load fixtures: %v", err)
+		}
+
+		log.Printf("Serving fake Plaid API at http://%s", addr)
+		log.Fatal(http.ListenAndServe(addr, plaidtest.NewServer(fixtures)))
+	},
+}
+
 // plaidCmd represents the plaid command
 var plaidCmd = &cobra.Command{
 	Use:   "plaid",
@@ -64,4 +196,11 @@ func init() {
 	RootCmd.AddCommand(plaidCmd)
 
 	plaidCmd.AddCommand(publicTokenCmd)
+
+	plaidCmd.AddCommand(plaidStatusCmd)
+	plaidCmd.AddCommand(plaidUpdateCmd)
+
+	plaidCmd.AddCommand(fakePlaidCmd)
+	fakePlaidCmd.Flags().String("addr", "localhost:4242", "Address to listen on")
+	fakePlaidCmd.Flags().StringP("fixtures", "f", "", "Fixtures file to serve from")
 }
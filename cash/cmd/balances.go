@@ -0,0 +1,133 @@
+// Copyright © 2017 Paul Carleton
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/pcarleton/cashcoach/api/money"
+	"github.com/pcarleton/cashcoach/api/plaid"
+	"github.com/pcarleton/cashcoach/cash/lib"
+)
+
+type balanceRow struct {
+	Account   string       `json:"account"`
+	Nickname  string       `json:"nickname"`
+	Mask      string       `json:"mask"`
+	Name      string       `json:"name"`
+	Type      string       `json:"type"`
+	Available money.Amount `json:"available"`
+	Current   money.Amount `json:"current"`
+	Limit     money.Amount `json:"limit"`
+}
+
+// balancesCmd represents the balances command
+var balancesCmd = &cobra.Command{
+	Use:   "balances [account...]",
+	Short: "Print current balances for configured accounts",
+	Long: `Fetches live balances from Plaid for each account, or every configured
+account if none are named.  Use --cached to skip the round trip to the bank
+and print the balances Plaid last saw instead.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		accts := pickAccounts(args)
+
+		cached, err := cmd.Flags().GetBool("cached")
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		jsonOut, err := cmd.Flags().GetBool("json")
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		delimiter := lib.StringFlagOrDie(cmd, "delimiter")
+
+		client := lib.GetClient()
+		ctx := context.Background()
+		rows := make([]balanceRow, 0)
+
+		for _, acct := range accts {
+			var resp plaid.AccountsResponse
+			if cached {
+				resp, err = client.Accounts(ctx, acct.Token)
+			} else {
+				resp, err = client.Balances(ctx, acct.Token, nil)
+			}
+
+			if err != nil {
+				fatalPlaidError(acct.Name, err)
+			}
+
+			nickMap := acct.NickMap(resp.Accounts)
+			for _, a := range resp.Accounts {
+				rows = append(rows, balanceRow{
+					Account:   acct.Name,
+					Nickname:  nickMap[a.ID],
+					Mask:      a.Mask,
+					Name:      a.Name,
+					Type:      a.Type,
+					Available: a.Balances.Available,
+					Current:   a.Balances.Current,
+					Limit:     a.Balances.Limit,
+				})
+			}
+		}
+
+		if jsonOut {
+			lib.OutputJson(rows)
+			return
+		}
+
+		headers := []string{
+			"account",
+			"nickname",
+			"mask",
+			"name",
+			"type",
+			"available",
+			"current",
+			"limit",
+		}
+
+		fmt.Println(strings.Join(headers, "\t"))
+		for _, r := range rows {
+			pieces := []string{
+				r.Account,
+				r.Nickname,
+				r.Mask,
+				r.Name,
+				r.Type,
+				r.Available.String(),
+				r.Current.String(),
+				r.Limit.String(),
+			}
+
+			fmt.Println(strings.Join(pieces, delimiter))
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(balancesCmd)
+	balancesCmd.Flags().Bool("cached", false, "Use Plaid's cached balances instead of asking the bank")
+	balancesCmd.Flags().StringP("delimiter", "d", "\t", "Delimiter to use for printing")
+	balancesCmd.Flags().BoolP("json", "j", false, "When true, output balances as JSON")
+}
@@ -15,7 +15,15 @@
 package cmd
 
 import (
+	"bufio"
 	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -48,6 +56,89 @@ var configCmd = &cobra.Command{
 	},
 }
 
+var configSealCmd = &cobra.Command{
+	Use:   "seal",
+	Short: "Encrypt an access token for the config file",
+	Long: `Reads an access token and prints it encrypted with the keyring (see the key
+command).  Put the output in an account's sealed_token in place of token.
+
+The token is prompted for without echoing it, or read from stdin when that
+isn't a terminal, so it never ends up in shell history:
+
+  cash config seal < token.txt`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		token, err := readSecret("Access token: ")
+		if err != nil {
+			log.Fatalf("Unable to read token: %v", err)
+		}
+		if token == "" {
+			log.Fatalf("No access token given")
+		}
+
+		sealed, err := lib.SealToken(token)
+		if err != nil {
+			log.Fatalf("Unable to seal token: %v", err)
+		}
+
+		fmt.Println(sealed)
+	},
+}
+
+// readSecret reads a line from stdin.  If stdin is a terminal, it prompts
+// first and turns off echo while the line is typed.
+func readSecret(prompt string) (string, error) {
+	if isTerminal(os.Stdin) {
+		fmt.Fprint(os.Stderr, prompt)
+
+		if err := stty("-echo"); err != nil {
+			return "", fmt.Errorf("unable to turn off echo: %v", err)
+		}
+
+		// Don't leave the terminal without echo if we're interrupted.
+		interrupted := make(chan os.Signal, 1)
+		signal.Notify(interrupted, os.Interrupt, syscall.SIGTERM)
+		done := make(chan struct{})
+		go func() {
+			select {
+			case <-interrupted:
+				stty("echo")
+				fmt.Fprintln(os.Stderr)
+				os.Exit(1)
+			case <-done:
+			}
+		}()
+
+		defer func() {
+			signal.Stop(interrupted)
+			close(done)
+			stty("echo")
+			fmt.Fprintln(os.Stderr)
+		}()
+	}
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", err
+	}
+
+	return strings.TrimSpace(line), nil
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// stty changes the settings of the terminal on stdin.
+func stty(args ...string) error {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	return cmd.Run()
+}
+
 func init() {
 	RootCmd.AddCommand(configCmd)
+
+	configCmd.AddCommand(configSealCmd)
 }
@@ -0,0 +1,69 @@
+// Copyright © 2017 Paul Carleton
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"log"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/pcarleton/cashcoach/cash/lib"
+	"github.com/pcarleton/cashcoach/cash/lib/ledger"
+)
+
+var ledgerExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Write a journal out as ledger or beancount",
+	Long: `Reads a journal, like one "cash ledger import --journal" keeps, and prints
+it in another format.
+
+With --format beancount, accounts are renamed the way beancount requires,
+so expenses:food and drink:restaurants becomes
+Expenses:Food-And-Drink:Restaurants, and each account is opened on the date
+it's first used.  Two accounts that would get the same name, like
+"food & drink" and "food and drink", are an error; rename one of them in the
+journal.  Amounts without a commodity are in --currency, and "$" is USD.
+Metadata like plaid_id is kept.
+
+With --format ledger, the journal is checked and printed back in the form
+"cash ledger import" writes.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		format := lib.StringFlagOrDie(cmd, "format")
+		if format != "ledger" && format != "beancount" {
+			log.Fatalf("Unknown format %q, expected ledger or beancount", format)
+		}
+
+		journal := readJournal(lib.StringFlagOrDie(cmd, "file"))
+
+		var err error
+		if format == "beancount" {
+			err = ledger.WriteBeancount(os.Stdout, journal.Transactions, lib.StringFlagOrDie(cmd, "currency"))
+		} else {
+			err = writeLTrans(os.Stdout, journal.Transactions, false)
+		}
+
+		if err != nil {
+			log.Fatalf("Unable to export journal: %v", err)
+		}
+	},
+}
+
+func init() {
+	ledgerCmd.AddCommand(ledgerExportCmd)
+	ledgerExportCmd.Flags().StringP("file", "f", "", "Journal to read, or stdin if empty")
+	ledgerExportCmd.Flags().String("format", "ledger", "Output format: ledger or beancount")
+	ledgerExportCmd.Flags().String("currency", "USD", "Currency for amounts without a commodity")
+}
@@ -0,0 +1,193 @@
+// Copyright © 2017 Paul Carleton
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/pcarleton/cashcoach/cash/lib"
+	"github.com/pcarleton/cashcoach/cash/lib/ledger"
+)
+
+var ledgerBalanceCmd = &cobra.Command{
+	Use:   "balance [account...]",
+	Short: "Print account totals from a ledger journal",
+	Long: `Totals each account in a journal, along with every account above it, like
+"ledger balance".  Accounts name prefixes to report on, so "expenses:food"
+covers expenses:food:groceries too.
+
+Use --depth to stop at a number of account levels, --start and --end to
+pick a date range and --period to total each month or quarter separately.
+--format tsv output can be fed to "cash sheets import".`,
+	Run: func(cmd *cobra.Command, args []string) {
+		journal, filter, depth, period := ledgerReportArgs(cmd, args)
+		format := lib.StringFlagOrDie(cmd, "format")
+
+		rows := ledger.Balance(journal.Transactions, filter, depth, period)
+
+		if format == "json" {
+			lib.OutputJson(rows)
+			return
+		}
+
+		headers := []string{"account", "commodity", "amount"}
+		if period != ledger.NoPeriod {
+			headers = append([]string{"period"}, headers...)
+		}
+
+		table := make([][]string, len(rows))
+		for i, r := range rows {
+			amount := r.Amount.String()
+			if format == "table" {
+				amount = r.AmountString()
+			}
+
+			table[i] = []string{r.Account, r.Commodity, amount}
+			if period != ledger.NoPeriod {
+				table[i] = append([]string{r.Period}, table[i]...)
+			}
+		}
+
+		printReport(format, headers, table)
+	},
+}
+
+var ledgerRegisterCmd = &cobra.Command{
+	Use:   "register [account...]",
+	Short: "List postings from a ledger journal with a running total",
+	Long: `Lists each posting in a journal in date order with a running total, like
+"ledger register".  Accounts name prefixes to report on, so "expenses:food"
+covers expenses:food:groceries too.
+
+Use --depth to shorten account names to a number of levels, --start and
+--end to pick a date range and --period to sum each account per month or
+quarter.  --format tsv output can be fed to "cash sheets import".`,
+	Run: func(cmd *cobra.Command, args []string) {
+		journal, filter, depth, period := ledgerReportArgs(cmd, args)
+		format := lib.StringFlagOrDie(cmd, "format")
+
+		rows := ledger.Register(journal.Transactions, filter, depth, period)
+
+		if format == "json" {
+			lib.OutputJson(rows)
+			return
+		}
+
+		headers := []string{"date", "description", "account", "commodity", "amount", "total"}
+
+		table := make([][]string, len(rows))
+		for i, r := range rows {
+			amount, total := r.Amount.String(), r.Total.String()
+			if format == "table" {
+				amount, total = r.AmountString(), r.TotalString()
+			}
+
+			table[i] = []string{r.Date.Format(lib.DateFmt), r.Description, r.Account, r.Commodity, amount, total}
+		}
+
+		printReport(format, headers, table)
+	},
+}
+
+// ledgerReportArgs reads the journal and the flags the reports share.
+func ledgerReportArgs(cmd *cobra.Command, args []string) (*ledger.Journal, ledger.Filter, int, ledger.Period) {
+	format := lib.StringFlagOrDie(cmd, "format")
+	if format != "table" && format != "tsv" && format != "json" {
+		log.Fatalf("Unknown format %q, expected table, tsv or json", format)
+	}
+
+	period, err := ledger.ParsePeriod(lib.StringFlagOrDie(cmd, "period"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	depth := lib.IntFlagOrDie(cmd, "depth")
+	if depth < 0 {
+		log.Fatalf("--depth can't be negative")
+	}
+
+	filter := ledger.Filter{}
+	if start := lib.StringFlagOrDie(cmd, "start"); start != "" {
+		filter.Start = lib.DateOrDie(start)
+	}
+	if end := lib.StringFlagOrDie(cmd, "end"); end != "" {
+		filter.End = lib.DateOrDie(end)
+	}
+	for _, arg := range args {
+		filter.Accounts = append(filter.Accounts, ledger.AccountName(strings.Split(arg, ":")))
+	}
+
+	return readJournal(lib.StringFlagOrDie(cmd, "file")), filter, depth, period
+}
+
+// readJournal parses and validates the journal at filename, or stdin if
+// it's empty or "-".
+func readJournal(filename string) *ledger.Journal {
+	var journal *ledger.Journal
+	var err error
+
+	if filename == "" || filename == "-" {
+		journal, err = ledger.Parse(os.Stdin, "<stdin>")
+	} else {
+		journal, err = ledger.ParseFile(filename)
+	}
+
+	if err != nil {
+		log.Fatalf("Unable to read journal: %v", err)
+	}
+
+	if err := journal.Validate(); err != nil {
+		log.Fatalf("Invalid journal: %v", err)
+	}
+
+	return journal
+}
+
+// printReport prints rows under headers, either lined up in columns or
+// tab-separated.
+func printReport(format string, headers []string, rows [][]string) {
+	if format == "tsv" {
+		fmt.Println(strings.Join(headers, "\t"))
+		for _, row := range rows {
+			fmt.Println(strings.Join(row, "\t"))
+		}
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(headers, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	w.Flush()
+}
+
+func init() {
+	for _, c := range []*cobra.Command{ledgerBalanceCmd, ledgerRegisterCmd} {
+		ledgerCmd.AddCommand(c)
+		c.Flags().StringP("file", "f", "", "Journal to read, or stdin if empty")
+		c.Flags().StringP("start", "s", "", "Only include transactions on or after this date (like 2006-01-03)")
+		c.Flags().StringP("end", "e", "", "Only include transactions on or before this date")
+		c.Flags().Int("depth", 0, "Number of account levels to show, or 0 for all")
+		c.Flags().StringP("period", "p", "", "Group by period: monthly or quarterly")
+		c.Flags().String("format", "table", "Output format: table, tsv or json")
+	}
+}
@@ -15,18 +15,19 @@
 package cmd
 
 import (
-	//"encoding/json"
+	"bufio"
 	"fmt"
-  "strconv"
+	"io"
 	"log"
 	"os"
+	"strings"
 	"time"
-  "bufio"
-  "strings"
 
 	"github.com/spf13/cobra"
 
+	"github.com/pcarleton/cashcoach/api/money"
 	"github.com/pcarleton/cashcoach/api/plaid"
+	"github.com/pcarleton/cashcoach/api/rules"
 	"github.com/pcarleton/cashcoach/cash/lib"
 	"github.com/pcarleton/cashcoach/cash/lib/ledger"
 )
@@ -34,129 +35,416 @@ import (
 var ledgerImportCmd = &cobra.Command{
 	Use:   "import",
 	Short: "Import a TSV into ledger format",
+	Long: `Reads a TSV like the one "cash transactions" prints, with account, date,
+description, category, label, split, amount and id columns, and prints a
+ledger journal.  IDs are kept as plaid_id metadata.
+
+Each row becomes a transaction from the account to an expense account named
+after the category, after applying the rules config.  Money coming in that's
+categorized as pay, interest or a transfer goes to an income account instead.
+Account nicknames are mapped to ledger accounts with the ledger_accounts
+config key; unmapped ones become liabilities:<nickname>.  Labels,
+comma-separated, become ledger tags.
+
+Transfers between our own accounts that show up on both sides, such as a
+credit card payment from checking, are booked once, between the two accounts.
+
+Rows shared between people, because a rule or the split column names them or
+their category has a split policy, are divided with the splits config key.
+The payer's share stays in the expense account and everyone else's goes to
+assets:receivable:<payer>:<person>, which "cash settle" totals.
+
+Reads stdin if --file is not given.  Nothing is written if any transaction
+would be invalid, such as one with an empty category part.
+
+With --format ofx or qif, reads a bank statement downloaded by hand instead;
+QFX files are OFX.  Rows are in the statement's account, by its account ID
+or QIF account name, unless --account gives a nickname.  OFX FITIDs are kept
+as fitid metadata, and repeats of one in the same account are dropped.
+
+With --format csv, reads a bank's CSV statement with the csv_profiles entry
+named by --profile, which sets the delimiter, columns, date format, sign
+convention, lines to skip and default account:
+
+  csv_profiles:
+    chase:
+      date_format: 01/02/2006
+      account: checking
+      columns:
+        date: Posting Date
+        description: Description
+        amount: Amount
+
+A profile's id column is treated like a FITID.
+
+With --journal, transactions already in that journal are skipped and the
+rest are appended to it instead of printed.  A transaction counts as
+already there if it has the same date, description and postings, or the
+same Plaid ID, or the same FITID in the same account.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		// TODO: read from stdin if no file specified
+		ttrans := readRowsOrDie(cmd)
 
-		filename := lib.StringFlagOrDie(cmd, "file")
+		engine := lib.RulesOrDie()
+		for i := range ttrans {
+			ttrans[i] = categorizeRow(engine, ttrans[i])
+		}
+
+		accounts := lib.LedgerAccounts()
+		splitter := lib.SplitterOrDie()
+		warned := make(map[string]bool)
+		invalid := 0
+
+		entries := make([]ledger.Entry, 0, len(ttrans))
+		for _, t := range ttrans {
+			account, ok := accounts[strings.ToLower(t.Account)]
+			if !ok {
+				account = ledger.Liability(t.Account)
+				if !warned[t.Account] {
+					log.Printf("No ledger_accounts entry for %q, using %s", t.Account, account)
+					warned[t.Account] = true
+				}
+			}
+
+			split, err := splitter.Split(t.Category, t.Account, commaList(t.Split), t.Amount)
+			if err != nil {
+				log.Printf("%s %s: %v", t.Date.Format(lib.DateFmt), t.Description, err)
+				invalid++
+				continue
+			}
+
+			entries = append(entries, ledger.Entry{
+				ID:          t.ID,
+				IDKey:       t.IDKey,
+				Account:     account,
+				Date:        t.Date,
+				Description: t.Description,
+				Category:    categoryPath(t.Category),
+				Amount:      t.Amount,
+				Tags:        commaList(t.Label),
+				Split:       split,
+			})
+		}
+
+		lTrans := ledger.Book(entries, ledger.TransferWindow)
+
+		for _, t := range lTrans {
+			if err := t.Validate(); err != nil {
+				log.Print(err)
+				invalid++
+			}
+		}
+		if invalid > 0 {
+			log.Fatalf("%d invalid transactions, nothing imported", invalid)
+		}
+
+		journal := lib.StringFlagOrDie(cmd, "journal")
+		if journal == "" {
+			writeLTrans(os.Stdout, lTrans, false)
+			return
+		}
 
-    ttrans, err :=  readTsv(filename)
+		lTrans = newLTrans(journal, lTrans)
+		if len(lTrans) == 0 {
+			return
+		}
 
+		f, err := os.OpenFile(journal, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
 		if err != nil {
-			log.Fatalf("Unable to load transactions: %v", err)
+			log.Fatalf("Unable to open journal: %v", err)
 		}
 
-    fmt.Printf("%+v", ttrans)
-    
-    
+		if err := writeLTrans(f, lTrans, true); err != nil {
+			log.Fatalf("Unable to write journal: %v", err)
+		}
 
-		//reader, err := os.Open(filename)
+		if err := f.Close(); err != nil {
+			log.Fatalf("Unable to write journal: %v", err)
+		}
 
+		log.Printf("Added %d transactions to %s", len(lTrans), journal)
+	},
+}
 
+// newLTrans drops the transactions already in the journal at path, by
+// their Key or bank IDs.  A journal that doesn't exist yet has none.
+func newLTrans(path string, lTrans []ledger.Transaction) []ledger.Transaction {
+	existing, err := ledger.ParseFile(path)
+	if os.IsNotExist(err) {
+		return lTrans
+	}
+	if err != nil {
+		log.Fatalf("Unable to read journal: %v", err)
+	}
 
-		//var trans []plaid.Transaction
+	keys := existing.Keys()
+	fresh := make([]ledger.Transaction, 0, len(lTrans))
 
-		//decoder := json.NewDecoder(reader)
+	for _, t := range lTrans {
+		ids := append(t.IDs(), t.Key())
+		if anyKey(keys, ids) {
+			continue
+		}
+		// Also catches the same row twice in one import.
+		for _, id := range ids {
+			keys[id] = true
+		}
+		fresh = append(fresh, t)
+	}
 
-		//err = decoder.Decode(&trans)
-		//if err != nil {
-		//	log.Fatalf("Unable to read json", err)
-		//}
+	if skipped := len(lTrans) - len(fresh); skipped > 0 {
+		log.Printf("Skipped %d transactions already in %s", skipped, path)
+	}
 
-		//for _, t := range trans {
-		//	lTrans := makeLTrans(&t, "taylor")
-		//	fmt.Println(lTrans.String())
-		//	fmt.Println("")
-		//}
-	},
+	return fresh
+}
+
+func anyKey(keys map[string]bool, ids []string) bool {
+	for _, id := range ids {
+		if keys[id] {
+			return true
+		}
+	}
+	return false
+}
+
+// writeLTrans writes transactions separated by blank lines.  leading adds
+// a blank line before the first one too, for appending to a journal.
+func writeLTrans(w io.Writer, lTrans []ledger.Transaction, leading bool) error {
+	for i, t := range lTrans {
+		if i > 0 || leading {
+			if _, err := fmt.Fprintln(w); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintln(w, t.String()); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 type TableTrans struct {
-  Account string // Nick name, human readable
-  Date time.Time
-  Description string
-  Category string
-  Label string
-  Amount float64
+	// ID is the Plaid transaction ID, if the TSV has one, or the FITID
+	// for OFX statements.
+	ID string
+	// IDKey is the metadata key ID is kept under, ledger.PlaidID if
+	// empty.
+	IDKey       string
+	Account     string // Nick name, human readable
+	Date        time.Time
+	Description string
+	Category    string
+	Label       string
+	// Split names the people sharing the row, comma-separated.
+	Split  string
+	Amount money.Amount
 }
 
-func readTsv(fileName string) ([]TableTrans, error) {
-		reader, err := os.Open(fileName)
-		if err != nil {
-			return nil, err
+// commaList reads a comma-separated column, like label or split.
+func commaList(column string) []string {
+	var items []string
+	for _, item := range strings.Split(column, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
 		}
+	}
+	return items
+}
 
-    scanner := bufio.NewScanner(reader)
-    scanner.Scan()
-    headers := strings.Split(scanner.Text(), "\t")
-    fmt.Println(headers)
-
-    ttrans := make([]TableTrans, 1)
-
-    for scanner.Scan() {
-      line := scanner.Text()
-      pieces := strings.Split(line, "\t")
-      trans := make(map[string]string)
-
-      for idx, header := range headers {
-        trans[header] = pieces[idx]
-      }
-
-      date, err := time.Parse("2006-01-02", trans["date"])
-      if err != nil {
-        return nil, fmt.Errorf("Invalid date %s in line {%s}", trans["date"], line)
-      }
-
-      amount, err := strconv.ParseFloat(trans["amount"], 64)
-      if err != nil {
-        return nil, fmt.Errorf("Invalid amount %s in line {%s}", trans["amount"], line)
-      }
-      ttrans = append(ttrans, TableTrans{
-        Account: trans["account"],
-        Date: date,
-        Description: trans["description"],
-        Category: trans["category"],
-        Amount: amount,
-      })
-    }
-
-    return ttrans, nil
+// categoryPath splits a category column, which is "uncategorized" if empty.
+func categoryPath(category string) []string {
+	if category == "" {
+		return []string{"uncategorized"}
+	}
+	return strings.Split(category, ":")
 }
 
-func splitTrans(t *plaid.Transaction, acct1, acct2 string) ledger.Transaction {
-	changes := []ledger.Change{
-		{ledger.Expense(t.Category...), t.Amount},
-		{ledger.Liability(t.AccountID, acct1), -1 * t.Amount / 2},
-		{Account: ledger.Liability(t.AccountID, acct2)},
+// categorizeRow re-categorizes a row with the first matching rule, treating
+// its category column as Plaid's.  A label or split already in the row is
+// kept.
+func categorizeRow(engine *rules.Engine, t TableTrans) TableTrans {
+	var category []string
+	if t.Category != "" {
+		category = strings.Split(t.Category, ":")
 	}
 
-	date, err := time.Parse(plaid.DateFmt, t.Date)
-	if err != nil {
-		log.Fatalf("Unable to parse date: %s", err)
+	result := engine.Categorize(rules.Transaction{
+		Name:     t.Description,
+		Amount:   t.Amount,
+		Account:  t.Account,
+		Category: category,
+		Date:     t.Date,
+	})
+
+	if result.Rule == "" {
+		return t
 	}
 
-	return ledger.Transaction{
-		Date:        date,
-		Description: t.Name,
-		Changes:     changes,
+	t.Category = result.Category
+	if t.Label == "" {
+		t.Label = strings.Join(result.Tags, ",")
+	}
+	if t.Split == "" {
+		t.Split = strings.Join(result.Split, ",")
 	}
+	return t
 }
 
-func makeLTrans(t *plaid.Transaction, acct string) ledger.Transaction {
-	changes := []ledger.Change{
-		{ledger.Expense(t.Category...), t.Amount},
-		{Account: ledger.Liability(t.AccountID, acct)},
+// rowError is a problem with one line of a TSV.  Lines count from 1,
+// including the header.
+type rowError struct {
+	Line int
+	Err  string
+}
+
+func (e rowError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Err)
+}
+
+// rowErrors collects every malformed row, so they can all be fixed at once.
+type rowErrors []rowError
+
+func (e rowErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
 	}
+	return strings.Join(msgs, "; ")
+}
 
-	date, err := time.Parse(plaid.DateFmt, t.Date)
-	if err != nil {
-		log.Fatalf("Unable to parse date: %s", err)
+// tsvRequired are the columns a row can't be imported without.
+var tsvRequired = []string{"date", "amount"}
+
+func readTsv(reader io.Reader) ([]TableTrans, error) {
+	scanner := bufio.NewScanner(reader)
+
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, rowErrors{{1, "missing header row"}}
+	}
+
+	headers := strings.Split(strings.TrimRight(scanner.Text(), "\r"), "\t")
+	columns := make(map[string]int)
+	for idx, header := range headers {
+		columns[strings.ToLower(strings.TrimSpace(header))] = idx
+	}
+
+	for _, required := range tsvRequired {
+		if _, ok := columns[required]; !ok {
+			return nil, rowErrors{{1, fmt.Sprintf("missing %s column", required)}}
+		}
+	}
+
+	ttrans := make([]TableTrans, 0)
+	errs := make(rowErrors, 0)
+
+	for lineNo := 2; scanner.Scan(); lineNo++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		pieces := strings.Split(line, "\t")
+		if len(pieces) != len(headers) {
+			errs = append(errs, rowError{lineNo,
+				fmt.Sprintf("expected %d columns, got %d", len(headers), len(pieces))})
+			continue
+		}
+
+		field := func(name string) string {
+			idx, ok := columns[name]
+			if !ok {
+				return ""
+			}
+			return strings.TrimSpace(pieces[idx])
+		}
+
+		date, err := time.Parse(lib.DateFmt, field("date"))
+		if err != nil {
+			errs = append(errs, rowError{lineNo, fmt.Sprintf("invalid date %q", field("date"))})
+			continue
+		}
+
+		amount, err := money.Parse(field("amount"))
+		if err != nil {
+			errs = append(errs, rowError{lineNo, err.Error()})
+			continue
+		}
+
+		ttrans = append(ttrans, TableTrans{
+			ID:          field("id"),
+			Account:     field("account"),
+			Date:        date,
+			Description: field("description"),
+			Category:    field("category"),
+			Label:       field("label"),
+			Split:       field("split"),
+			Amount:      amount,
+		})
 	}
 
-	return ledger.Transaction{
-		Date:        date,
-		Description: t.Name,
-		Changes:     changes,
+	if err := scanner.Err(); err != nil {
+		return nil, err
 	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	return ttrans, nil
+}
+
+// plaidLTrans books Plaid transactions, pairing up transfers between the
+// response's accounts.  Accounts are named by ledger_accounts, or else by
+// nickname under the root their Plaid type calls for.  Categories come from
+// the rules.
+func plaidLTrans(engine *rules.Engine, resp plaid.TransactionResponse, nickMap map[string]string) ([]ledger.Transaction, error) {
+	configured := lib.LedgerAccounts()
+	accounts := make(map[string]ledger.AccountName)
+
+	for _, a := range resp.Accounts {
+		nick := nickMap[a.ID]
+		if name, ok := configured[strings.ToLower(nick)]; ok && nick != "" {
+			accounts[a.ID] = name
+			continue
+		}
+
+		if nick == "" {
+			nick = a.Name
+		}
+		accounts[a.ID] = ledger.PlaidAccount(a, nick)
+	}
+
+	entries := make([]ledger.Entry, 0, len(resp.Transactions))
+	for _, t := range resp.Transactions {
+		account, ok := accounts[t.AccountID]
+		if !ok {
+			return nil, fmt.Errorf("transaction %s is in unknown account %s", t.ID, t.AccountID)
+		}
+
+		entry, err := ledger.PlaidEntry(t, account)
+		if err != nil {
+			return nil, err
+		}
+
+		result := engine.Categorize(rules.FromPlaid(t, nickMap[t.AccountID]))
+		if result.Category != "" {
+			entry.Category = strings.Split(result.Category, ":")
+		}
+
+		entries = append(entries, entry)
+	}
+
+	lTrans := ledger.Book(entries, ledger.TransferWindow)
+	for _, t := range lTrans {
+		if err := t.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	return lTrans, nil
 }
 
 // ledgerCmd represents the ledger command
@@ -171,5 +459,7 @@ func init() {
 	RootCmd.AddCommand(ledgerCmd)
 
 	ledgerCmd.AddCommand(ledgerImportCmd)
-	ledgerImportCmd.Flags().StringP("file", "f", "", "File to read transaction data from")
+	ledgerImportCmd.Flags().StringP("file", "f", "", "File to read transaction data from, or stdin if empty")
+	addRowFlags(ledgerImportCmd)
+	ledgerImportCmd.Flags().String("journal", "", "Journal to append new transactions to, skipping ones it already has")
 }
@@ -0,0 +1,101 @@
+// Copyright © 2017 Paul Carleton
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pcarleton/cashcoach/api/money"
+)
+
+func TestReadTsv(t *testing.T) {
+	tsv := "Account\tDate\tDescription\tAmount\tCategory\tLabel\tID\r\n" +
+		"checking\t2018-03-01\t\"JOE'S\" CAFE, INC\t4.50\tFood:Coffee\twork\ttxn-1\r\n" +
+		"\n" +
+		"   \n" +
+		"visa\t2018-03-02\tRefund\t-12.00\t\t\t\n"
+
+	got, err := readTsv(strings.NewReader(tsv))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []TableTrans{
+		{
+			ID:          "txn-1",
+			Account:     "checking",
+			Date:        time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC),
+			Description: `"JOE'S" CAFE, INC`,
+			Category:    "Food:Coffee",
+			Label:       "work",
+			Amount:      money.MustParse("4.50"),
+		},
+		{
+			Account:     "visa",
+			Date:        time.Date(2018, 3, 2, 0, 0, 0, 0, time.UTC),
+			Description: "Refund",
+			Amount:      money.MustParse("-12"),
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+}
+
+func TestReadTsvColumnsInAnyOrder(t *testing.T) {
+	got, err := readTsv(strings.NewReader("amount\tdate\n1.00\t2018-03-01\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Amount != money.MustParse("1") || got[0].Account != "" {
+		t.Errorf("got %+v", got)
+	}
+}
+
+func TestReadTsvErrors(t *testing.T) {
+	cases := []struct {
+		name string
+		tsv  string
+		want string
+	}{
+		{"empty", "", "line 1: missing header row"},
+		{"no amount column", "date\tdescription\n2018-03-01\tx\n", "line 1: missing amount column"},
+		{"no date column", "amount\n1.00\n", "line 1: missing date column"},
+		{"every bad row", "date\tamount\n" +
+			"2018-03-01\n" +
+			"2018-03-01\t1.00\textra\n" +
+			"03/01/2018\t1.00\n" +
+			"2018-03-01\t\n" +
+			"2018-03-01\t1.005\n" +
+			"2018-03-01\t1.00\n",
+			`line 2: expected 2 columns, got 1; ` +
+				`line 3: expected 2 columns, got 3; ` +
+				`line 4: invalid date "03/01/2018"; ` +
+				`line 5: invalid amount ""; ` +
+				`line 6: amount "1.005" has fractions of a cent`},
+		// Blank lines still count towards line numbers.
+		{"after blank lines", "date\tamount\n\n\n2018-03-01\tabc\n", `line 4: invalid amount "abc"`},
+	}
+
+	for _, c := range cases {
+		_, err := readTsv(strings.NewReader(c.tsv))
+		if err == nil || err.Error() != c.want {
+			t.Errorf("%s: got %v, want %q", c.name, err, c.want)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright © 2017 Paul Carleton
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/pcarleton/cashcoach/api/money"
+	"github.com/pcarleton/cashcoach/api/rules"
+	"github.com/pcarleton/cashcoach/cash/lib"
+)
+
+type ruleTestRow struct {
+	Account       string       `json:"account"`
+	Date          string       `json:"date"`
+	Description   string       `json:"description"`
+	Amount        money.Amount `json:"amount"`
+	PlaidCategory string       `json:"plaid_category"`
+	Rule          string       `json:"rule"`
+	Category      string       `json:"category"`
+	Tags          []string     `json:"tags"`
+	Split         []string     `json:"split"`
+}
+
+// rulesCmd represents the rules command
+var rulesCmd = &cobra.Command{
+	Use:   "rules",
+	Short: "Work with categorization rules",
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+	},
+}
+
+var rulesTestCmd = &cobra.Command{
+	Use:   "test [account...]",
+	Short: "Show which rule matches each transaction",
+	Long: `Fetches transactions for each account, or every configured account if none
+are named, and prints the rule that matched each one along with the category,
+tags and split it assigned.  Transactions no rule matched show Plaid's
+category and an empty rule.  Use --unmatched to see only those, which is
+handy when writing new rules.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		accts := pickAccounts(args)
+		engine := lib.RulesOrDie()
+		interval := pickInterval(cmd)
+
+		jsonOut, err := cmd.Flags().GetBool("json")
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		unmatched, err := cmd.Flags().GetBool("unmatched")
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		delimiter := lib.StringFlagOrDie(cmd, "delimiter")
+
+		client := lib.GetClient()
+		rows := make([]ruleTestRow, 0)
+		counts := make(map[string]int)
+
+		for _, acct := range accts {
+			resp, err := client.AllTransactions(context.Background(), acct.Token, interval.Start, interval.End, nil)
+			if err != nil {
+				fatalPlaidError(acct.Name, err)
+			}
+
+			nickMap := acct.NickMap(resp.Accounts)
+			for _, t := range resp.Transactions {
+				nick := nickMap[t.AccountID]
+				result := engine.Categorize(rules.FromPlaid(t, nick))
+				counts[result.Rule]++
+
+				if unmatched && result.Rule != "" {
+					continue
+				}
+
+				rows = append(rows, ruleTestRow{
+					Account:       nick,
+					Date:          t.Date,
+					Description:   t.Name,
+					Amount:        t.Amount,
+					PlaidCategory: strings.Join(t.Category, ":"),
+					Rule:          result.Rule,
+					Category:      result.Category,
+					Tags:          result.Tags,
+					Split:         result.Split,
+				})
+			}
+		}
+
+		for _, r := range engine.Rules() {
+			log.Printf("%s: %d matched", r.Name, counts[r.Name])
+		}
+		log.Printf("(no rule): %d", counts[""])
+
+		if jsonOut {
+			lib.OutputJson(rows)
+			return
+		}
+
+		headers := []string{"account", "date", "description", "amount", "plaid category", "rule", "category", "tags", "split"}
+		fmt.Println(strings.Join(headers, delimiter))
+
+		for _, r := range rows {
+			fmt.Println(strings.Join([]string{
+				r.Account,
+				r.Date,
+				r.Description,
+				r.Amount.String(),
+				r.PlaidCategory,
+				r.Rule,
+				r.Category,
+				strings.Join(r.Tags, ","),
+				strings.Join(r.Split, ","),
+			}, delimiter))
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(rulesCmd)
+	rulesCmd.AddCommand(rulesTestCmd)
+	rulesTestCmd.Flags().StringP("start", "s", "", "Start date to find transactions (like 2006-01-03)")
+	rulesTestCmd.Flags().StringP("end", "e", "", "End date to find transactions (inclusive)")
+	rulesTestCmd.Flags().IntP("lastN", "l", 0, "Fetch transactions for the last N days")
+	rulesTestCmd.Flags().Bool("unmatched", false, "Only show transactions no rule matched")
+	rulesTestCmd.Flags().StringP("delimiter", "d", "\t", "Delimiter to use for printing")
+	rulesTestCmd.Flags().BoolP("json", "j", false, "When true, output results as JSON")
+}
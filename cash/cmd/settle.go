@@ -0,0 +1,82 @@
+// Copyright © 2017 Paul Carleton
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"log"
+
+	"github.com/spf13/cobra"
+
+	"github.com/pcarleton/cashcoach/cash/lib"
+	"github.com/pcarleton/cashcoach/cash/lib/ledger"
+)
+
+var settleCmd = &cobra.Command{
+	Use:   "settle",
+	Short: "Work out who owes whom for shared expenses",
+	Long: `Totals the assets:receivable:<payer>:<person> postings that "cash ledger
+import" writes for shared expenses, and prints what each person owes each
+other person, netted in both directions.
+
+Record paying someone back against the same accounts, e.g. a transfer from
+taylor to paul posted to assets:receivable:paul:taylor, and it counts
+against what's owed.  Use --start and --end to settle a single period.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		format := lib.StringFlagOrDie(cmd, "format")
+		if format != "table" && format != "tsv" && format != "json" {
+			log.Fatalf("Unknown format %q, expected table, tsv or json", format)
+		}
+
+		filter := ledger.Filter{}
+		if start := lib.StringFlagOrDie(cmd, "start"); start != "" {
+			filter.Start = lib.DateOrDie(start)
+		}
+		if end := lib.StringFlagOrDie(cmd, "end"); end != "" {
+			filter.End = lib.DateOrDie(end)
+		}
+
+		journal := readJournal(lib.StringFlagOrDie(cmd, "file"))
+		debts := ledger.Settle(journal.Transactions, filter)
+
+		if format == "json" {
+			lib.OutputJson(debts)
+			return
+		}
+
+		if len(debts) == 0 && format == "table" {
+			log.Print("Everyone is settled up")
+			return
+		}
+
+		rows := make([][]string, len(debts))
+		for i, d := range debts {
+			amount := d.Amount.String()
+			if format == "table" {
+				amount = d.AmountString()
+			}
+			rows[i] = []string{d.From, d.To, d.Commodity, amount}
+		}
+
+		printReport(format, []string{"from", "to", "commodity", "amount"}, rows)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(settleCmd)
+	settleCmd.Flags().StringP("file", "f", "", "Journal to read, or stdin if empty")
+	settleCmd.Flags().StringP("start", "s", "", "Only include transactions on or after this date (like 2006-01-03)")
+	settleCmd.Flags().StringP("end", "e", "", "Only include transactions on or before this date")
+	settleCmd.Flags().String("format", "table", "Output format: table, tsv or json")
+}
@@ -17,7 +17,6 @@ package cmd
 import (
 	"fmt"
 	"log"
-	"os"
 	"strings"
 
 	"github.com/pcarleton/cashcoach/cash/lib"
@@ -39,16 +38,14 @@ var sheetsCmd = &cobra.Command{
 var createCmd = &cobra.Command{
 	Use:   "create",
 	Short: "create Google sheets from TSV",
-	Args:  cobra.NoArgs,
+	Long: `Creates a spreadsheet from a TSV, or from a CSV, OFX, QFX or QIF bank
+statement with --format, which is categorized with the rules and laid out
+like "cash transactions" prints.`,
+	Args: cobra.NoArgs,
 	Run: func(cmd *cobra.Command, args []string) {
 		client := lib.GetSheetsClient()
-		fname := lib.StringFlagOrDie(cmd, "file")
 		title := lib.StringFlagOrDie(cmd, "title")
-
-		reader, err := os.Open(fname)
-		if err != nil {
-			log.Fatalf("Unable to open file: %v", err)
-		}
+		reader := tsvOrDie(cmd)
 
 		r, err := client.CreateSpreadsheetFromTsv(title, reader)
 		if err != nil {
@@ -72,18 +69,16 @@ var createCmd = &cobra.Command{
 var importCmd = &cobra.Command{
 	Use:   "import",
 	Short: "import a TSV to an existing Google sheet",
+	Long: `Replaces a sheet's contents with a TSV, or with a CSV, OFX, QFX or QIF
+bank statement with --format, which is categorized with the rules and laid out
+like "cash transactions" prints.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		fname := lib.StringFlagOrDie(cmd, "file")
 		ssId := lib.StringFlagOrDie(cmd, "spreadsheet")
 		sheetName := lib.StringFlagOrDie(cmd, "name")
 
 		client := lib.GetSheetsClient()
 
-		reader, err := os.Open(fname)
-		if err != nil {
-			log.Fatalf("Unable to open file: %v", err)
-		}
-		data := sheets.TsvToArr(reader)
+		data := sheets.TsvToArr(tsvOrDie(cmd))
 
 		ss, err := client.GetSpreadsheet(ssId)
 		if err != nil {
@@ -248,6 +243,7 @@ func init() {
 	sheetsCmd.AddCommand(createCmd)
 	createCmd.Flags().StringP("file", "f", "", "The file to read data from, if not set use STDIN")
 	createCmd.Flags().StringP("title", "t", "", "The title to give the spreadsheet")
+	addRowFlags(createCmd)
 
 	sheetsCmd.AddCommand(deleteCmd)
 	sheetsCmd.AddCommand(importCmd)
@@ -255,6 +251,7 @@ func init() {
 	importCmd.Flags().StringP("file", "f", "", "The file to read data from, if not set use STDIN")
 	importCmd.Flags().StringP("spreadsheet", "s", "", "The ID of the spreadsheet to import to")
 	importCmd.Flags().StringP("name", "n", "", "The name of the sheet to import to")
+	addRowFlags(importCmd)
 
 	sheetsCmd.AddCommand(pullCmd)
 
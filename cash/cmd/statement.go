@@ -0,0 +1,175 @@
+// Copyright © 2017 Paul Carleton
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/pcarleton/cashcoach/cash/lib"
+	"github.com/pcarleton/cashcoach/cash/lib/ledger"
+	"github.com/pcarleton/cashcoach/cash/lib/statement"
+)
+
+// addRowFlags adds the flags readRowsOrDie reads.
+func addRowFlags(cmd *cobra.Command) {
+	cmd.Flags().String("format", "tsv", "Input format: tsv, csv, ofx (or qfx) or qif")
+	cmd.Flags().String("profile", "", "The csv_profiles entry to read a csv with")
+	cmd.Flags().String("account", "", "Account nickname for csv, ofx and qif rows, in place of the statement's own")
+}
+
+// openOrStdin opens filename, or returns stdin if it's empty or "-".
+func openOrStdin(filename string) io.ReadCloser {
+	if filename == "" || filename == "-" {
+		return os.Stdin
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		log.Fatalf("Unable to open file: %v", err)
+	}
+	return f
+}
+
+// readRowsOrDie reads the --file flag's rows in the --format flag's format.
+func readRowsOrDie(cmd *cobra.Command) []TableTrans {
+	reader := openOrStdin(lib.StringFlagOrDie(cmd, "file"))
+	defer reader.Close()
+
+	format := strings.ToLower(lib.StringFlagOrDie(cmd, "format"))
+	name := lib.StringFlagOrDie(cmd, "profile")
+
+	var profile *statement.CSVProfile
+	switch {
+	case format == "csv" && name == "":
+		log.Fatalf("--format csv needs a --profile from csv_profiles")
+	case format == "csv":
+		profile = lib.CSVProfileOrDie(name)
+	case name != "":
+		log.Fatalf("--profile is only for --format csv")
+	}
+
+	ttrans, err := readRows(reader, format, lib.StringFlagOrDie(cmd, "account"), profile)
+
+	if err != nil {
+		if rowErrs, ok := err.(rowErrors); ok {
+			for _, e := range rowErrs {
+				log.Print(e)
+			}
+			log.Fatalf("%d malformed rows, nothing imported", len(rowErrs))
+		}
+		log.Fatalf("Unable to load transactions: %v", err)
+	}
+
+	return ttrans
+}
+
+// readRows reads rows from a TSV like "cash transactions" prints, or from
+// a bank statement.  CSV statements are read with profile.  Statement rows
+// are in account, if it's set.
+func readRows(reader io.Reader, format, account string, profile *statement.CSVProfile) ([]TableTrans, error) {
+	var txns []statement.Transaction
+	var err error
+
+	switch format {
+	case "tsv":
+		return readTsv(reader)
+	case "csv":
+		txns, err = statement.ParseCSV(reader, profile)
+	case "ofx", "qfx":
+		txns, err = statement.ParseOFX(reader)
+	case "qif":
+		txns, err = statement.ParseQIF(reader)
+	default:
+		return nil, fmt.Errorf("unknown format %q, expected tsv, csv, ofx or qif", format)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	txns = statement.Dedup(txns)
+	ttrans := make([]TableTrans, len(txns))
+
+	for i, t := range txns {
+		if account != "" {
+			t.Account = account
+		}
+		if t.Account == "" {
+			return nil, fmt.Errorf("the statement doesn't name its account; set --account")
+		}
+
+		ttrans[i] = TableTrans{
+			ID:          t.ID,
+			Account:     t.Account,
+			Date:        t.Date,
+			Description: t.Description,
+			Category:    t.Category,
+			Amount:      t.Amount,
+		}
+		if t.ID != "" {
+			ttrans[i].IDKey = ledger.FITID
+		}
+	}
+
+	return ttrans, nil
+}
+
+// tableRow formats a row to line up with transactionHeaders.
+func tableRow(t TableTrans) []string {
+	return []string{
+		t.Account,
+		t.Date.Format(lib.DateFmt),
+		t.Description,
+		t.Category,
+		t.Label,
+		t.Split,
+		t.Amount.String(),
+		t.ID,
+	}
+}
+
+// tsvOrDie reads the --file flag's rows and returns them as a TSV, so
+// statements can go anywhere the TSV "cash transactions" prints can.  Rows
+// are categorized with the rules, as "cash transactions" does.  A TSV is
+// passed through as it is.
+func tsvOrDie(cmd *cobra.Command) io.Reader {
+	if strings.ToLower(lib.StringFlagOrDie(cmd, "format")) == "tsv" {
+		reader := openOrStdin(lib.StringFlagOrDie(cmd, "file"))
+		defer reader.Close()
+
+		var buf bytes.Buffer
+		if _, err := io.Copy(&buf, reader); err != nil {
+			log.Fatalf("Unable to read file: %v", err)
+		}
+		return &buf
+	}
+
+	ttrans := readRowsOrDie(cmd)
+	engine := lib.RulesOrDie()
+
+	var buf bytes.Buffer
+	fmt.Fprintln(&buf, strings.Join(transactionHeaders, "\t"))
+	for _, t := range ttrans {
+		fmt.Fprintln(&buf, strings.Join(tableRow(categorizeRow(engine, t)), "\t"))
+	}
+	return &buf
+}
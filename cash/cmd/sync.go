@@ -0,0 +1,145 @@
+// Copyright © 2017 Paul Carleton
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/pcarleton/cashcoach/api/plaid"
+	"github.com/pcarleton/cashcoach/api/rules"
+	"github.com/pcarleton/cashcoach/cash/lib"
+)
+
+type syncChange struct {
+	Status      string            `json:"status"`
+	Account     string            `json:"account"`
+	Transaction plaid.Transaction `json:"transaction"`
+
+	// Categorization is left out for removed transactions.
+	Categorization *rules.Result `json:"categorization,omitempty"`
+}
+
+// syncCmd represents the sync command
+var syncCmd = &cobra.Command{
+	Use:   "sync [account...]",
+	Short: "Fetch transactions changed since the last sync",
+	Long: `Fetches added, modified and removed transactions for each account using
+/transactions/sync, then saves the new cursor so the next run only sees what
+changed.  With no arguments every configured account is synced.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		accts := pickAccounts(args)
+
+		state, err := lib.LoadState()
+		if err != nil {
+			log.Fatalf("Unable to load state: %v", err)
+		}
+
+		dryRun, err := cmd.Flags().GetBool("dry-run")
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		reset, err := cmd.Flags().GetBool("reset")
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		jsonOut, err := cmd.Flags().GetBool("json")
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		delimiter := lib.StringFlagOrDie(cmd, "delimiter")
+
+		client := lib.GetClient()
+		engine := lib.RulesOrDie()
+		changes := make([]syncChange, 0)
+
+		categorize := func(t plaid.Transaction, nick string) *rules.Result {
+			result := engine.Categorize(rules.FromPlaid(t, nick))
+			return &result
+		}
+
+		for _, acct := range accts {
+			cursor := state.Cursors[acct.Name]
+			if reset {
+				cursor = ""
+			}
+
+			resp, err := client.SyncAll(context.Background(), acct.Token, cursor)
+			if err != nil {
+				fatalPlaidError(acct.Name, err)
+			}
+
+			log.Printf("%s: %d added, %d modified, %d removed", acct.Name,
+				len(resp.Added), len(resp.Modified), len(resp.Removed))
+
+			nickMap := acct.NickMap(resp.Accounts)
+			for _, t := range resp.Added {
+				nick := nickMap[t.AccountID]
+				changes = append(changes, syncChange{"added", nick, t, categorize(t, nick)})
+			}
+			for _, t := range resp.Modified {
+				nick := nickMap[t.AccountID]
+				changes = append(changes, syncChange{"modified", nick, t, categorize(t, nick)})
+			}
+			for _, r := range resp.Removed {
+				changes = append(changes, syncChange{"removed", acct.Name, plaid.Transaction{ID: r.ID}, nil})
+			}
+
+			state.Cursors[acct.Name] = resp.NextCursor
+		}
+
+		if jsonOut {
+			lib.OutputJson(changes)
+		} else {
+			headers := append([]string{"status"}, transactionHeaders...)
+			fmt.Println(strings.Join(append(headers, "id"), "\t"))
+
+			for _, c := range changes {
+				// Removed transactions only come back with an ID.
+				row := make([]string, len(transactionHeaders))
+				row[0] = c.Account
+				if c.Status != "removed" {
+					row = transactionRow(engine, map[string]string{c.Transaction.AccountID: c.Account}, c.Transaction)
+				}
+
+				row = append([]string{c.Status}, row...)
+				fmt.Println(strings.Join(append(row, c.Transaction.ID), delimiter))
+			}
+		}
+
+		if dryRun {
+			return
+		}
+
+		if err := state.Save(); err != nil {
+			log.Fatalf("Unable to save sync cursors: %v", err)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(syncCmd)
+	syncCmd.Flags().Bool("dry-run", false, "Print changes without saving the new cursors")
+	syncCmd.Flags().Bool("reset", false, "Ignore saved cursors and sync each account from the beginning")
+	syncCmd.Flags().StringP("delimiter", "d", "\t", "Delimiter to use for printing")
+	syncCmd.Flags().BoolP("json", "j", false, "When true, output changes as JSON")
+}
@@ -15,6 +15,7 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
@@ -22,6 +23,7 @@ import (
 	"time"
 
 	"github.com/pcarleton/cashcoach/api/plaid"
+	"github.com/pcarleton/cashcoach/api/rules"
 	"github.com/pcarleton/cashcoach/cash/lib"
 	"github.com/spf13/cobra"
 )
@@ -59,6 +61,34 @@ func pickInterval(cmd *cobra.Command) lib.Interval {
 	}
 }
 
+// pickAccounts picks the accounts named in args, or every configured
+// account if there are none.
+func pickAccounts(args []string) []lib.Account {
+	accts, err := lib.GetAccounts()
+	if err != nil {
+		log.Fatalf("Unable to read accounts: %v", err)
+	}
+
+	if len(args) == 0 {
+		return accts
+	}
+
+	picked := make([]lib.Account, 0, len(args))
+	for _, name := range args {
+		acct, err := lib.GetAccount(name)
+		if err != nil {
+			log.Fatalf("Unable to read accounts: %v", err)
+		}
+		if acct == nil {
+			fmt.Printf("No account with name %s.\n", name)
+			os.Exit(1)
+		}
+		picked = append(picked, *acct)
+	}
+
+	return picked
+}
+
 // transactionsCmd represents the transactions command
 var transactionsCmd = &cobra.Command{
 	Use:   "transactions",
@@ -81,10 +111,10 @@ var transactionsCmd = &cobra.Command{
 		client := lib.GetClient()
 		interval := pickInterval(cmd)
 
-		resp, err := client.Transactions(acct.Token, interval.Start, interval.End)
+		resp, err := client.AllTransactions(context.Background(), acct.Token, interval.Start, interval.End, nil)
 
 		if err != nil {
-			log.Fatal(err)
+			fatalPlaidError(acct.Name, err)
 		}
 
 		delimiter, err := cmd.Flags().GetString("delimiter")
@@ -97,47 +127,83 @@ var transactionsCmd = &cobra.Command{
 		log.Printf("%s to %s", interval.Start, interval.End)
 
 		nickMap := acct.NickMap(resp.Accounts)
+		engine := lib.RulesOrDie()
 
 		jsonOut, err := cmd.Flags().GetBool("json")
 		if err != nil {
 			log.Fatal(err)
 		}
 
+		ledgerOut, err := cmd.Flags().GetBool("ledger")
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if ledgerOut {
+			lTrans, err := plaidLTrans(engine, resp, nickMap)
+			if err != nil {
+				log.Fatalf("Unable to book transactions: %v", err)
+			}
+			writeLTrans(os.Stdout, lTrans, false)
+			return
+		}
+
 		if jsonOut {
 			// Might regret messing with the data like this later...
-			newTrans := make([]plaid.Transaction, len(resp.Transactions))
+			newTrans := make([]categorizedTransaction, len(resp.Transactions))
 			for i, t := range resp.Transactions {
 				t.AccountID = nickMap[t.AccountID]
-				newTrans[i] = t
+				newTrans[i] = categorizedTransaction{t, engine.Categorize(rules.FromPlaid(t, t.AccountID))}
 			}
 
 			lib.OutputJson(newTrans)
 			return
 		}
 
-		headers := []string{
-			"account",
-			"date",
-			"description",
-			"category",
-			"amount",
-		}
-
-		fmt.Println(strings.Join(headers, "\t"))
+		fmt.Println(strings.Join(transactionHeaders, "\t"))
 		for _, trans := range resp.Transactions {
-			pieces := []string{
-				nickMap[trans.AccountID],
-				trans.Date,
-				trans.Name,
-				strings.Join(trans.Category, ":"),
-				fmt.Sprintf("%.2f", trans.Amount),
-			}
-
-			fmt.Println(strings.Join(pieces, delimiter))
+			fmt.Println(strings.Join(transactionRow(engine, nickMap, trans), delimiter))
 		}
 	},
 }
 
+// categorizedTransaction is a transaction with what the rules made of it.
+type categorizedTransaction struct {
+	plaid.Transaction
+	Categorization rules.Result `json:"categorization"`
+}
+
+// transactionHeaders match the columns `cash ledger import` reads.
+var transactionHeaders = []string{
+	"account",
+	"date",
+	"description",
+	"category",
+	"label",
+	"split",
+	"amount",
+	"id",
+}
+
+// transactionRow formats trans to line up with transactionHeaders.  The
+// category comes from the first matching rule, or Plaid, and the label and
+// split are the rule's tags and people.
+func transactionRow(engine *rules.Engine, nickMap map[string]string, trans plaid.Transaction) []string {
+	nick := nickMap[trans.AccountID]
+	result := engine.Categorize(rules.FromPlaid(trans, nick))
+
+	return []string{
+		nick,
+		trans.Date,
+		trans.Name,
+		result.Category,
+		strings.Join(result.Tags, ","),
+		strings.Join(result.Split, ","),
+		trans.Amount.String(),
+		trans.ID,
+	}
+}
+
 func init() {
 	RootCmd.AddCommand(transactionsCmd)
 	// Cobra supports Persistent Flags which will work for this command
@@ -147,4 +213,5 @@ func init() {
 	transactionsCmd.PersistentFlags().IntP("lastN", "l", 0, "Fecth transactions for the last N days")
 	transactionsCmd.Flags().StringP("delimiter", "d", "\t", "Delimiter to use for printing")
 	transactionsCmd.Flags().BoolP("json", "j", false, "When true, output transaction data as JSON")
+	transactionsCmd.Flags().Bool("ledger", false, "When true, output a ledger journal, with transfers between the accounts paired up")
 }
@@ -5,10 +5,12 @@ import (
   "log"
   "time"
   "encoding/json"
+  "net/http"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"github.com/pcarleton/cashcoach/api/plaid"
+	"github.com/pcarleton/cashcoach/api/plaid/cassette"
 )
 
 const (
@@ -18,6 +20,8 @@ const (
 type Account struct{
   Name string
   Token string
+  // SealedToken is Token encrypted with the keyring, from `cash config seal`.
+  SealedToken string `mapstructure:"sealed_token"`
   Nicknames map[string]string
 }
 
@@ -74,6 +78,12 @@ func GetAccounts() ([]Account, error) {
     if err != nil {
       return nil, err
     }
+
+    for i := range accounts {
+      if err := accounts[i].openToken(); err != nil {
+        return nil, err
+      }
+    }
     return accounts, nil
 }
 
@@ -90,12 +100,44 @@ func GetAccount(name string) (*Account, error) {
   return nil, nil
 }
 
+// PlaidURL is the Plaid API base URL from the plaid_url config key,
+// defaulting to the development environment.  Point it at a plaidtest
+// server to work offline.
+func PlaidURL() string {
+  if url := viper.GetString("plaid_url"); url != "" {
+    return url
+  }
+  return plaid.DevURL
+}
+
+// plaidTransport returns a cassette recorder if plaid_cassette is set, so
+// a run can be recorded once and replayed later without hitting Plaid.
+func plaidTransport() http.RoundTripper {
+  path := viper.GetString("plaid_cassette")
+  if path == "" {
+    return nil
+  }
+
+  mode, err := cassette.ParseMode(viper.GetString("plaid_cassette_mode"))
+  if err != nil {
+    log.Fatal(err)
+  }
+
+  recorder, err := cassette.New(path, mode, nil)
+  if err != nil {
+    log.Fatalf("Unable to load cassette %s: %v", path, err)
+  }
+
+  return recorder
+}
+
 func GetClient() plaid.Client {
   // TODO: Memoize?
-	return plaid.NewClient(
+	return plaid.NewClientWithTransport(
 		viper.GetString("client_id"),
 		viper.GetString("client_secret"),
-		plaid.DevURL)
+		PlaidURL(),
+		plaidTransport())
 }
 
 type Interval struct {
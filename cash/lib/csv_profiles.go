@@ -0,0 +1,39 @@
+package lib
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/spf13/viper"
+
+	"github.com/pcarleton/cashcoach/cash/lib/statement"
+)
+
+// GetCSVProfile reads the named profile from the csv_profiles config key.
+// See statement.CSVProfile for the format.
+func GetCSVProfile(name string) (*statement.CSVProfile, error) {
+	profiles := make(map[string]statement.CSVProfile)
+	if err := viper.UnmarshalKey("csv_profiles", &profiles); err != nil {
+		return nil, fmt.Errorf("unable to read csv_profiles: %v", err)
+	}
+
+	// Config keys are case-insensitive, so viper lowercases them.
+	profile, ok := profiles[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("no csv_profiles entry for %q", name)
+	}
+
+	if err := profile.Validate(); err != nil {
+		return nil, fmt.Errorf("csv profile %s: %v", name, err)
+	}
+	return &profile, nil
+}
+
+func CSVProfileOrDie(name string) *statement.CSVProfile {
+	profile, err := GetCSVProfile(name)
+	if err != nil {
+		log.Fatalf("Invalid CSV profile: %v", err)
+	}
+	return profile
+}
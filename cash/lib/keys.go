@@ -0,0 +1,67 @@
+package lib
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/viper"
+
+	"github.com/pcarleton/cashcoach/api/storage"
+)
+
+// keyringEnv matches the API server, so both can share a keyring.
+const keyringEnv = "CASHCOACH_KEYRING"
+
+// GetKeyring loads the keyring from $CASHCOACH_KEYRING or the file named
+// by the keyring config key.
+func GetKeyring() (*storage.Keyring, error) {
+	if env := os.Getenv(keyringEnv); env != "" {
+		return storage.ParseKeyring([]byte(env))
+	}
+
+	path := viper.GetString("keyring")
+	if path == "" {
+		return nil, fmt.Errorf("no keyring: set %s or keyring in the config file", keyringEnv)
+	}
+
+	return storage.LoadKeyring(path)
+}
+
+// SealToken encrypts an access token for the sealed_token config key.
+func SealToken(token string) (string, error) {
+	keyring, err := GetKeyring()
+	if err != nil {
+		return "", err
+	}
+
+	env, err := storage.Seal(keyring, []byte(token))
+	if err != nil {
+		return "", err
+	}
+
+	return storage.EncodeEnvelope(env)
+}
+
+func (a *Account) openToken() error {
+	if a.SealedToken == "" {
+		return nil
+	}
+
+	keyring, err := GetKeyring()
+	if err != nil {
+		return err
+	}
+
+	env, err := storage.DecodeEnvelope(a.SealedToken)
+	if err != nil {
+		return fmt.Errorf("invalid sealed_token for %s: %v", a.Name, err)
+	}
+
+	token, err := storage.Open(keyring, env)
+	if err != nil {
+		return fmt.Errorf("unable to open sealed_token for %s: %v", a.Name, err)
+	}
+
+	a.Token = string(token)
+	return nil
+}
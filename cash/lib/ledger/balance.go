@@ -0,0 +1,169 @@
+package ledger
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/pcarleton/cashcoach/api/money"
+)
+
+// Balance returns t's changes with the elided amount filled in.
+//
+// Each commodity has to add up to zero on its own.  An elided posting
+// takes up whatever the others leave over, so when several commodities
+// are left over it becomes one posting per commodity, in the order they
+// first appear.  Without an elided posting every commodity must already
+// come to zero.  Only one posting may be elided.
+func (t *Transaction) Balance() ([]Change, error) {
+	elided := -1
+	var commodities []string
+	sums := make(map[string]money.Amount)
+
+	for i, c := range t.Changes {
+		if c.Elided {
+			if elided >= 0 {
+				return nil, t.errorf("postings to %s and %s both have no amount; only one can be elided",
+					t.Changes[elided].Account, c.Account)
+			}
+			elided = i
+			continue
+		}
+
+		if _, ok := sums[c.Commodity]; !ok {
+			commodities = append(commodities, c.Commodity)
+		}
+		sums[c.Commodity] += c.Amount
+	}
+
+	var left []string
+	for _, commodity := range commodities {
+		if sums[commodity] != 0 {
+			left = append(left, commodity)
+		}
+	}
+
+	if elided < 0 {
+		if len(left) == 0 {
+			return t.Changes, nil
+		}
+
+		off := make([]string, len(left))
+		for i, commodity := range left {
+			off[i] = formatAmount(sums[commodity], commodity)
+		}
+		return nil, t.errorf("doesn't balance, off by %s", strings.Join(off, ", "))
+	}
+
+	changes := make([]Change, 0, len(t.Changes)+len(left))
+	changes = append(changes, t.Changes[:elided]...)
+
+	if len(left) == 0 {
+		// Everything else balances, so the elided posting is zero.
+		c := t.Changes[elided]
+		c.Amount = 0
+		c.Elided = false
+		changes = append(changes, c)
+	}
+	for _, commodity := range left {
+		c := t.Changes[elided]
+		c.Amount = -sums[commodity]
+		c.Commodity = commodity
+		c.Elided = false
+		changes = append(changes, c)
+	}
+
+	return append(changes, t.Changes[elided+1:]...), nil
+}
+
+var metaKeyPattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_-]*$`)
+
+// Validate checks t can be written to a journal and read back the same:
+// it has a date and postings, its accounts are named, its description
+// and metadata fit on one line without looking like a comment, and it
+// balances.
+func (t *Transaction) Validate() error {
+	if t.Date.IsZero() {
+		return t.errorf("has no date")
+	}
+
+	if strings.ContainsAny(t.Description, "\r\n") {
+		return t.errorf("description must fit on one line")
+	}
+
+	// A ";" after a tab or two spaces would be read back as a comment.
+	if commentStart(t.Description) >= 0 {
+		return t.errorf("description can't have a \";\" after a tab or two spaces")
+	}
+
+	if strings.ContainsAny(t.Code, "\r\n()") {
+		return t.errorf("code %q can't be written to a journal", t.Code)
+	}
+
+	for key, value := range t.Meta {
+		if !metaKeyPattern.MatchString(key) || strings.ContainsAny(value, "\r\n") {
+			return t.errorf("metadata %q can't be written to a journal", key)
+		}
+	}
+
+	if len(t.Changes) == 0 {
+		return t.errorf("has no postings")
+	}
+
+	for _, c := range t.Changes {
+		if err := t.validateAccount(c.Account); err != nil {
+			return err
+		}
+	}
+
+	_, err := t.Balance()
+	return err
+}
+
+func (t *Transaction) validateAccount(account AccountName) error {
+	if len(account) == 0 {
+		return t.errorf("has a posting with no account")
+	}
+
+	for _, piece := range account {
+		if strings.TrimSpace(piece) == "" {
+			return t.errorf("account %q has an empty part", account.String())
+		}
+		// Two spaces or a tab would end the account name when read back.
+		if strings.Contains(piece, "  ") || strings.ContainsAny(piece, "\t\r\n;") {
+			return t.errorf("account %q can't be written to a journal", account.String())
+		}
+	}
+	return nil
+}
+
+// errorf describes a problem with t, by where it was read from if it was
+// parsed and by its date and description otherwise.
+func (t *Transaction) errorf(format string, v ...interface{}) error {
+	msg := fmt.Sprintf(format, v...)
+	if t.Pos.Line > 0 {
+		return &ParseError{t.Pos, "transaction " + msg}
+	}
+	return fmt.Errorf("transaction %s %q %s", t.Date.Format(DateFmt), t.Description, msg)
+}
+
+// inferred returns Balance's changes, or the changes as they are if t
+// doesn't balance.
+func (t *Transaction) inferred() []Change {
+	changes, err := t.Balance()
+	if err != nil {
+		return t.Changes
+	}
+	return changes
+}
+
+// Validate checks every transaction in the journal, returning the first
+// problem.
+func (j *Journal) Validate() error {
+	for i := range j.Transactions {
+		if err := j.Transactions[i].Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
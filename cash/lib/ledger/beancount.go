@@ -0,0 +1,257 @@
+package ledger
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// BeancountDateFmt is how beancount writes dates.
+const BeancountDateFmt = "2006-01-02"
+
+// beancountRoots maps our top-level accounts to beancount's five.
+var beancountRoots = map[string]string{
+	"assets":      "Assets",
+	"liabilities": "Liabilities",
+	"expenses":    "Expenses",
+	"income":      "Income",
+	"equity":      "Equity",
+}
+
+// beancountSymbols are the currency symbols we know the code for.
+var beancountSymbols = map[string]string{
+	"$": "USD",
+	"€": "EUR",
+	"£": "GBP",
+	"¥": "JPY",
+}
+
+var beancountCurrency = regexp.MustCompile(`^[A-Z][A-Z0-9'._-]{0,22}[A-Z0-9]$`)
+
+// BeancountAccount names account the way beancount requires: one of its
+// five roots, then components that start with a capital letter or digit
+// and hold only letters, digits and dashes.  Other characters, like the
+// spaces in Plaid's "Food and Drink", break words, and "&" becomes "And",
+// so "expenses:food & drink" is Expenses:Food-And-Drink.
+func BeancountAccount(account AccountName) (string, error) {
+	if len(account) == 0 {
+		return "", fmt.Errorf("empty account")
+	}
+
+	root, ok := beancountRoots[strings.ToLower(strings.TrimSpace(account[0]))]
+	if !ok {
+		return "", fmt.Errorf("account %s isn't under assets, liabilities, expenses, income or equity", account)
+	}
+
+	pieces := []string{root}
+	for _, piece := range account[1:] {
+		pieces = append(pieces, beancountComponent(piece))
+	}
+	return strings.Join(pieces, ":"), nil
+}
+
+func beancountComponent(piece string) string {
+	piece = strings.Replace(piece, "&", " and ", -1)
+	words := strings.FieldsFunc(piece, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	for i, word := range words {
+		runes := []rune(word)
+		runes[0] = unicode.ToUpper(runes[0])
+		words[i] = string(runes)
+	}
+
+	if len(words) == 0 {
+		return "Unknown"
+	}
+	return strings.Join(words, "-")
+}
+
+// BeancountCurrency maps a ledger commodity to a beancount currency: known
+// symbols like "$" to their codes, codes to upper case, and no commodity
+// to def.
+func BeancountCurrency(commodity, def string) (string, error) {
+	if commodity == "" {
+		commodity = def
+	}
+	if code, ok := beancountSymbols[commodity]; ok {
+		commodity = code
+	}
+
+	currency := strings.ToUpper(commodity)
+	if !beancountCurrency.MatchString(currency) {
+		return "", fmt.Errorf("commodity %q isn't a valid beancount currency", commodity)
+	}
+	return currency, nil
+}
+
+// beancountTag makes s usable as a beancount tag, which only holds
+// letters, digits and "-_/.".
+func beancountTag(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("-_/.", r) {
+			return r
+		}
+		return '-'
+	}, strings.TrimSpace(s))
+}
+
+// beancountMetaKey makes key usable as beancount metadata, whose keys start
+// with a lower case letter.
+func beancountMetaKey(key string) string {
+	runes := []rune(key)
+	runes[0] = unicode.ToLower(runes[0])
+	return string(runes)
+}
+
+func beancountString(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	return `"` + strings.Replace(s, `"`, `\"`, -1) + `"`
+}
+
+type beancountPosting struct {
+	account, currency, comment string
+	change                     Change
+}
+
+type beancountTransaction struct {
+	t        *Transaction
+	postings []beancountPosting
+}
+
+// WriteBeancount writes transactions as a beancount file.  Every account
+// gets an open directive dated when it's first used, listing the
+// currencies posted to it.  Elided amounts are filled in, since beancount
+// doesn't infer them across currencies the way ledger does.  Amounts with
+// no commodity are in currency.  Meta and the code become metadata, so
+// plaid_id: abc is kept as plaid_id: "abc".  Two accounts that would
+// have the same beancount name, like "food & drink" and "food and drink",
+// are an error rather than being merged.
+func WriteBeancount(w io.Writer, transactions []Transaction, currency string) error {
+	type opening struct {
+		date       time.Time
+		currencies map[string]bool
+		// from is the account's name in the journal.
+		from string
+	}
+	opened := make(map[string]*opening)
+
+	converted := make([]beancountTransaction, len(transactions))
+	for i := range transactions {
+		t := &transactions[i]
+		if err := t.Validate(); err != nil {
+			return err
+		}
+
+		changes, err := t.Balance()
+		if err != nil {
+			return err
+		}
+
+		bt := beancountTransaction{t: t}
+		for _, c := range changes {
+			account, err := BeancountAccount(c.Account)
+			if err != nil {
+				return t.errorf("%v", err)
+			}
+			cur, err := BeancountCurrency(c.Commodity, currency)
+			if err != nil {
+				return t.errorf("%v", err)
+			}
+
+			o, ok := opened[account]
+			if !ok {
+				o = &opening{date: t.Date, currencies: make(map[string]bool), from: c.Account.String()}
+				opened[account] = o
+			}
+			if o.from != c.Account.String() {
+				return t.errorf("account %q would be %s in beancount, the same as %q", c.Account, account, o.from)
+			}
+			if t.Date.Before(o.date) {
+				o.date = t.Date
+			}
+			o.currencies[cur] = true
+
+			bt.postings = append(bt.postings, beancountPosting{account, cur, c.Comment, c})
+		}
+		converted[i] = bt
+	}
+
+	accounts := make([]string, 0, len(opened))
+	for account := range opened {
+		accounts = append(accounts, account)
+	}
+	sort.Slice(accounts, func(i, j int) bool {
+		a, b := opened[accounts[i]], opened[accounts[j]]
+		if !a.date.Equal(b.date) {
+			return a.date.Before(b.date)
+		}
+		return accounts[i] < accounts[j]
+	})
+
+	bw := bufio.NewWriter(w)
+
+	if currency != "" {
+		if cur, err := BeancountCurrency(currency, ""); err == nil {
+			fmt.Fprintf(bw, "option \"operating_currency\" %s\n\n", beancountString(cur))
+		}
+	}
+
+	for _, account := range accounts {
+		o := opened[account]
+		currencies := make([]string, 0, len(o.currencies))
+		for cur := range o.currencies {
+			currencies = append(currencies, cur)
+		}
+		sort.Strings(currencies)
+		fmt.Fprintf(bw, "%s open %s %s\n", o.date.Format(BeancountDateFmt), account, strings.Join(currencies, ","))
+	}
+
+	for _, bt := range converted {
+		fmt.Fprintln(bw)
+		writeBeancountTransaction(bw, bt)
+	}
+
+	return bw.Flush()
+}
+
+func writeBeancountTransaction(w io.Writer, bt beancountTransaction) {
+	t := bt.t
+
+	flag := "*"
+	if t.State == Pending {
+		flag = "!"
+	}
+
+	header := fmt.Sprintf("%s %s %s", t.Date.Format(BeancountDateFmt), flag, beancountString(t.Description))
+	for _, tag := range t.Tags {
+		if tag = beancountTag(tag); tag != "" {
+			header += " #" + tag
+		}
+	}
+	fmt.Fprintln(w, header)
+
+	if _, ok := t.Meta["code"]; t.Code != "" && !ok {
+		fmt.Fprintf(w, "  code: %s\n", beancountString(t.Code))
+	}
+	for _, key := range t.metaKeys() {
+		fmt.Fprintf(w, "  %s: %s\n", beancountMetaKey(key), beancountString(t.Meta[key]))
+	}
+	for _, comment := range t.Comments {
+		fmt.Fprintf(w, "  ; %s\n", comment)
+	}
+
+	for _, p := range bt.postings {
+		line := fmt.Sprintf("  %s  %s %s", p.account, p.change.Amount, p.currency)
+		if p.comment != "" {
+			line += " ; " + p.comment
+		}
+		fmt.Fprintln(w, line)
+	}
+}
@@ -0,0 +1,59 @@
+package ledger_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/pcarleton/cashcoach/cash/lib/ledger"
+)
+
+func TestWriteBeancount(t *testing.T) {
+	j, err := ledger.ParseFile(filepath.Join("testdata", "export.ledger"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got bytes.Buffer
+	if err := ledger.WriteBeancount(&got, j.Transactions, "USD"); err != nil {
+		t.Fatal(err)
+	}
+
+	want, err := ioutil.ReadFile(filepath.Join("testdata", "export.beancount"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.String() != string(want) {
+		t.Errorf("got\n%s\nwant\n%s", got.String(), want)
+	}
+}
+
+func TestWriteBeancountCollisions(t *testing.T) {
+	cases := []struct {
+		journal string
+		want    string
+	}{
+		{`2018/03/01 Lunch
+    expenses:food & drink    $5.00
+    assets:checking
+
+2018/03/02 Dinner
+    expenses:food and drink    $9.00
+    assets:checking
+`, `test.ledger:5: transaction account "expenses:food and drink" would be Expenses:Food-And-Drink in beancount, the same as "expenses:food & drink"`},
+		{`2018/03/01 Lunch
+    expenses:Food    $5.00
+    expenses:food    $5.00
+    assets:checking
+`, `test.ledger:1: transaction account "expenses:food" would be Expenses:Food in beancount, the same as "expenses:Food"`},
+	}
+
+	for _, c := range cases {
+		j := parse(t, c.journal)
+		err := ledger.WriteBeancount(ioutil.Discard, j.Transactions, "USD")
+		if err == nil || err.Error() != c.want {
+			t.Errorf("got %v, want %s", err, c.want)
+		}
+	}
+}
@@ -0,0 +1,292 @@
+package ledger
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pcarleton/cashcoach/api/money"
+	"github.com/pcarleton/cashcoach/api/plaid"
+)
+
+// TransferWindow is how far apart the two sides of a transfer can be
+// dated and still be paired.  Banks often post the receiving side a day or
+// two after the sending side.
+const TransferWindow = 4 * 24 * time.Hour
+
+// liabilityTypes are Plaid account types and subtypes that are debts.
+var liabilityTypes = map[string]bool{
+	"credit":         true,
+	"credit card":    true,
+	"line of credit": true,
+	"loan":           true,
+	"mortgage":       true,
+	"student":        true,
+	"auto":           true,
+}
+
+// AccountRoot maps a Plaid account type and subtype to liabilities for
+// credit cards and loans, and assets for everything else, like
+// depository and investment accounts.
+func AccountRoot(accountType, subtype string) AccountName {
+	if liabilityTypes[strings.ToLower(accountType)] || liabilityTypes[strings.ToLower(subtype)] {
+		return Liability()
+	}
+	return Asset()
+}
+
+// PlaidAccount names a Plaid account under its AccountRoot.
+func PlaidAccount(acct plaid.Account, name string) AccountName {
+	return append(AccountRoot(acct.Type, acct.Subtype), name)
+}
+
+// incomeCategories are the top-level categories of money coming in that
+// is income rather than a refund.
+var incomeCategories = map[string]bool{
+	"income":   true,
+	"interest": true,
+	"payroll":  true,
+	"transfer": true,
+}
+
+// uncategorized is the category of entries without one.  Money coming in
+// uncategorized is counted as income.
+var uncategorized = map[string]bool{"uncategorized": true}
+
+// transferCategories are the top-level categories either side of a
+// transfer between our own accounts has.
+var transferCategories = map[string]bool{
+	"payment":  true,
+	"transfer": true,
+}
+
+// PlaidID is the Meta key for the Plaid transaction ID a transaction was
+// booked from.  PlaidTransferID is the receiving side's, for transfers.
+// FITID and FITIDTransfer are the same for the IDs in OFX statements.
+const (
+	PlaidID         = "plaid_id"
+	PlaidTransferID = "plaid_transfer_id"
+	FITID           = "fitid"
+	FITIDTransfer   = "transfer_fitid"
+)
+
+// transferIDKeys maps the Meta key for a sending side's ID to the one for
+// the receiving side's.
+var transferIDKeys = map[string]string{
+	PlaidID: PlaidTransferID,
+	FITID:   FITIDTransfer,
+}
+
+// Entry is one side of money moving in or out of one of our accounts, as
+// the bank reports it.
+type Entry struct {
+	// ID is the bank's ID for the entry, like Plaid's transaction ID, if
+	// known.
+	ID string
+	// IDKey is the Meta key ID is kept under, PlaidID if empty.
+	IDKey       string
+	Account     AccountName
+	Date        time.Time
+	Description string
+	// Category is a path like Food and Drink:Restaurants, most general
+	// first.
+	Category []string
+	// Amount is positive for money leaving Account, like Plaid's.
+	Amount money.Amount
+	Tags   []string
+	// Split divides the entry between people, if it's shared.  It's
+	// ignored for transfers.
+	Split *Split
+}
+
+// PlaidEntry adapts a Plaid transaction in account.
+func PlaidEntry(t plaid.Transaction, account AccountName) (Entry, error) {
+	date, err := time.Parse(plaid.DateFmt, t.Date)
+	if err != nil {
+		return Entry{}, fmt.Errorf("transaction %s has invalid date %q", t.ID, t.Date)
+	}
+
+	return Entry{
+		ID:          t.ID,
+		Account:     account,
+		Date:        date,
+		Description: t.Name,
+		Category:    t.Category,
+		Amount:      t.Amount,
+	}, nil
+}
+
+func topCategory(category []string, set map[string]bool) bool {
+	return len(category) > 0 && set[strings.ToLower(strings.TrimSpace(category[0]))]
+}
+
+// Counterpart is the account on the other side of an entry: income named
+// after the category for money coming in as pay, interest or deposits,
+// and otherwise an expense named after the category, so refunds reduce
+// what was spent.
+func Counterpart(category []string, amount money.Amount) AccountName {
+	if len(category) == 0 {
+		category = []string{"uncategorized"}
+	}
+
+	if amount < 0 && (topCategory(category, uncategorized) || topCategory(category, incomeCategories)) {
+		return Income(category...)
+	}
+	return Expense(category...)
+}
+
+// Transaction books e on its own, against its Counterpart, divided up by
+// its Split if it has one.
+func (e Entry) Transaction() Transaction {
+	counterpart := Counterpart(e.Category, e.Amount)
+
+	changes := []Change{{Account: counterpart, Amount: e.Amount}}
+	if e.Split != nil {
+		changes = e.Split.Postings(counterpart, "")
+	}
+
+	t := Transaction{
+		Date:        e.Date,
+		Description: e.Description,
+		Tags:        e.Tags,
+		Changes:     append(changes, Change{Account: e.Account, Elided: true}),
+	}
+
+	if e.ID != "" {
+		t.Meta = map[string]string{e.idKey(): e.ID}
+	}
+	return t
+}
+
+func (e Entry) idKey() string {
+	if e.IDKey == "" {
+		return PlaidID
+	}
+	return e.IDKey
+}
+
+// transferIDKey is the Meta key for e's ID when e is the receiving side of
+// a transfer.  Keys without one in transferIDKeys get a transfer_ prefix,
+// like FITIDTransfer.
+func (e Entry) transferIDKey() string {
+	if key, ok := transferIDKeys[e.idKey()]; ok {
+		return key
+	}
+	return "transfer_" + e.idKey()
+}
+
+// FindTransfers pairs up the two sides of transfers between our own
+// accounts: entries in different accounts for opposite amounts, dated
+// within window of each other, at least one of them categorized as a
+// transfer or payment.  Each pair is the index of the side money left,
+// then the side it arrived in.  Each sending side takes the closest
+// receiving side, earliest first on ties, so pairing is deterministic.
+func FindTransfers(entries []Entry, window time.Duration) [][2]int {
+	order := make([]int, len(entries))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return entries[order[i]].Date.Before(entries[order[j]].Date)
+	})
+
+	used := make(map[int]bool)
+	var pairs [][2]int
+
+	for _, out := range order {
+		o := entries[out]
+		if o.Amount <= 0 {
+			continue
+		}
+
+		best := -1
+		var bestGap time.Duration
+
+		for _, in := range order {
+			n := entries[in]
+			if used[in] || n.Amount != -o.Amount || n.Account.String() == o.Account.String() {
+				continue
+			}
+			if !topCategory(o.Category, transferCategories) && !topCategory(n.Category, transferCategories) {
+				continue
+			}
+
+			gap := n.Date.Sub(o.Date)
+			if gap < 0 {
+				gap = -gap
+			}
+			if gap > window {
+				continue
+			}
+
+			if best < 0 || gap < bestGap {
+				best, bestGap = in, gap
+			}
+		}
+
+		if best >= 0 {
+			used[out], used[best] = true, true
+			pairs = append(pairs, [2]int{out, best})
+		}
+	}
+
+	return pairs
+}
+
+// TransferTransaction books both sides of a transfer as a single
+// transaction between the two accounts, dated when the money left.
+func TransferTransaction(out, in Entry) Transaction {
+	t := Transaction{
+		Date:        out.Date,
+		Description: out.Description,
+		Comments: []string{
+			fmt.Sprintf("transfer, received %s as %q", in.Date.Format(DateFmt), in.Description),
+		},
+		Changes: []Change{
+			{Account: in.Account, Amount: out.Amount},
+			{Account: out.Account, Amount: -out.Amount},
+		},
+	}
+
+	if out.ID != "" || in.ID != "" {
+		t.Meta = make(map[string]string)
+	}
+	if out.ID != "" {
+		t.Meta[out.idKey()] = out.ID
+	}
+	if in.ID != "" {
+		t.Meta[in.transferIDKey()] = in.ID
+	}
+	return t
+}
+
+// Book turns entries into transactions, pairing transfers with
+// FindTransfers and booking the rest against their Counterparts.  The
+// transactions are in date order.
+func Book(entries []Entry, window time.Duration) []Transaction {
+	// Transfers go where their sending side was, and their receiving
+	// side is dropped.
+	transfers := make(map[int]int)
+	received := make(map[int]bool)
+	for _, pair := range FindTransfers(entries, window) {
+		transfers[pair[0]] = pair[1]
+		received[pair[1]] = true
+	}
+
+	booked := make([]Transaction, 0, len(entries)-len(transfers))
+	for i, e := range entries {
+		switch in, ok := transfers[i]; {
+		case ok:
+			booked = append(booked, TransferTransaction(e, entries[in]))
+		case !received[i]:
+			booked = append(booked, e.Transaction())
+		}
+	}
+
+	sort.SliceStable(booked, func(i, j int) bool {
+		return booked[i].Date.Before(booked[j].Date)
+	})
+
+	return booked
+}
@@ -0,0 +1,131 @@
+package ledger_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pcarleton/cashcoach/api/money"
+	"github.com/pcarleton/cashcoach/cash/lib/ledger"
+)
+
+func TestCounterpart(t *testing.T) {
+	cases := []struct {
+		category []string
+		amount   money.Amount
+		want     string
+	}{
+		{nil, 500, "expenses:uncategorized"},
+		{nil, -500, "income:uncategorized"},
+		{[]string{"Uncategorized"}, -500, "income:Uncategorized"},
+		{[]string{" Payroll ", "Acme"}, -500, "income: Payroll :Acme"},
+		{[]string{"Transfer"}, -500, "income:Transfer"},
+		// Refunds come off what was spent.
+		{[]string{"Food and Drink", "Restaurants"}, -500, "expenses:Food and Drink:Restaurants"},
+		{[]string{"Food and Drink", "Restaurants"}, 500, "expenses:Food and Drink:Restaurants"},
+	}
+
+	for _, c := range cases {
+		if got := ledger.Counterpart(c.category, c.amount).String(); got != c.want {
+			t.Errorf("Counterpart(%q, %s) = %s, want %s", c.category, c.amount, got, c.want)
+		}
+	}
+}
+
+func day(d int) time.Time {
+	return time.Date(2018, 3, d, 0, 0, 0, 0, time.UTC)
+}
+
+func TestFindTransfers(t *testing.T) {
+	checking, visa, savings := ledger.Asset("checking"), ledger.Liability("visa"), ledger.Asset("savings")
+
+	entries := []ledger.Entry{
+		{Account: checking, Date: day(5), Category: []string{"Transfer"}, Amount: 50000},
+		{Account: visa, Date: day(7), Category: []string{"Payment"}, Amount: -50000},
+		// Closer to the first, so it's paired with it.
+		{Account: visa, Date: day(6), Category: []string{"payment"}, Amount: -50000},
+		// Too far apart.
+		{Account: savings, Date: day(1), Category: []string{"transfer"}, Amount: 10000},
+		{Account: checking, Date: day(10), Category: []string{"transfer"}, Amount: -10000},
+		// Neither side is a transfer.
+		{Account: checking, Date: day(2), Category: []string{"food"}, Amount: 500},
+		{Account: visa, Date: day(2), Category: []string{"food"}, Amount: -500},
+		// The same account.
+		{Account: checking, Date: day(3), Category: []string{"transfer"}, Amount: 2000},
+		{Account: checking, Date: day(3), Category: []string{"transfer"}, Amount: -2000},
+		// Takes what's left.  Only one side needs the category.
+		{Account: savings, Date: day(8), Category: []string{"Savings"}, Amount: 50000},
+	}
+
+	got := ledger.FindTransfers(entries, ledger.TransferWindow)
+	want := [][2]int{{0, 2}, {9, 1}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestFindTransfersTies(t *testing.T) {
+	entries := []ledger.Entry{
+		{Account: ledger.Asset("checking"), Date: day(5), Category: []string{"transfer"}, Amount: 100},
+		{Account: ledger.Asset("savings"), Date: day(6), Amount: -100},
+		{Account: ledger.Asset("brokerage"), Date: day(4), Amount: -100},
+	}
+
+	got := ledger.FindTransfers(entries, ledger.TransferWindow)
+	want := [][2]int{{0, 2}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestBook(t *testing.T) {
+	entries := []ledger.Entry{
+		{ID: "c1", IDKey: ledger.FITID, Account: ledger.Asset("checking"), Date: day(5),
+			Description: "Payment to Visa", Category: []string{"Transfer"}, Amount: 50000},
+		// A key without a transfer key of its own.
+		{ID: "v1", IDKey: "csv_id", Account: ledger.Liability("visa"), Date: day(6),
+			Description: "Payment thank you", Category: []string{"Payment"}, Amount: -50000},
+		{ID: "p1", Account: ledger.Asset("checking"), Date: day(1),
+			Description: "Paycheck", Category: []string{"Payroll"}, Amount: -200000},
+		{Account: ledger.Liability("visa"), Date: day(2), Description: "Groceries",
+			Category: []string{"Food", "Groceries"}, Amount: 3000, Tags: []string{"costco"},
+			Split: &ledger.Split{PaidBy: "paul", Shares: []ledger.Share{{"paul", 2000}, {"taylor", 1000}}}},
+		{Account: ledger.Liability("visa"), Date: day(3), Description: "Mystery refund",
+			Category: []string{"Uncategorized"}, Amount: -700},
+	}
+
+	booked := ledger.Book(entries, ledger.TransferWindow)
+
+	var got []string
+	for _, txn := range booked {
+		if err := txn.Validate(); err != nil {
+			t.Error(err)
+		}
+		got = append(got, txn.String())
+	}
+
+	want := []string{
+		`2018/03/01 Paycheck
+    ; plaid_id: p1
+    income:Payroll    -2000.00
+    assets:checking`,
+		`2018/03/02 Groceries
+    ; :costco:
+    expenses:Food:Groceries    20.00
+    assets:receivable:paul:taylor    10.00
+    liabilities:visa`,
+		`2018/03/03 Mystery refund
+    income:Uncategorized    -7.00
+    liabilities:visa`,
+		`2018/03/05 Payment to Visa
+    ; transfer, received 2018/03/06 as "Payment thank you"
+    ; fitid: c1
+    ; transfer_csv_id: v1
+    liabilities:visa    500.00
+    assets:checking    -500.00`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got\n%s\n\nwant\n%s", strings.Join(got, "\n\n"), strings.Join(want, "\n\n"))
+	}
+}
@@ -3,9 +3,12 @@ package ledger
 import (
   "time"
   "fmt"
+  "sort"
 
   "strings"
+  "unicode"
 
+  "github.com/pcarleton/cashcoach/api/money"
 )
 
 const (
@@ -31,33 +34,161 @@ func Expense(pieces ...string) AccountName{
   return append([]string{"expenses"}, pieces...)
 }
 
+func Income(pieces ...string) AccountName{
+  return append([]string{"income"}, pieces...)
+}
+
+func Equity(pieces ...string) AccountName{
+  return append([]string{"equity"}, pieces...)
+}
+
+// Change is one posting.  An Elided posting is written without an amount,
+// leaving ledger to balance the transaction with it; its Amount is
+// ignored.  Any other posting is written with its Amount, even if that's
+// 0.00.
 type Change struct {
   Account AccountName
-  Amount float64
+  Amount money.Amount
+  Elided bool
+
+  // Commodity is the amount's currency, like "$" or "USD".  Empty means
+  // plain numbers.
+  Commodity string
+
+  // Comment is the posting's trailing comment, without the ";".
+  Comment string
+}
+
+// formatAmount writes symbols like "$" before the number and codes like
+// "USD" after it, the way ledger prints them.
+func formatAmount(amount money.Amount, commodity string) string {
+  number := amount.String()
+
+  switch {
+  case commodity == "":
+    return number
+  case isSymbol(commodity):
+    if amount < 0 {
+      return "-" + commodity + number[1:]
+    }
+    return commodity + number
+  default:
+    return number + " " + commodity
+  }
+}
+
+func isSymbol(commodity string) bool {
+  for _, r := range commodity {
+    if unicode.IsLetter(r) {
+      return false
+    }
+  }
+  return true
 }
 
 func (c *Change) String() string {
-  if c.Amount == 0 {
-    return c.Account.String()
+  line := c.Account.String()
+  if !c.Elided {
+    line = fmt.Sprintf("%s    %s", line, formatAmount(c.Amount, c.Commodity))
+  }
+  if c.Comment != "" {
+    line = fmt.Sprintf("%s  ; %s", line, c.Comment)
+  }
+  return line
+}
+
+// State is a transaction's cleared flag.
+type State int
+
+const (
+  Uncleared State = iota
+  Pending
+  Cleared
+)
+
+// marker is how the state is written after the date.
+func (s State) marker() string {
+  switch s {
+  case Pending:
+    return "!"
+  case Cleared:
+    return "*"
   }
-  return fmt.Sprintf("%s    %.2f", c.Account.String(), c.Amount)
+  return ""
 }
 
 type Transaction struct {
   Date time.Time
+  State State
+  // Code is the optional "(code)" before the description, like a check
+  // number.
+  Code string
   Description string
+  // Comments are the transaction's own comment lines, without the ";".
+  Comments []string
+  // Tags are written as a ledger tag comment, like "; :food:shared:".
+  Tags []string
+  // Meta are written as ledger metadata comments, like "; plaid_id: abc".
+  // Keys are words, like plaid_id.
+  Meta map[string]string
   Changes []Change
+
+  // Pos is where the transaction was read from, if it was parsed.
+  Pos Position
+}
+
+// Tag makes s usable as a ledger tag, which can't hold spaces or colons.
+func Tag(s string) string {
+  s = strings.TrimSpace(s)
+  return strings.Map(func(r rune) rune {
+    if r == ':' || unicode.IsSpace(r) {
+      return '-'
+    }
+    return r
+  }, s)
 }
 
 func (t *Transaction) String() string {
-  lines := make([]string, 1 + len(t.Changes))
+  lines := make([]string, 0, 2 + len(t.Changes))
 
-  lines[0] = fmt.Sprintf("%s %s", t.Date.Format(DateFmt),
-                           t.Description)
+  header := t.Date.Format(DateFmt)
+  if marker := t.State.marker(); marker != "" {
+    header += " " + marker
+  }
+  if t.Code != "" {
+    header += " (" + t.Code + ")"
+  }
+  lines = append(lines, header + " " + t.Description)
 
-  for i, c := range t.Changes {
-    lines[i+1] = "    " + c.String()
+  for _, comment := range t.Comments {
+    lines = append(lines, "    ; " + comment)
+  }
+
+  for _, key := range t.metaKeys() {
+    lines = append(lines, fmt.Sprintf("    ; %s: %s", key, t.Meta[key]))
+  }
+
+  if len(t.Tags) > 0 {
+    tags := make([]string, len(t.Tags))
+    for i, tag := range t.Tags {
+      tags[i] = Tag(tag)
+    }
+    lines = append(lines, fmt.Sprintf("    ; :%s:", strings.Join(tags, ":")))
+  }
+
+  for _, c := range t.Changes {
+    lines = append(lines, "    " + c.String())
   }
 
   return strings.Join(lines, "\n")
 }
+
+// metaKeys returns the Meta keys in order, so output is stable.
+func (t *Transaction) metaKeys() []string {
+  keys := make([]string, 0, len(t.Meta))
+  for key := range t.Meta {
+    keys = append(keys, key)
+  }
+  sort.Strings(keys)
+  return keys
+}
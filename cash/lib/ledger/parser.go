@@ -0,0 +1,567 @@
+package ledger
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pcarleton/cashcoach/api/money"
+)
+
+// Position is a line in a journal.
+type Position struct {
+	File string
+	Line int
+}
+
+func (p Position) String() string {
+	if p.File == "" {
+		return fmt.Sprintf("line %d", p.Line)
+	}
+	return fmt.Sprintf("%s:%d", p.File, p.Line)
+}
+
+// ParseError is a problem at a particular line of a journal.
+type ParseError struct {
+	Pos Position
+	Msg string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Pos, e.Msg)
+}
+
+// Journal is what Parse reads from a ledger-cli style file.
+type Journal struct {
+	// Accounts are the names declared with account directives, in order.
+	Accounts     []AccountName
+	Transactions []Transaction
+}
+
+// Parse reads a journal.  name is only used in error positions.
+//
+// It understands transactions with cleared and pending markers, codes,
+// elided amounts and comments, account directives, and comment blocks.
+// Other directives, like commodity and payee, are skipped along with their
+// indented lines.  Include directives need a file to be relative to, so
+// they only work through ParseFile.  Features that would change what the
+// postings add up to, like prices, balance assertions, virtual postings
+// and automated transactions, are reported as errors rather than being
+// silently misread.
+func Parse(r io.Reader, name string) (*Journal, error) {
+	p := &parser{journal: &Journal{}, file: name}
+	if err := p.parse(r); err != nil {
+		return nil, err
+	}
+	return p.journal, nil
+}
+
+// ParseFile reads the journal at path, following include directives.
+func ParseFile(path string) (*Journal, error) {
+	p := &parser{journal: &Journal{}, visited: make(map[string]bool)}
+	if err := p.parseFile(path, Position{}); err != nil {
+		return nil, err
+	}
+	return p.journal, nil
+}
+
+type parser struct {
+	journal *Journal
+	file    string
+
+	// visited guards against include cycles.  Nil when not reading files.
+	visited map[string]bool
+
+	line int
+
+	// cur is the transaction being read, if any.
+	cur *Transaction
+
+	// skipping is set after a directive whose indented lines are ignored.
+	skipping bool
+
+	// inComment is set inside a comment block.
+	inComment bool
+}
+
+func (p *parser) pos() Position {
+	return Position{p.file, p.line}
+}
+
+func (p *parser) errorf(format string, v ...interface{}) error {
+	return &ParseError{p.pos(), fmt.Sprintf(format, v...)}
+}
+
+func (p *parser) parseFile(path string, from Position) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	if p.visited[abs] {
+		return &ParseError{from, fmt.Sprintf("%s is included more than once", path)}
+	}
+	p.visited[abs] = true
+
+	f, err := os.Open(path)
+	if err != nil {
+		if from.Line > 0 {
+			return &ParseError{from, err.Error()}
+		}
+		return err
+	}
+	defer f.Close()
+
+	// Each file gets its own position and state; the journal is shared.
+	sub := &parser{journal: p.journal, file: path, visited: p.visited}
+	return sub.parse(f)
+}
+
+func (p *parser) parse(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+
+	for scanner.Scan() {
+		p.line++
+		if err := p.parseLine(strings.TrimRight(scanner.Text(), "\r")); err != nil {
+			return err
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	if p.inComment {
+		return p.errorf("comment block is never ended")
+	}
+
+	return p.finish()
+}
+
+// finish adds the transaction being read to the journal.
+func (p *parser) finish() error {
+	t := p.cur
+	p.cur = nil
+
+	if t == nil {
+		return nil
+	}
+
+	if len(t.Changes) == 0 {
+		return &ParseError{t.Pos, "transaction has no postings"}
+	}
+
+	p.journal.Transactions = append(p.journal.Transactions, *t)
+	return nil
+}
+
+func (p *parser) parseLine(line string) error {
+	trimmed := strings.TrimSpace(line)
+
+	if p.inComment {
+		if trimmed == "end comment" || trimmed == "end test" {
+			p.inComment = false
+		}
+		return nil
+	}
+
+	if trimmed == "" {
+		p.skipping = false
+		return p.finish()
+	}
+
+	if line[0] == ' ' || line[0] == '\t' {
+		switch {
+		case p.cur != nil:
+			return p.parsePosting(trimmed)
+		case p.skipping:
+			return nil
+		case trimmed[0] == ';':
+			return nil
+		default:
+			return p.errorf("indented line outside a transaction")
+		}
+	}
+
+	if err := p.finish(); err != nil {
+		return err
+	}
+	p.skipping = false
+
+	switch {
+	case strings.ContainsRune(";#%|*", rune(line[0])):
+		return nil
+	case line[0] >= '0' && line[0] <= '9':
+		return p.parseHeader(line)
+	}
+
+	return p.parseDirective(trimmed)
+}
+
+// skippedDirectives don't affect what's read, so they and any indented
+// lines under them are ignored.
+var skippedDirectives = map[string]bool{
+	"alias": true, "apply": true, "assert": true, "bucket": true, "check": true,
+	"commodity": true, "D": true, "define": true, "end": true, "expr": true,
+	"N": true, "P": true, "payee": true, "tag": true, "value": true,
+	"year": true, "Y": true,
+}
+
+func (p *parser) parseDirective(line string) error {
+	word, rest := splitWord(line)
+
+	switch {
+	case word == "account":
+		name, _ := splitComment(rest)
+		if name == "" {
+			return p.errorf("account directive needs a name")
+		}
+		p.journal.Accounts = append(p.journal.Accounts, parseAccountName(name))
+		p.skipping = true
+		return nil
+
+	case word == "comment" || word == "test":
+		p.inComment = true
+		return nil
+
+	case word == "include":
+		if p.visited == nil {
+			return p.errorf("include is only supported when reading a file")
+		}
+		path, _ := splitComment(rest)
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(filepath.Dir(p.file), path)
+		}
+		return p.parseFile(path, p.pos())
+
+	case word == "=" || word == "~" || strings.HasPrefix(word, "=") || strings.HasPrefix(word, "~"):
+		return p.errorf("automated and periodic transactions are not supported")
+
+	case skippedDirectives[word]:
+		p.skipping = true
+		return nil
+	}
+
+	return p.errorf("unknown directive %q", word)
+}
+
+var dateLayouts = []string{"2006/1/2", "2006-1-2", "2006.1.2"}
+
+func parseDate(s string) (time.Time, error) {
+	// Only the primary date matters; drop any auxiliary "=date".
+	if i := strings.IndexByte(s, '='); i >= 0 {
+		s = s[:i]
+	}
+
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q", s)
+}
+
+// parseHeader reads "DATE [*|!] [(CODE)] DESCRIPTION [; NOTE]".
+func (p *parser) parseHeader(line string) error {
+	dateStr, rest := splitWord(line)
+
+	date, err := parseDate(dateStr)
+	if err != nil {
+		return p.errorf("%v", err)
+	}
+
+	t := &Transaction{Date: date, Pos: p.pos()}
+
+	rest, note := splitComment(rest)
+
+	switch {
+	case strings.HasPrefix(rest, "*"):
+		t.State = Cleared
+		rest = strings.TrimSpace(rest[1:])
+	case strings.HasPrefix(rest, "!"):
+		t.State = Pending
+		rest = strings.TrimSpace(rest[1:])
+	}
+
+	if strings.HasPrefix(rest, "(") {
+		end := strings.IndexByte(rest, ')')
+		if end < 0 {
+			return p.errorf("unclosed ( in transaction code")
+		}
+		t.Code = rest[1:end]
+		rest = strings.TrimSpace(rest[end+1:])
+	}
+
+	t.Description = rest
+	if note != "" {
+		p.addComment(t, note)
+	}
+
+	p.cur = t
+	return nil
+}
+
+// metaPattern matches a "key: value" metadata comment.
+var metaPattern = regexp.MustCompile(`^([A-Za-z][A-Za-z0-9_-]*):\s+(.*)$`)
+
+// addComment files a transaction-level comment as tags, metadata or a
+// comment.
+func (p *parser) addComment(t *Transaction, comment string) {
+	if tags, ok := parseTags(comment); ok {
+		t.Tags = append(t.Tags, tags...)
+		return
+	}
+
+	if m := metaPattern.FindStringSubmatch(comment); m != nil {
+		if t.Meta == nil {
+			t.Meta = make(map[string]string)
+		}
+		t.Meta[m[1]] = m[2]
+		return
+	}
+
+	t.Comments = append(t.Comments, comment)
+}
+
+// parseTags reads a ":tag1:tag2:" comment.
+func parseTags(comment string) ([]string, bool) {
+	if len(comment) < 3 || !strings.HasPrefix(comment, ":") || !strings.HasSuffix(comment, ":") ||
+		strings.ContainsAny(comment, " \t") {
+		return nil, false
+	}
+
+	var tags []string
+	for _, tag := range strings.Split(comment[1:len(comment)-1], ":") {
+		if tag == "" {
+			return nil, false
+		}
+		tags = append(tags, tag)
+	}
+	return tags, true
+}
+
+func (p *parser) parsePosting(line string) error {
+	t := p.cur
+
+	if line[0] == ';' {
+		comment := strings.TrimSpace(line[1:])
+
+		if len(t.Changes) == 0 {
+			p.addComment(t, comment)
+			return nil
+		}
+
+		last := &t.Changes[len(t.Changes)-1]
+		if last.Comment == "" {
+			last.Comment = comment
+		} else {
+			last.Comment += " " + comment
+		}
+		return nil
+	}
+
+	// Per-posting cleared markers aren't kept.
+	if line[0] == '*' || line[0] == '!' {
+		line = strings.TrimSpace(line[1:])
+	}
+
+	if line == "" || line[0] == ';' {
+		return p.errorf("posting has no account")
+	}
+
+	if line[0] == '(' || line[0] == '[' {
+		return p.errorf("virtual postings are not supported")
+	}
+
+	// The account ends at a tab or two spaces.  After that, any ";"
+	// starts a comment.
+	account, rest := line, ""
+	if i := accountEnd(line); i >= 0 {
+		account, rest = line[:i], line[i:]
+	}
+	account = strings.TrimSpace(account)
+
+	amountStr, comment := rest, ""
+	if i := strings.IndexByte(rest, ';'); i >= 0 {
+		amountStr, comment = rest[:i], strings.TrimSpace(rest[i+1:])
+	}
+	amountStr = strings.TrimSpace(amountStr)
+
+	change := Change{Account: parseAccountName(account), Comment: comment, Elided: amountStr == ""}
+
+	if amountStr != "" {
+		amount, commodity, err := parseAmount(amountStr)
+		if err != nil {
+			return p.errorf("%v", err)
+		}
+		change.Amount = amount
+		change.Commodity = commodity
+	}
+
+	t.Changes = append(t.Changes, change)
+	return nil
+}
+
+var amountPattern = regexp.MustCompile(
+	`^(-?)\s*([^-\d\s.,]+)?\s*(-?)\s*(\d[\d,]*(?:\.\d+)?|\.\d+)\s*([^-\d\s.,]+)?$`)
+
+// parseAmount reads amounts like "12.50", "-$1,234.56", "$-5" and
+// "10 USD".
+func parseAmount(s string) (money.Amount, string, error) {
+	if strings.ContainsAny(s, "@") {
+		return 0, "", fmt.Errorf("prices in amount %q are not supported", s)
+	}
+	if strings.ContainsAny(s, "=") {
+		return 0, "", fmt.Errorf("balance assertions in amount %q are not supported", s)
+	}
+
+	m := amountPattern.FindStringSubmatch(s)
+	if m == nil {
+		return 0, "", fmt.Errorf("invalid amount %q", s)
+	}
+
+	leadingMinus, prefix, innerMinus, number, suffix := m[1], m[2], m[3], m[4], m[5]
+
+	if prefix != "" && suffix != "" {
+		return 0, "", fmt.Errorf("amount %q has two commodities", s)
+	}
+	if leadingMinus != "" && innerMinus != "" {
+		return 0, "", fmt.Errorf("invalid amount %q", s)
+	}
+
+	amount, err := money.Parse(number)
+	if err != nil {
+		return 0, "", err
+	}
+
+	if leadingMinus != "" || innerMinus != "" {
+		amount = -amount
+	}
+
+	return amount, prefix + suffix, nil
+}
+
+func parseAccountName(name string) AccountName {
+	return AccountName(strings.Split(name, ":"))
+}
+
+// splitWord splits off the first space-separated word.
+func splitWord(s string) (string, string) {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexAny(s, " \t"); i >= 0 {
+		return s[:i], strings.TrimSpace(s[i+1:])
+	}
+	return s, ""
+}
+
+// accountEnd returns where the first tab or two spaces in s are, or -1.
+func accountEnd(s string) int {
+	tab, spaces := strings.IndexByte(s, '\t'), strings.Index(s, "  ")
+	if tab < 0 || (spaces >= 0 && spaces < tab) {
+		return spaces
+	}
+	return tab
+}
+
+// commentStart returns where the comment in s starts, or -1.  As in
+// ledger, a ";" only starts one at the beginning of s or after a tab or
+// two spaces, so descriptions like "Foo; Bar" are read whole.
+func commentStart(s string) int {
+	for i := 0; i < len(s); i++ {
+		if s[i] != ';' {
+			continue
+		}
+		if i == 0 || s[i-1] == '\t' || strings.HasSuffix(s[:i], "  ") {
+			return i
+		}
+	}
+	return -1
+}
+
+// splitComment splits a trailing "  ; comment" off s.
+func splitComment(s string) (string, string) {
+	if i := commentStart(s); i >= 0 {
+		return strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+1:])
+	}
+	return strings.TrimSpace(s), ""
+}
+
+// Key identifies a transaction for spotting duplicates: its date,
+// description and postings, in any order.  Cleared state, codes and
+// comments are ignored, and a single elided amount counts as the amount
+// that balances the others, so writing it out doesn't hide a duplicate.
+func (t *Transaction) Key() string {
+	postings := make([]string, 0, len(t.Changes))
+	for _, c := range t.inferred() {
+		postings = append(postings, fmt.Sprintf("%s %s", c.Account, formatAmount(c.Amount, c.Commodity)))
+	}
+	sort.Strings(postings)
+
+	return fmt.Sprintf("%s|%s|%s", t.Date.Format(DateFmt), t.Description, strings.Join(postings, "|"))
+}
+
+// IDs returns keys for the bank IDs in t's Meta, so a transaction can be
+// recognized by them even if its description or postings have been edited
+// since.  Either side of a transfer matches a transaction with the same
+// ID.  Plaid IDs are unique on their own, but FITIDs are only unique
+// within an account, so they're keyed by the statement's account.
+func (t *Transaction) IDs() []string {
+	var ids []string
+
+	for _, key := range t.metaKeys() {
+		switch key {
+		case PlaidID, PlaidTransferID:
+			ids = append(ids, PlaidID+"="+t.Meta[key])
+		case FITID, FITIDTransfer:
+			for _, account := range t.statementAccounts(key == FITIDTransfer) {
+				ids = append(ids, FITID+"="+account.String()+"="+t.Meta[key])
+			}
+		}
+	}
+
+	return ids
+}
+
+// statementAccounts guesses which of t's accounts a statement came from:
+// its asset and liability postings, other than receivables.  When there
+// are several, as in a transfer, the sending side is the one money left
+// and the receiving side the one it arrived in.
+func (t *Transaction) statementAccounts(receiving bool) []AccountName {
+	var all, side []AccountName
+	for _, c := range t.inferred() {
+		if len(c.Account) == 0 || c.Account.HasPrefix(Asset("receivable")) {
+			continue
+		}
+		if root := strings.ToLower(c.Account[0]); root != "assets" && root != "liabilities" {
+			continue
+		}
+
+		all = append(all, c.Account)
+		if (c.Amount > 0) == receiving {
+			side = append(side, c.Account)
+		}
+	}
+
+	if len(all) > 1 && len(side) > 0 {
+		return side
+	}
+	return all
+}
+
+// Keys returns the Key and IDs of every transaction in the journal.
+func (j *Journal) Keys() map[string]bool {
+	keys := make(map[string]bool, len(j.Transactions))
+	for i := range j.Transactions {
+		keys[j.Transactions[i].Key()] = true
+		for _, id := range j.Transactions[i].IDs() {
+			keys[id] = true
+		}
+	}
+	return keys
+}
@@ -0,0 +1,194 @@
+package ledger_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pcarleton/cashcoach/cash/lib/ledger"
+)
+
+func parse(t *testing.T, journal string) *ledger.Journal {
+	t.Helper()
+	j, err := ledger.Parse(strings.NewReader(journal), "test.ledger")
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	return j
+}
+
+func TestRoundTrip(t *testing.T) {
+	want := ledger.Transaction{
+		Date:        time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC),
+		State:       ledger.Cleared,
+		Code:        "1042",
+		Description: "Foo; Bar ;baz",
+		Comments:    []string{"split with Sam; paid back"},
+		Meta:        map[string]string{"plaid_id": "abc"},
+		Tags:        []string{"food"},
+		Changes: []ledger.Change{
+			{Account: ledger.Expense("Food"), Amount: 1250, Commodity: "$", Comment: "lunch; mostly"},
+			{Account: ledger.Asset("Checking"), Elided: true},
+		},
+	}
+	if err := want.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+
+	j := parse(t, want.String()+"\n")
+	if len(j.Transactions) != 1 {
+		t.Fatalf("read %d transactions, want 1", len(j.Transactions))
+	}
+
+	got := j.Transactions[0]
+	got.Pos = ledger.Position{}
+	if got.String() != want.String() {
+		t.Errorf("read back\n%s\nwant\n%s", got.String(), want.String())
+	}
+	if got.Description != want.Description {
+		t.Errorf("description %q, want %q", got.Description, want.Description)
+	}
+}
+
+func TestParseComments(t *testing.T) {
+	j := parse(t, `2018/03/01 Foo; Bar  ; note
+    Expenses:Food    $5 ; one space is enough after the amount
+    Assets:Checking	; a tab ends the account
+`)
+
+	txn := j.Transactions[0]
+	if txn.Description != "Foo; Bar" {
+		t.Errorf("description %q, want %q", txn.Description, "Foo; Bar")
+	}
+	if len(txn.Comments) != 1 || txn.Comments[0] != "note" {
+		t.Errorf("comments %q, want [note]", txn.Comments)
+	}
+
+	for i, want := range []string{"one space is enough after the amount", "a tab ends the account"} {
+		if got := txn.Changes[i].Comment; got != want {
+			t.Errorf("posting %d comment %q, want %q", i, got, want)
+		}
+	}
+	if acct := txn.Changes[1].Account.String(); acct != "Assets:Checking" {
+		t.Errorf("account %q, want Assets:Checking", acct)
+	}
+}
+
+func TestParseEmptyPosting(t *testing.T) {
+	for _, posting := range []string{"*", "!", "* ; note", "*\t; note"} {
+		journal := "2018/03/01 Foo\n    Expenses:Food  $5\n    " + posting + "\n"
+
+		_, err := ledger.Parse(strings.NewReader(journal), "test.ledger")
+		if _, ok := err.(*ledger.ParseError); !ok {
+			t.Errorf("%q: got %v, want a ParseError", posting, err)
+		}
+	}
+}
+
+func TestValidateCommentInDescription(t *testing.T) {
+	for _, desc := range []string{"Foo  ; Bar", "Foo\t; Bar", "; Bar"} {
+		txn := ledger.Transaction{
+			Date:        time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC),
+			Description: desc,
+			Changes: []ledger.Change{
+				{Account: ledger.Expense("Food"), Amount: 500, Commodity: "$"},
+				{Account: ledger.Asset("Checking"), Elided: true},
+			},
+		}
+		if err := txn.Validate(); err == nil {
+			t.Errorf("%q: Validate passed", desc)
+		}
+	}
+}
+
+// TestZeroAmount checks a real $0.00 posting isn't mistaken for an elided
+// one.
+func TestZeroAmount(t *testing.T) {
+	txn := ledger.Entry{
+		Date:        time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC),
+		Description: "Card verification",
+		Account:     ledger.Liability("Visa"),
+		Category:    []string{"Service"},
+	}.Transaction()
+
+	if err := txn.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+
+	j := parse(t, txn.String()+"\n")
+	got := j.Transactions[0].Changes
+	if len(got) != 2 || got[0].Elided || got[0].Amount != 0 || !got[1].Elided {
+		t.Fatalf("read back %+v from\n%s", got, txn.String())
+	}
+
+	balanced, err := j.Transactions[0].Balance()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range balanced {
+		if c.Elided || c.Amount != 0 {
+			t.Errorf("balanced to %+v", c)
+		}
+	}
+
+	// Two postings written as 0.00 balance; two without amounts don't.
+	j = parse(t, "2018/03/01 Zero\n    Expenses:Fees    $0.00\n    Assets:Checking    $0.00\n")
+	if err := j.Validate(); err != nil {
+		t.Errorf("explicit zeros: %v", err)
+	}
+
+	j = parse(t, "2018/03/01 Zero\n    Expenses:Fees\n    Assets:Checking\n")
+	if err := j.Validate(); err == nil {
+		t.Error("two elided postings passed")
+	}
+}
+
+func TestIDsSharedFITID(t *testing.T) {
+	// Two statements reuse FITID 1001 for different purchases, booked to
+	// the same expense.
+	checking := ledger.Entry{ID: "1001", IDKey: ledger.FITID, Account: ledger.Asset("checking"),
+		Date: day(2), Description: "Groceries", Category: []string{"Food"}, Amount: 4000}.Transaction()
+	visa := ledger.Entry{ID: "1001", IDKey: ledger.FITID, Account: ledger.Liability("visa"),
+		Date: day(2), Description: "Groceries", Category: []string{"Food"}, Amount: 2500}.Transaction()
+
+	// Written out and read back, as newLTrans compares them.
+	j := parse(t, checking.String()+"\n\n"+visa.String()+"\n")
+
+	for i, want := range []string{"fitid=assets:checking=1001", "fitid=liabilities:visa=1001"} {
+		if got := j.Transactions[i].IDs(); !reflect.DeepEqual(got, []string{want}) {
+			t.Errorf("%s: got IDs %q, want %q", j.Transactions[i].Description, got, want)
+		}
+	}
+}
+
+func TestIDsStatementAccount(t *testing.T) {
+	j := parse(t, `2018/03/02 Groceries
+    ; fitid: 1001
+    expenses:Food    $30.00
+    assets:receivable:paul:taylor    $10.00
+    liabilities:visa
+
+2018/03/05 Payment to Visa
+    ; fitid: 2001
+    ; transfer_fitid: 1002
+    liabilities:visa    $500.00
+    assets:checking    -$500.00
+
+2018/03/06 Payroll
+    ; plaid_id: abc
+    assets:checking    $2000.00
+    income:Payroll
+`)
+
+	want := [][]string{
+		{"fitid=liabilities:visa=1001"},
+		{"fitid=assets:checking=2001", "fitid=liabilities:visa=1002"},
+		{"plaid_id=abc"},
+	}
+	for i, txn := range j.Transactions {
+		if got := txn.IDs(); !reflect.DeepEqual(got, want[i]) {
+			t.Errorf("%s: got IDs %q, want %q", txn.Description, got, want[i])
+		}
+	}
+}
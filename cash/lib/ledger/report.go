@@ -0,0 +1,316 @@
+package ledger
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pcarleton/cashcoach/api/money"
+)
+
+// HasPrefix reports whether a is prefix or an account under it.  Names
+// are compared a piece at a time, ignoring case, so expenses:food doesn't
+// cover expenses:foodtruck.
+func (a AccountName) HasPrefix(prefix AccountName) bool {
+	if len(prefix) > len(a) {
+		return false
+	}
+
+	for i := range prefix {
+		if !strings.EqualFold(a[i], prefix[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Truncate returns a cut down to its first depth pieces.  A depth of 0 or
+// less leaves it whole.
+func (a AccountName) Truncate(depth int) AccountName {
+	if depth <= 0 || depth >= len(a) {
+		return a
+	}
+	return a[:depth]
+}
+
+// Filter picks which postings a report covers.
+type Filter struct {
+	// Start and End bound transaction dates, inclusive.  A zero time
+	// leaves that end open.
+	Start time.Time
+	End   time.Time
+
+	// Accounts limits the report to postings under any of these.  Empty
+	// means every account.
+	Accounts []AccountName
+}
+
+func (f Filter) matchesDate(date time.Time) bool {
+	if !f.Start.IsZero() && date.Before(f.Start) {
+		return false
+	}
+	if !f.End.IsZero() && date.After(f.End) {
+		return false
+	}
+	return true
+}
+
+func (f Filter) matchesAccount(account AccountName) bool {
+	if len(f.Accounts) == 0 {
+		return true
+	}
+
+	for _, prefix := range f.Accounts {
+		if account.HasPrefix(prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Period is how reports group transactions over time.
+type Period int
+
+const (
+	NoPeriod Period = iota
+	Monthly
+	Quarterly
+)
+
+// ParsePeriod reads "monthly" or "quarterly".  An empty string is
+// NoPeriod.
+func ParsePeriod(s string) (Period, error) {
+	switch strings.ToLower(s) {
+	case "", "none":
+		return NoPeriod, nil
+	case "monthly", "month":
+		return Monthly, nil
+	case "quarterly", "quarter":
+		return Quarterly, nil
+	}
+	return NoPeriod, fmt.Errorf("unknown period %q, expected monthly or quarterly", s)
+}
+
+// Start returns the first day of the period date falls in.  With
+// NoPeriod it's the zero time.
+func (p Period) Start(date time.Time) time.Time {
+	switch p {
+	case Monthly:
+		return time.Date(date.Year(), date.Month(), 1, 0, 0, 0, 0, date.Location())
+	case Quarterly:
+		month := (date.Month()-1)/3*3 + 1
+		return time.Date(date.Year(), month, 1, 0, 0, 0, 0, date.Location())
+	}
+	return time.Time{}
+}
+
+// Label names the period date falls in, like "2017-09" or "2017-Q3".
+func (p Period) Label(date time.Time) string {
+	switch p {
+	case Monthly:
+		return date.Format("2006-01")
+	case Quarterly:
+		return fmt.Sprintf("%d-Q%d", date.Year(), (date.Month()-1)/3+1)
+	}
+	return ""
+}
+
+// BalanceRow is an account's total in one commodity.  Totals include
+// every account under it.
+type BalanceRow struct {
+	// Period is the period's label, empty without one.
+	Period    string       `json:"period,omitempty"`
+	Account   string       `json:"account"`
+	Commodity string       `json:"commodity,omitempty"`
+	Amount    money.Amount `json:"amount"`
+}
+
+// AmountString formats the amount with its commodity.
+func (r BalanceRow) AmountString() string {
+	return formatAmount(r.Amount, r.Commodity)
+}
+
+// Balance totals the postings f matches for each account and every
+// account above it, down to depth pieces, like ledger's balance report.
+// With a period, each period is totalled separately.  Accounts that come
+// to zero are left out.  Rows are sorted by period, then account.
+func Balance(transactions []Transaction, f Filter, depth int, period Period) []BalanceRow {
+	type key struct {
+		period    time.Time
+		account   string
+		commodity string
+	}
+
+	totals := make(map[key]money.Amount)
+	labels := make(map[time.Time]string)
+
+	for i := range transactions {
+		t := &transactions[i]
+		if !f.matchesDate(t.Date) {
+			continue
+		}
+
+		start := period.Start(t.Date)
+		labels[start] = period.Label(t.Date)
+
+		for _, c := range t.inferred() {
+			if !f.matchesAccount(c.Account) {
+				continue
+			}
+
+			account := c.Account.Truncate(depth)
+			for n := 1; n <= len(account); n++ {
+				totals[key{start, account[:n].String(), c.Commodity}] += c.Amount
+			}
+		}
+	}
+
+	keys := make([]key, 0, len(totals))
+	for k, total := range totals {
+		if total == 0 {
+			continue
+		}
+		keys = append(keys, k)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if !a.period.Equal(b.period) {
+			return a.period.Before(b.period)
+		}
+		if a.account != b.account {
+			return a.account < b.account
+		}
+		return a.commodity < b.commodity
+	})
+
+	rows := make([]BalanceRow, len(keys))
+	for i, k := range keys {
+		rows[i] = BalanceRow{
+			Period:    labels[k.period],
+			Account:   k.account,
+			Commodity: k.commodity,
+			Amount:    totals[k],
+		}
+	}
+	return rows
+}
+
+// RegisterRow is one line of a register: a posting, or with a period, an
+// account's total for the period.  Total is the running total of the
+// rows so far in the same commodity.
+type RegisterRow struct {
+	Date        time.Time    `json:"date"`
+	Description string       `json:"description"`
+	Account     string       `json:"account"`
+	Commodity   string       `json:"commodity,omitempty"`
+	Amount      money.Amount `json:"amount"`
+	Total       money.Amount `json:"total"`
+}
+
+// AmountString formats the amount with its commodity.
+func (r RegisterRow) AmountString() string {
+	return formatAmount(r.Amount, r.Commodity)
+}
+
+// TotalString formats the running total with its commodity.
+func (r RegisterRow) TotalString() string {
+	return formatAmount(r.Total, r.Commodity)
+}
+
+// Register lists the postings f matches in date order, with accounts cut
+// down to depth pieces, like ledger's register report.  With a period,
+// postings are summed per account within each period instead, dated the
+// first day of the period and described by its label.
+func Register(transactions []Transaction, f Filter, depth int, period Period) []RegisterRow {
+	sorted := make([]*Transaction, 0, len(transactions))
+	for i := range transactions {
+		if f.matchesDate(transactions[i].Date) {
+			sorted = append(sorted, &transactions[i])
+		}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Date.Before(sorted[j].Date)
+	})
+
+	rows := make([]RegisterRow, 0)
+	for _, t := range sorted {
+		for _, c := range t.inferred() {
+			if !f.matchesAccount(c.Account) {
+				continue
+			}
+
+			rows = append(rows, RegisterRow{
+				Date:        t.Date,
+				Description: t.Description,
+				Account:     c.Account.Truncate(depth).String(),
+				Commodity:   c.Commodity,
+				Amount:      c.Amount,
+			})
+		}
+	}
+
+	if period != NoPeriod {
+		rows = groupRegister(rows, period)
+	}
+
+	totals := make(map[string]money.Amount)
+	for i := range rows {
+		totals[rows[i].Commodity] += rows[i].Amount
+		rows[i].Total = totals[rows[i].Commodity]
+	}
+
+	return rows
+}
+
+// groupRegister sums date-ordered rows by period, account and commodity.
+func groupRegister(rows []RegisterRow, period Period) []RegisterRow {
+	grouped := make([]RegisterRow, 0)
+
+	for len(rows) > 0 {
+		start := period.Start(rows[0].Date)
+
+		end := 1
+		for end < len(rows) && period.Start(rows[end].Date).Equal(start) {
+			end++
+		}
+
+		index := make(map[[2]string]int)
+		first := len(grouped)
+
+		for _, r := range rows[:end] {
+			k := [2]string{r.Account, r.Commodity}
+			if i, ok := index[k]; ok {
+				grouped[i].Amount += r.Amount
+				continue
+			}
+
+			index[k] = len(grouped)
+			grouped = append(grouped, RegisterRow{
+				Date:        start,
+				Description: period.Label(start),
+				Account:     r.Account,
+				Commodity:   r.Commodity,
+				Amount:      r.Amount,
+			})
+		}
+
+		group := grouped[first:]
+		sort.SliceStable(group, func(i, j int) bool {
+			return group[i].Account < group[j].Account
+		})
+
+		kept := grouped[:first]
+		for _, r := range group {
+			if r.Amount != 0 {
+				kept = append(kept, r)
+			}
+		}
+		grouped = kept
+
+		rows = rows[end:]
+	}
+
+	return grouped
+}
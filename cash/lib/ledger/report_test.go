@@ -0,0 +1,167 @@
+package ledger_test
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pcarleton/cashcoach/cash/lib/ledger"
+)
+
+// reportJournal is out of date order, has an elided posting in each
+// transaction, a refund that zeroes coffee, and one transaction in euros.
+const reportJournal = `2017/10/02 Pay
+    assets:checking    $1000.00
+    income:salary
+
+2017/09/05 Grocer
+    expenses:food:groceries    $40.00
+    assets:checking
+
+2017/09/20 Cafe
+    expenses:food:coffee    $5.00
+    liabilities:visa
+
+2017/10/15 Grocer
+    expenses:food:groceries    $60.00
+    assets:checking
+
+2017/11/03 Paris
+    expenses:travel    20.00 EUR
+    liabilities:visa
+
+2017/12/31 Coffee refund
+    expenses:food:coffee    -$5.00
+    liabilities:visa
+`
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func balanceLines(rows []ledger.BalanceRow) []string {
+	lines := make([]string, len(rows))
+	for i, r := range rows {
+		lines[i] = fmt.Sprintf("%s %s %s", r.Period, r.Account, r.AmountString())
+	}
+	return lines
+}
+
+func registerLines(rows []ledger.RegisterRow) []string {
+	lines := make([]string, len(rows))
+	for i, r := range rows {
+		lines[i] = fmt.Sprintf("%s %s %s %s %s",
+			r.Date.Format("2006-01-02"), r.Description, r.Account, r.AmountString(), r.TotalString())
+	}
+	return lines
+}
+
+func TestBalance(t *testing.T) {
+	j := parse(t, reportJournal)
+
+	got := balanceLines(ledger.Balance(j.Transactions, ledger.Filter{}, 0, ledger.NoPeriod))
+	want := []string{
+		" assets $900.00",
+		" assets:checking $900.00",
+		" expenses $100.00",
+		" expenses 20.00 EUR",
+		" expenses:food $100.00",
+		" expenses:food:groceries $100.00",
+		" expenses:travel 20.00 EUR",
+		" income -$1000.00",
+		" income:salary -$1000.00",
+		" liabilities -20.00 EUR",
+		" liabilities:visa -20.00 EUR",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestBalanceFiltered(t *testing.T) {
+	j := parse(t, reportJournal)
+
+	// Both ends of the range are included, and account prefixes ignore
+	// case but not partial pieces.
+	f := ledger.Filter{
+		Start:    date(2017, 9, 20),
+		End:      date(2017, 12, 31),
+		Accounts: []ledger.AccountName{{"Expenses", "FOOD"}, ledger.Liability(), {"assets", "check"}},
+	}
+
+	got := balanceLines(ledger.Balance(j.Transactions, f, 2, ledger.Monthly))
+	want := []string{
+		"2017-09 expenses $5.00",
+		"2017-09 expenses:food $5.00",
+		"2017-09 liabilities -$5.00",
+		"2017-09 liabilities:visa -$5.00",
+		"2017-10 expenses $60.00",
+		"2017-10 expenses:food $60.00",
+		"2017-11 liabilities -20.00 EUR",
+		"2017-11 liabilities:visa -20.00 EUR",
+		"2017-12 expenses -$5.00",
+		"2017-12 expenses:food -$5.00",
+		"2017-12 liabilities $5.00",
+		"2017-12 liabilities:visa $5.00",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	j := parse(t, reportJournal)
+
+	f := ledger.Filter{Accounts: []ledger.AccountName{ledger.Expense()}}
+	got := registerLines(ledger.Register(j.Transactions, f, 2, ledger.NoPeriod))
+
+	// Each commodity keeps its own running total.
+	want := []string{
+		"2017-09-05 Grocer expenses:food $40.00 $40.00",
+		"2017-09-20 Cafe expenses:food $5.00 $45.00",
+		"2017-10-15 Grocer expenses:food $60.00 $105.00",
+		"2017-11-03 Paris expenses:travel 20.00 EUR 20.00 EUR",
+		"2017-12-31 Coffee refund expenses:food -$5.00 $100.00",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestRegisterQuarterly(t *testing.T) {
+	j := parse(t, reportJournal)
+
+	f := ledger.Filter{
+		Start:    date(2017, 9, 1),
+		End:      date(2017, 11, 30),
+		Accounts: []ledger.AccountName{ledger.Asset(), ledger.Expense()},
+	}
+	got := registerLines(ledger.Register(j.Transactions, f, 1, ledger.Quarterly))
+	want := []string{
+		"2017-07-01 2017-Q3 assets -$40.00 -$40.00",
+		"2017-07-01 2017-Q3 expenses $45.00 $5.00",
+		"2017-10-01 2017-Q4 assets $940.00 $945.00",
+		"2017-10-01 2017-Q4 expenses $60.00 $1005.00",
+		"2017-10-01 2017-Q4 expenses 20.00 EUR 20.00 EUR",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestRegisterDropsZeroPeriods(t *testing.T) {
+	j := parse(t, `2017/09/05 Lunch
+    expenses:food    $12.00
+    assets:checking
+
+2017/09/06 Lunch refund
+    expenses:food    -$12.00
+    assets:checking
+`)
+
+	rows := ledger.Register(j.Transactions, ledger.Filter{}, 0, ledger.Monthly)
+	if len(rows) != 0 {
+		t.Errorf("got %q, want no rows", registerLines(rows))
+	}
+}
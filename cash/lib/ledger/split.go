@@ -0,0 +1,425 @@
+package ledger
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/pcarleton/cashcoach/api/money"
+)
+
+// Receivable is the account for what ower owes payer for shared expenses.
+func Receivable(payer, ower string) AccountName {
+	return Asset("receivable", payer, ower)
+}
+
+// SplitPolicy says how a shared expense is divided between people.  The
+// weights come from one of Percent, Shares or OwedBy.  Fixed amounts come
+// off the top first, and the rest is divided by the weights, or left to
+// whoever paid if there are none.
+type SplitPolicy struct {
+	// Percent gives each person a percentage.  They must add up to 100.
+	Percent map[string]float64 `mapstructure:"percent"`
+
+	// Shares divides in proportion, so {paul: 2, taylor: 1} gives paul
+	// two thirds.
+	Shares map[string]int64 `mapstructure:"shares"`
+
+	// OwedBy divides evenly between these people.  With PaidBy, it says
+	// "paid by X, owed by Y".
+	OwedBy []string `mapstructure:"owed_by"`
+
+	// Fixed are amounts people owe before the rest is divided.
+	Fixed map[string]float64 `mapstructure:"fixed"`
+
+	// PaidBy is who paid, in place of the person who pays the account.
+	PaidBy string `mapstructure:"paid_by"`
+}
+
+// SplitConfig is read from the splits config key:
+//
+//	splits:
+//	  payers:
+//	    visa: paul
+//	    amex: taylor
+//	  default:
+//	    percent: {paul: 60, taylor: 40}
+//	  categories:
+//	    food:groceries:
+//	      shares: {paul: 1, taylor: 1}
+//	    rent:
+//	      fixed: {taylor: 800}
+//	    gifts:
+//	      paid_by: paul
+//	      owed_by: [taylor]
+//
+// A transaction is split if a rule names people to split it between, or
+// if its category has a policy.  The most specific category policy wins,
+// falling back to the default.  Names are compared ignoring case.
+type SplitConfig struct {
+	// Payers maps account nicknames to the person who pays the account.
+	Payers     map[string]string      `mapstructure:"payers"`
+	Default    SplitPolicy            `mapstructure:"default"`
+	Categories map[string]SplitPolicy `mapstructure:"categories"`
+}
+
+// Splitter applies a SplitConfig.  A nil *Splitter splits nothing.
+type Splitter struct {
+	payers     map[string]string
+	def        policy
+	categories []categoryPolicy
+}
+
+type categoryPolicy struct {
+	category []string
+	policy   policy
+}
+
+type policy struct {
+	// people and weights line up, sorted by person.
+	people  []string
+	weights []int64
+	fixed   []Share
+	paidBy  string
+}
+
+// Share is one person's part of a split.
+type Share struct {
+	Person string
+	Amount money.Amount
+}
+
+// Split is how one transaction is divided.
+type Split struct {
+	PaidBy string
+	// Shares are sorted by person and add up to the transaction's amount.
+	Shares []Share
+}
+
+// NewSplitter checks every policy in config.  Errors name the policy.
+func NewSplitter(config SplitConfig) (*Splitter, error) {
+	s := &Splitter{payers: make(map[string]string)}
+
+	for account, person := range config.Payers {
+		s.payers[strings.ToLower(account)] = strings.ToLower(person)
+	}
+
+	def, err := compilePolicy(config.Default, false)
+	if err != nil {
+		return nil, fmt.Errorf("default split: %v", err)
+	}
+	s.def = def
+
+	for category, p := range config.Categories {
+		compiled, err := compilePolicy(p, true)
+		if err != nil {
+			return nil, fmt.Errorf("split for %s: %v", category, err)
+		}
+		s.categories = append(s.categories, categoryPolicy{splitCategory(category), compiled})
+	}
+
+	// Most specific first, so the first match is the best one.
+	sort.Slice(s.categories, func(i, j int) bool {
+		a, b := s.categories[i].category, s.categories[j].category
+		if len(a) != len(b) {
+			return len(a) > len(b)
+		}
+		return strings.Join(a, ":") < strings.Join(b, ":")
+	})
+
+	return s, nil
+}
+
+func splitCategory(category string) []string {
+	pieces := strings.Split(category, ":")
+	for i := range pieces {
+		pieces[i] = strings.ToLower(strings.TrimSpace(pieces[i]))
+	}
+	return pieces
+}
+
+// compilePolicy checks p.  Only category policies have to say something;
+// an empty default just means rules decide who shares.
+func compilePolicy(p SplitPolicy, required bool) (policy, error) {
+	c := policy{paidBy: strings.ToLower(p.PaidBy)}
+
+	given := 0
+	for _, set := range []bool{len(p.Percent) > 0, len(p.Shares) > 0, len(p.OwedBy) > 0} {
+		if set {
+			given++
+		}
+	}
+	if given > 1 {
+		return c, fmt.Errorf("only one of percent, shares and owed_by can be set")
+	}
+
+	weights := make(map[string]int64)
+
+	if len(p.Percent) > 0 {
+		var total int64
+		for person, percent := range p.Percent {
+			if percent < 0 {
+				return c, fmt.Errorf("%s has a negative percent", person)
+			}
+			// Hundredths of a percent, so 33.33 works.
+			weights[strings.ToLower(person)] = int64(math.Floor(percent*100 + 0.5))
+			total += weights[strings.ToLower(person)]
+		}
+		if total != 100*100 {
+			return c, fmt.Errorf("percents add up to %.2f, not 100", float64(total)/100)
+		}
+	}
+
+	for person, share := range p.Shares {
+		if share < 0 {
+			return c, fmt.Errorf("%s has a negative share", person)
+		}
+		weights[strings.ToLower(person)] = share
+	}
+
+	for _, person := range p.OwedBy {
+		weights[strings.ToLower(person)] = 1
+	}
+
+	var total int64
+	for person, weight := range weights {
+		c.people = append(c.people, person)
+		total += weight
+	}
+	if len(weights) > 0 && total == 0 {
+		return c, fmt.Errorf("every share is zero")
+	}
+
+	sort.Strings(c.people)
+	for _, person := range c.people {
+		c.weights = append(c.weights, weights[person])
+	}
+
+	for person, amount := range p.Fixed {
+		if amount < 0 {
+			return c, fmt.Errorf("%s has a negative fixed amount", person)
+		}
+		c.fixed = append(c.fixed, Share{strings.ToLower(person), money.FromFloat(amount)})
+	}
+	sort.Slice(c.fixed, func(i, j int) bool {
+		return c.fixed[i].Person < c.fixed[j].Person
+	})
+
+	if required && len(c.people) == 0 && len(c.fixed) == 0 {
+		return c, fmt.Errorf("no percent, shares, owed_by or fixed amounts")
+	}
+
+	return c, nil
+}
+
+func (s *Splitter) categoryPolicy(category string) (policy, bool) {
+	path := splitCategory(category)
+
+	for _, c := range s.categories {
+		if len(c.category) > len(path) {
+			continue
+		}
+
+		matches := true
+		for i := range c.category {
+			if c.category[i] != path[i] {
+				matches = false
+				break
+			}
+		}
+
+		if matches {
+			return c.policy, true
+		}
+	}
+
+	return policy{}, false
+}
+
+// Split divides amount, spent from the account with the given nickname.
+// people, usually from a rule, names who shares it; if it's empty, only
+// categories with their own policy are split.  It returns nil if the
+// transaction isn't shared.
+func (s *Splitter) Split(category, account string, people []string, amount money.Amount) (*Split, error) {
+	if s == nil {
+		return nil, nil
+	}
+
+	p, ok := s.categoryPolicy(category)
+	if !ok {
+		if len(people) == 0 {
+			return nil, nil
+		}
+		p = s.def
+	}
+
+	payer := p.paidBy
+	if payer == "" {
+		payer = s.payers[strings.ToLower(account)]
+	}
+	if payer == "" {
+		return nil, fmt.Errorf("no one pays account %q; set splits.payers or paid_by", account)
+	}
+
+	names, weights := p.people, p.weights
+	if len(people) > 0 {
+		names, weights = nil, nil
+		for _, person := range people {
+			person = strings.ToLower(strings.TrimSpace(person))
+			weight := int64(1)
+
+			if len(p.people) > 0 {
+				i := sort.SearchStrings(p.people, person)
+				if i == len(p.people) || p.people[i] != person {
+					return nil, fmt.Errorf("%s has no share in the %s split policy", person, policyName(category, ok))
+				}
+				weight = p.weights[i]
+			}
+
+			names = append(names, person)
+			weights = append(weights, weight)
+		}
+	}
+
+	shares := make(map[string]money.Amount)
+	left := amount
+
+	for _, f := range p.fixed {
+		part := f.Amount
+		if amount < 0 {
+			part = -part
+		}
+		shares[f.Person] += part
+		left -= part
+	}
+
+	if (amount >= 0 && left < 0) || (amount < 0 && left > 0) {
+		return nil, fmt.Errorf("fixed amounts come to more than %s", amount)
+	}
+
+	var total int64
+	for _, w := range weights {
+		total += w
+	}
+
+	if total > 0 {
+		for i, part := range left.Allocate(weights...) {
+			shares[names[i]] += part
+		}
+	} else {
+		shares[payer] += left
+	}
+
+	split := &Split{PaidBy: payer}
+	for person, share := range shares {
+		split.Shares = append(split.Shares, Share{person, share})
+	}
+	sort.Slice(split.Shares, func(i, j int) bool {
+		return split.Shares[i].Person < split.Shares[j].Person
+	})
+
+	return split, nil
+}
+
+func policyName(category string, ok bool) string {
+	if ok {
+		return category
+	}
+	return "default"
+}
+
+// Postings are the changes that record the split in place of a single
+// posting to expense: the payer's own share stays in expense, and each
+// other person's share becomes a Receivable from them.
+func (s *Split) Postings(expense AccountName, commodity string) []Change {
+	var changes []Change
+
+	for _, share := range s.Shares {
+		if share.Person == s.PaidBy && share.Amount != 0 {
+			changes = append(changes, Change{Account: expense, Amount: share.Amount, Commodity: commodity})
+		}
+	}
+
+	for _, share := range s.Shares {
+		if share.Person != s.PaidBy && share.Amount != 0 {
+			changes = append(changes, Change{Account: Receivable(s.PaidBy, share.Person), Amount: share.Amount, Commodity: commodity})
+		}
+	}
+
+	return changes
+}
+
+// Debt is what one person owes another, netted over both directions.
+type Debt struct {
+	From      string       `json:"from"`
+	To        string       `json:"to"`
+	Commodity string       `json:"commodity,omitempty"`
+	Amount    money.Amount `json:"amount"`
+}
+
+// AmountString formats the amount with its commodity.
+func (d Debt) AmountString() string {
+	return formatAmount(d.Amount, d.Commodity)
+}
+
+// Settle works out who owes whom from the Receivable postings f matches.
+// Payments between people should be posted to the same accounts, so they
+// count against what's owed.  Each pair of people is netted to a single
+// debt per commodity.  Debts are sorted by who owes.
+func Settle(transactions []Transaction, f Filter) []Debt {
+	type key struct {
+		from, to, commodity string
+	}
+
+	owed := make(map[key]money.Amount)
+	prefix := Asset("receivable")
+
+	for i := range transactions {
+		t := &transactions[i]
+		if !f.matchesDate(t.Date) {
+			continue
+		}
+
+		for _, c := range t.inferred() {
+			if len(c.Account) != len(prefix)+2 || !c.Account.HasPrefix(prefix) || !f.matchesAccount(c.Account) {
+				continue
+			}
+
+			// Keep one total per pair: what the later name owes the
+			// earlier one.
+			payer, ower := c.Account[len(prefix)], c.Account[len(prefix)+1]
+			switch {
+			case payer == ower:
+				continue
+			case payer > ower:
+				owed[key{payer, ower, c.Commodity}] -= c.Amount
+			default:
+				owed[key{ower, payer, c.Commodity}] += c.Amount
+			}
+		}
+	}
+
+	debts := make([]Debt, 0)
+	for k, amount := range owed {
+		switch {
+		case amount > 0:
+			debts = append(debts, Debt{k.from, k.to, k.commodity, amount})
+		case amount < 0:
+			debts = append(debts, Debt{k.to, k.from, k.commodity, -amount})
+		}
+	}
+
+	sort.Slice(debts, func(i, j int) bool {
+		a, b := debts[i], debts[j]
+		if a.From != b.From {
+			return a.From < b.From
+		}
+		if a.To != b.To {
+			return a.To < b.To
+		}
+		return a.Commodity < b.Commodity
+	})
+
+	return debts
+}
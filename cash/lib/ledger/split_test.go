@@ -0,0 +1,202 @@
+package ledger_test
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pcarleton/cashcoach/api/money"
+	"github.com/pcarleton/cashcoach/cash/lib/ledger"
+)
+
+var splitConfig = ledger.SplitConfig{
+	Payers:  map[string]string{"Visa": "Paul", "amex": "taylor"},
+	Default: ledger.SplitPolicy{Percent: map[string]float64{"paul": 60, "taylor": 40}},
+	Categories: map[string]ledger.SplitPolicy{
+		"food:groceries": {Shares: map[string]int64{"Paul": 2, "taylor": 1}},
+		"Rent":           {Fixed: map[string]float64{"taylor": 800}},
+		"rent:deposit":   {Fixed: map[string]float64{"taylor": 100}, OwedBy: []string{"paul", "taylor"}},
+		"gifts":          {PaidBy: "paul", OwedBy: []string{"taylor"}},
+		"trips":          {Percent: map[string]float64{"paul": 33.33, "taylor": 33.33, "sam": 33.34}},
+	},
+}
+
+// splitString formats s like "paul: paul=20.00 taylor=10.00".
+func splitString(s *ledger.Split) string {
+	if s == nil {
+		return "none"
+	}
+	parts := []string{s.PaidBy + ":"}
+	for _, share := range s.Shares {
+		parts = append(parts, fmt.Sprintf("%s=%s", share.Person, share.Amount))
+	}
+	return strings.Join(parts, " ")
+}
+
+func TestSplit(t *testing.T) {
+	splitter, err := ledger.NewSplitter(splitConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		category string
+		account  string
+		people   []string
+		amount   string
+		want     string
+	}{
+		// Shares, with the odd cent going to the bigger remainder.
+		{"food:groceries", "visa", nil, "30.00", "paul: paul=20.00 taylor=10.00"},
+		{"Food:Groceries:Costco", "AMEX", nil, "10.00", "taylor: paul=6.67 taylor=3.33"},
+		// No policy for the category and no one named by a rule.
+		{"food:restaurants", "visa", nil, "10.00", "none"},
+		// Rules fall back to the default percents.
+		{"food:restaurants", "visa", []string{" Taylor", "paul"}, "10.00", "paul: paul=6.00 taylor=4.00"},
+		{"food:restaurants", "visa", []string{"paul", "taylor"}, "-10.01", "paul: paul=-6.01 taylor=-4.00"},
+		// Fixed amounts with nothing to divide the rest leave it to the
+		// payer, and refunds take them back.
+		{"rent", "visa", nil, "1000.00", "paul: paul=200.00 taylor=800.00"},
+		{"rent", "visa", nil, "-1000.00", "paul: paul=-200.00 taylor=-800.00"},
+		{"rent:deposit", "visa", nil, "300.00", "paul: paul=100.00 taylor=200.00"},
+		// Paid by X, owed by Y, whichever card it was on.
+		{"gifts", "amex", nil, "25.00", "paul: taylor=25.00"},
+		{"gifts", "amex", nil, "-25.00", "paul: taylor=-25.00"},
+		// Percents to the hundredth.
+		{"trips", "visa", nil, "1.00", "paul: paul=0.33 sam=0.34 taylor=0.33"},
+	}
+
+	for _, c := range cases {
+		amount := money.MustParse(c.amount)
+		split, err := splitter.Split(c.category, c.account, c.people, amount)
+		if err != nil {
+			t.Errorf("%s %s: %v", c.category, c.amount, err)
+			continue
+		}
+		if got := splitString(split); got != c.want {
+			t.Errorf("%s %s: got %q, want %q", c.category, c.amount, got, c.want)
+		}
+
+		if split != nil {
+			var total money.Amount
+			for _, share := range split.Shares {
+				total += share.Amount
+			}
+			if total != amount {
+				t.Errorf("%s %s: shares add up to %s", c.category, c.amount, total)
+			}
+		}
+	}
+}
+
+func TestSplitErrors(t *testing.T) {
+	splitter, err := ledger.NewSplitter(splitConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		category string
+		account  string
+		people   []string
+		want     string
+	}{
+		{"food:groceries", "visa", []string{"paul", "Sam"}, "sam has no share in the food:groceries split policy"},
+		{"food:restaurants", "visa", []string{"sam"}, "sam has no share in the default split policy"},
+		{"food:groceries", "checking", nil, `no one pays account "checking"; set splits.payers or paid_by`},
+		{"rent", "visa", nil, "fixed amounts come to more than 500.00"},
+	}
+
+	for _, c := range cases {
+		_, err := splitter.Split(c.category, c.account, c.people, money.MustParse("500.00"))
+		if err == nil || err.Error() != c.want {
+			t.Errorf("%s %v: got %v, want %q", c.category, c.people, err, c.want)
+		}
+	}
+}
+
+func TestNewSplitterErrors(t *testing.T) {
+	cases := []struct {
+		config ledger.SplitConfig
+		want   string
+	}{
+		{ledger.SplitConfig{Default: ledger.SplitPolicy{Percent: map[string]float64{"paul": 60, "taylor": 30}}},
+			"default split: percents add up to 90.00, not 100"},
+		{ledger.SplitConfig{Default: ledger.SplitPolicy{Percent: map[string]float64{"paul": 100}, OwedBy: []string{"taylor"}}},
+			"default split: only one of percent, shares and owed_by can be set"},
+		{ledger.SplitConfig{Categories: map[string]ledger.SplitPolicy{"rent": {Shares: map[string]int64{"paul": -1, "taylor": 2}}}},
+			"split for rent: paul has a negative share"},
+		{ledger.SplitConfig{Categories: map[string]ledger.SplitPolicy{"rent": {Shares: map[string]int64{"paul": 0}}}},
+			"split for rent: every share is zero"},
+		{ledger.SplitConfig{Categories: map[string]ledger.SplitPolicy{"rent": {Fixed: map[string]float64{"paul": -5}}}},
+			"split for rent: paul has a negative fixed amount"},
+		{ledger.SplitConfig{Categories: map[string]ledger.SplitPolicy{"rent": {PaidBy: "paul"}}},
+			"split for rent: no percent, shares, owed_by or fixed amounts"},
+	}
+
+	for _, c := range cases {
+		_, err := ledger.NewSplitter(c.config)
+		if err == nil || err.Error() != c.want {
+			t.Errorf("got %v, want %q", err, c.want)
+		}
+	}
+}
+
+func TestSplitPostings(t *testing.T) {
+	split := &ledger.Split{
+		PaidBy: "paul",
+		Shares: []ledger.Share{{"paul", 2000}, {"sam", 0}, {"taylor", 1000}},
+	}
+
+	got := split.Postings(ledger.Expense("food"), "$")
+	want := []ledger.Change{
+		{Account: ledger.Expense("food"), Amount: 2000, Commodity: "$"},
+		{Account: ledger.Receivable("paul", "taylor"), Amount: 1000, Commodity: "$"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestSettle(t *testing.T) {
+	j := parse(t, `2018/01/01 Groceries
+    expenses:food    $20.00
+    assets:receivable:paul:taylor    $10.00
+    assets:receivable:paul:sam    $5.00
+    liabilities:visa
+
+2018/01/05 Dinner
+    expenses:food    $30.00
+    assets:receivable:taylor:paul    $25.00
+    assets:receivable:taylor:sam    $5.00
+    liabilities:amex
+
+2018/01/10 Paris
+    expenses:travel    10.00 EUR
+    assets:receivable:sam:paul    10.00 EUR
+    liabilities:visa
+
+2018/01/20 Sam pays Paul back
+    assets:receivable:paul:sam    -$5.00
+    assets:checking
+
+2018/02/01 After the period
+    assets:receivable:paul:taylor    $100.00
+    liabilities:visa
+`)
+
+	f := ledger.Filter{End: time.Date(2018, 1, 31, 0, 0, 0, 0, time.UTC)}
+	got := ledger.Settle(j.Transactions, f)
+
+	// Paul and Sam are square, and Paul's and Taylor's debts net out.
+	want := []ledger.Debt{
+		{From: "paul", To: "sam", Commodity: "EUR", Amount: 1000},
+		{From: "paul", To: "taylor", Commodity: "$", Amount: 1500},
+		{From: "sam", To: "taylor", Commodity: "$", Amount: 500},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+}
@@ -0,0 +1,27 @@
+package lib
+
+import (
+	"strings"
+
+	"github.com/spf13/viper"
+
+	"github.com/pcarleton/cashcoach/cash/lib/ledger"
+)
+
+// LedgerAccounts maps account nicknames to ledger account names, from the
+// ledger_accounts config key:
+//
+//	ledger_accounts:
+//	  visa: liabilities:chase:visa
+//	  checking: assets:schwab:checking
+//
+// Config keys are case-insensitive, so the nicknames are lowercased.
+func LedgerAccounts() map[string]ledger.AccountName {
+	accounts := make(map[string]ledger.AccountName)
+
+	for nick, name := range viper.GetStringMapString("ledger_accounts") {
+		accounts[strings.ToLower(nick)] = ledger.AccountName(strings.Split(name, ":"))
+	}
+
+	return accounts
+}
@@ -0,0 +1,29 @@
+package lib
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/spf13/viper"
+
+	"github.com/pcarleton/cashcoach/api/rules"
+)
+
+// GetRules compiles the rules config key.  See package rules for the
+// format; the API server reads the same key.
+func GetRules() (*rules.Engine, error) {
+	list := make([]rules.Rule, 0)
+	if err := viper.UnmarshalKey("rules", &list); err != nil {
+		return nil, fmt.Errorf("unable to read rules: %v", err)
+	}
+
+	return rules.New(list)
+}
+
+func RulesOrDie() *rules.Engine {
+	engine, err := GetRules()
+	if err != nil {
+		log.Fatalf("Invalid rules: %v", err)
+	}
+	return engine
+}
@@ -0,0 +1,29 @@
+package lib
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/spf13/viper"
+
+	"github.com/pcarleton/cashcoach/cash/lib/ledger"
+)
+
+// GetSplitter reads the splits config key.  See ledger.SplitConfig for
+// the format.
+func GetSplitter() (*ledger.Splitter, error) {
+	config := ledger.SplitConfig{}
+	if err := viper.UnmarshalKey("splits", &config); err != nil {
+		return nil, fmt.Errorf("unable to read splits: %v", err)
+	}
+
+	return ledger.NewSplitter(config)
+}
+
+func SplitterOrDie() *ledger.Splitter {
+	splitter, err := GetSplitter()
+	if err != nil {
+		log.Fatalf("Invalid splits: %v", err)
+	}
+	return splitter
+}
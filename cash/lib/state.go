@@ -0,0 +1,80 @@
+package lib
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/spf13/viper"
+)
+
+const stateFileName = ".cashcoach_state.json"
+
+// State is data the CLI writes back between runs, kept apart from the
+// hand-edited config file.
+type State struct {
+	// Cursors maps an Account.Name to its last /transactions/sync cursor.
+	Cursors map[string]string `json:"cursors"`
+
+	path string
+}
+
+func statePath() (string, error) {
+	if path := viper.GetString("state_file"); path != "" {
+		return path, nil
+	}
+
+	home, err := homedir.Dir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(home, stateFileName), nil
+}
+
+// LoadState reads the state file, returning an empty State if it doesn't
+// exist yet.
+func LoadState() (*State, error) {
+	path, err := statePath()
+	if err != nil {
+		return nil, err
+	}
+
+	state := &State{Cursors: make(map[string]string), path: path}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, err
+	}
+
+	if state.Cursors == nil {
+		state.Cursors = make(map[string]string)
+	}
+
+	return state, nil
+}
+
+// Save writes the state file, replacing it atomically so a crash can't
+// leave a half-written cursor behind.
+func (s *State) Save() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, s.path)
+}
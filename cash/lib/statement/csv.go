@@ -0,0 +1,356 @@
+package statement
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pcarleton/cashcoach/api/money"
+)
+
+// Sign conventions for a CSV's amount column.
+const (
+	// DebitsNegative is for amounts that are negative for money leaving
+	// the account, as most banks write them.
+	DebitsNegative = "debits_negative"
+	// DebitsPositive is for amounts that are positive for money leaving
+	// the account, like Plaid's and most credit card statements'.
+	DebitsPositive = "debits_positive"
+)
+
+// CSVProfile says how to read one bank's CSV statements.  Profiles are
+// named under the csv_profiles config key:
+//
+//	csv_profiles:
+//	  chase:
+//	    date_format: 01/02/2006
+//	    account: checking
+//	    columns:
+//	      date: Posting Date
+//	      description: Description
+//	      amount: Amount
+//	  amex:
+//	    delimiter: ";"
+//	    skip_rows: 3
+//	    no_header: true
+//	    columns:
+//	      date: 1
+//	      description: 2
+//	      debit: 4
+//	      credit: 5
+//
+// Columns are named by their header, ignoring case, or numbered from 1.
+type CSVProfile struct {
+	// Delimiter separates fields: "," if empty, or "tab" for tabs.
+	Delimiter string `mapstructure:"delimiter"`
+
+	// SkipRows is how many lines come before the header, or before the
+	// first transaction with NoHeader, like a bank's title and account
+	// summary.
+	SkipRows int `mapstructure:"skip_rows"`
+
+	// NoHeader is set for files without a header row, whose columns have
+	// to be numbered.
+	NoHeader bool `mapstructure:"no_header"`
+
+	// DateFormat is a Go time layout, like 01/02/2006.  It defaults to
+	// 2006-01-02.
+	DateFormat string `mapstructure:"date_format"`
+
+	// Sign is DebitsNegative, the default, or DebitsPositive.  It's only
+	// used for an amount column; debit and credit columns are read
+	// without their signs.
+	Sign string `mapstructure:"sign"`
+
+	// DecimalComma is set for amounts written like 1.234,56.
+	DecimalComma bool `mapstructure:"decimal_comma"`
+
+	// Account is the account nickname for rows without an account
+	// column.
+	Account string `mapstructure:"account"`
+
+	Columns CSVColumns `mapstructure:"columns"`
+}
+
+// CSVColumns maps our fields to a CSV's columns.  There has to be a date
+// column, and either an amount column or debit and credit columns.
+type CSVColumns struct {
+	Date        string `mapstructure:"date"`
+	Description string `mapstructure:"description"`
+	Amount      string `mapstructure:"amount"`
+	// Debit is money leaving the account and Credit money coming in.
+	// Banks that write both in one column should use Amount.
+	Debit    string `mapstructure:"debit"`
+	Credit   string `mapstructure:"credit"`
+	Category string `mapstructure:"category"`
+	Account  string `mapstructure:"account"`
+	// ID is the bank's reference for the transaction, if it has one.
+	// It's used like an OFX FITID.
+	ID string `mapstructure:"id"`
+}
+
+// Validate checks p can be used to read a statement.
+func (p *CSVProfile) Validate() error {
+	if _, err := p.delimiter(); err != nil {
+		return err
+	}
+
+	if p.SkipRows < 0 {
+		return fmt.Errorf("skip_rows can't be negative")
+	}
+
+	if p.Sign != "" && p.Sign != DebitsNegative && p.Sign != DebitsPositive {
+		return fmt.Errorf("unknown sign %q, expected %s or %s", p.Sign, DebitsNegative, DebitsPositive)
+	}
+
+	c := p.Columns
+	if c.Date == "" {
+		return fmt.Errorf("no date column")
+	}
+
+	switch {
+	case c.Amount != "" && (c.Debit != "" || c.Credit != ""):
+		return fmt.Errorf("only one of an amount column and debit and credit columns can be set")
+	case c.Amount == "" && (c.Debit == "" || c.Credit == ""):
+		return fmt.Errorf("no amount column, or debit and credit columns")
+	}
+
+	if p.NoHeader {
+		for _, column := range []string{c.Date, c.Description, c.Amount, c.Debit, c.Credit, c.Category, c.Account, c.ID} {
+			if _, err := strconv.Atoi(column); column != "" && err != nil {
+				return fmt.Errorf("column %q has to be a number without a header row", column)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (p *CSVProfile) delimiter() (rune, error) {
+	switch p.Delimiter {
+	case "":
+		return ',', nil
+	case "tab", `\t`:
+		return '\t', nil
+	}
+
+	r, size := utf8.DecodeRuneInString(p.Delimiter)
+	if size != len(p.Delimiter) || r == '"' || r == '\r' || r == '\n' {
+		return 0, fmt.Errorf("delimiter %q has to be a single character other than a quote or newline", p.Delimiter)
+	}
+	return r, nil
+}
+
+// csvColumns are a profile's columns as indexes into a row, or -1 for
+// ones it doesn't have.
+type csvColumns struct {
+	date, description, amount, debit, credit, category, account, id int
+}
+
+func (p *CSVProfile) resolve(header []string) (csvColumns, error) {
+	names := make(map[string]int)
+	for i, name := range header {
+		// Excel starts UTF-8 files with a byte order mark.
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		if _, ok := names[name]; !ok {
+			names[name] = i
+		}
+	}
+
+	var err error
+	index := func(column string) int {
+		if column == "" || err != nil {
+			return -1
+		}
+		if n, convErr := strconv.Atoi(column); convErr == nil {
+			if n < 1 {
+				err = fmt.Errorf("column %d: columns are numbered from 1", n)
+			}
+			return n - 1
+		}
+		i, ok := names[strings.ToLower(strings.TrimSpace(column))]
+		if !ok {
+			err = fmt.Errorf("no %q column in the header", column)
+		}
+		return i
+	}
+
+	c := p.Columns
+	cols := csvColumns{
+		date:        index(c.Date),
+		description: index(c.Description),
+		amount:      index(c.Amount),
+		debit:       index(c.Debit),
+		credit:      index(c.Credit),
+		category:    index(c.Category),
+		account:     index(c.Account),
+		id:          index(c.ID),
+	}
+	return cols, err
+}
+
+// width is how many fields a row needs to have every column.
+func (c csvColumns) width() int {
+	width := 0
+	for _, i := range []int{c.date, c.description, c.amount, c.debit, c.credit, c.category, c.account, c.id} {
+		if i+1 > width {
+			width = i + 1
+		}
+	}
+	return width
+}
+
+// parseAmount reads amounts the way banks write them, like "$1,234.56"
+// or "(12.50)" for a negative amount.
+func (p *CSVProfile) parseAmount(s string) (money.Amount, error) {
+	s = strings.Replace(strings.TrimSpace(s), "$", "", -1)
+	if s == "" {
+		return 0, fmt.Errorf("missing amount")
+	}
+
+	if p.DecimalComma {
+		s = strings.NewReplacer(".", ",", ",", ".").Replace(s)
+	}
+
+	negative := strings.HasPrefix(s, "(") && strings.HasSuffix(s, ")")
+	if negative {
+		s = s[1 : len(s)-1]
+	}
+
+	amount, err := money.Parse(s)
+	if negative {
+		amount = -amount
+	}
+	return amount, err
+}
+
+// ParseCSV reads a bank's CSV statement with p.  Errors number rows from
+// the top of the file, counting skipped lines and the header.  Blank rows
+// are ignored, but a row missing any of p's columns or its amount is an
+// error rather than being read as 0.00.
+func ParseCSV(r io.Reader, p *CSVProfile) ([]Transaction, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	delimiter, _ := p.delimiter()
+
+	br := bufio.NewReader(r)
+	for i := 0; i < p.SkipRows; i++ {
+		if _, err := br.ReadString('\n'); err != nil {
+			if err == io.EOF {
+				return nil, fmt.Errorf("only %d lines, expected at least %d to skip", i, p.SkipRows)
+			}
+			return nil, err
+		}
+	}
+
+	reader := csv.NewReader(br)
+	reader.Comma = delimiter
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+
+	row := p.SkipRows
+	var header []string
+	if !p.NoHeader {
+		var err error
+		if header, err = reader.Read(); err != nil {
+			if err == io.EOF {
+				return nil, fmt.Errorf("missing header row")
+			}
+			return nil, err
+		}
+		row++
+	}
+
+	cols, err := p.resolve(header)
+	if err != nil {
+		return nil, err
+	}
+
+	layout := p.DateFormat
+	if layout == "" {
+		layout = "2006-01-02"
+	}
+
+	var txns []Transaction
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		row++
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %v", row, err)
+		}
+
+		if strings.TrimSpace(strings.Join(record, "")) == "" {
+			continue
+		}
+
+		if width := cols.width(); len(record) < width {
+			return nil, fmt.Errorf("row %d: expected at least %d columns, got %d", row, width, len(record))
+		}
+
+		field := func(i int) string {
+			if i < 0 {
+				return ""
+			}
+			return strings.TrimSpace(record[i])
+		}
+
+		date, err := time.Parse(layout, field(cols.date))
+		if err != nil {
+			return nil, fmt.Errorf("row %d: invalid date %q, expected a date like %s", row, field(cols.date), layout)
+		}
+
+		var amount money.Amount
+		if cols.amount >= 0 {
+			if amount, err = p.parseAmount(field(cols.amount)); err != nil {
+				return nil, fmt.Errorf("row %d: %v", row, err)
+			}
+			if p.Sign != DebitsPositive {
+				amount = -amount
+			}
+		} else {
+			// Banks leave whichever side doesn't apply empty.
+			debitStr, creditStr := field(cols.debit), field(cols.credit)
+			if debitStr == "" && creditStr == "" {
+				return nil, fmt.Errorf("row %d: no debit or credit amount", row)
+			}
+
+			var debit, credit money.Amount
+			if debitStr != "" {
+				if debit, err = p.parseAmount(debitStr); err != nil {
+					return nil, fmt.Errorf("row %d: %v", row, err)
+				}
+			}
+			if creditStr != "" {
+				if credit, err = p.parseAmount(creditStr); err != nil {
+					return nil, fmt.Errorf("row %d: %v", row, err)
+				}
+			}
+			amount = debit.Abs() - credit.Abs()
+		}
+
+		account := field(cols.account)
+		if account == "" {
+			account = p.Account
+		}
+
+		txns = append(txns, Transaction{
+			ID:          field(cols.id),
+			Account:     account,
+			Date:        date,
+			Description: field(cols.description),
+			Category:    field(cols.category),
+			Amount:      amount,
+		})
+	}
+
+	return txns, nil
+}
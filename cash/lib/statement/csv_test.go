@@ -0,0 +1,71 @@
+package statement_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pcarleton/cashcoach/cash/lib/statement"
+)
+
+var (
+	amountProfile = &statement.CSVProfile{
+		Columns: statement.CSVColumns{Date: "Date", Description: "Description", Amount: "Amount"},
+	}
+	debitCreditProfile = &statement.CSVProfile{
+		Columns: statement.CSVColumns{Date: "Date", Description: "Description", Debit: "Debit", Credit: "Credit"},
+	}
+)
+
+func TestParseCSV(t *testing.T) {
+	txns, err := statement.ParseCSV(strings.NewReader(
+		"Date,Description,Debit,Credit\n"+
+			"2018-03-01,Coffee,$4.50,\n"+
+			"2018-03-02,Refund,,(2.00)\n"+
+			"\n"+
+			"2018-03-03,Card check,0.00,\n"), debitCreditProfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []int64{450, -200, 0}
+	if len(txns) != len(want) {
+		t.Fatalf("read %d transactions, want %d", len(txns), len(want))
+	}
+	for i, txn := range txns {
+		if int64(txn.Amount) != want[i] {
+			t.Errorf("%s: amount %v, want %d cents", txn.Description, txn.Amount, want[i])
+		}
+	}
+}
+
+func TestParseCSVRowErrors(t *testing.T) {
+	cases := []struct {
+		name    string
+		profile *statement.CSVProfile
+		csv     string
+		want    string
+	}{
+		{"empty amount", amountProfile,
+			"Date,Description,Amount\n2018-03-01,Coffee,-4.50\n2018-03-02,Tea,\n",
+			"row 3: missing amount"},
+		{"blank amount", amountProfile,
+			"Date,Description,Amount\n2018-03-02,Tea,  $ \n",
+			"row 2: missing amount"},
+		{"short row", amountProfile,
+			"Date,Description,Amount\n2018-03-02,Tea\n",
+			"row 2: expected at least 3 columns, got 2"},
+		{"no debit or credit", debitCreditProfile,
+			"Date,Description,Debit,Credit\n2018-03-02,Tea,,\n",
+			"row 2: no debit or credit amount"},
+		{"short debit and credit row", debitCreditProfile,
+			"Date,Description,Debit,Credit\n2018-03-02,Tea,4.50\n",
+			"row 2: expected at least 4 columns, got 3"},
+	}
+
+	for _, c := range cases {
+		_, err := statement.ParseCSV(strings.NewReader(c.csv), c.profile)
+		if err == nil || err.Error() != c.want {
+			t.Errorf("%s: got %v, want %q", c.name, err, c.want)
+		}
+	}
+}
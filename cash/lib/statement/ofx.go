@@ -0,0 +1,190 @@
+package statement
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/pcarleton/cashcoach/api/money"
+)
+
+// ofxDateFmt is the part of an OFX date we use.  Dates can go on with a
+// time and zone, like 20170902120000.000[-5:EST], but banks post by day.
+const ofxDateFmt = "20060102"
+
+// ofxCategories gives the categories of the transaction types that say
+// more than which way the money went, so transfers can be paired and pay
+// and interest booked as income.
+var ofxCategories = map[string]string{
+	"XFER":      "Transfer",
+	"PAYMENT":   "Payment",
+	"INT":       "Interest",
+	"DIV":       "Income:Dividends",
+	"DIRECTDEP": "Payroll",
+}
+
+var ofxEntities = strings.NewReplacer(
+	"&lt;", "<",
+	"&gt;", ">",
+	"&quot;", `"`,
+	"&apos;", "'",
+	"&nbsp;", " ",
+	"&amp;", "&",
+)
+
+// ofxTransaction collects a STMTTRN's fields until it's closed.
+type ofxTransaction struct {
+	kind, posted, amount, id, name, memo string
+}
+
+// ParseOFX reads the transactions from every bank and credit card
+// statement in an OFX or QFX file.  Both the SGML of OFX 1.x, where
+// values don't have closing tags, and the XML of OFX 2.x are read.
+func ParseOFX(r io.Reader) ([]Transaction, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Skip the header, which is "KEY:VALUE" lines in 1.x and XML
+	// processing instructions in 2.x.
+	s := string(data)
+	start := strings.Index(strings.ToUpper(s), "<OFX>")
+	if start < 0 {
+		return nil, fmt.Errorf("not an OFX file: no <OFX> element")
+	}
+	s = s[start:]
+
+	var (
+		txns    []Transaction
+		account string
+		inFrom  bool
+		current *ofxTransaction
+	)
+
+	for len(s) > 0 {
+		open := strings.IndexByte(s, '<')
+		if open < 0 {
+			break
+		}
+		end := strings.IndexByte(s[open:], '>')
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated tag %q", s[open:])
+		}
+		end += open
+
+		tag := strings.ToUpper(strings.TrimSpace(s[open+1 : end]))
+		s = s[end+1:]
+
+		next := strings.IndexByte(s, '<')
+		if next < 0 {
+			next = len(s)
+		}
+		value := ofxEntities.Replace(strings.TrimSpace(s[:next]))
+
+		switch {
+		case strings.HasPrefix(tag, "?") || strings.HasPrefix(tag, "!"):
+			continue
+
+		case tag == "BANKACCTFROM" || tag == "CCACCTFROM":
+			inFrom = true
+		case tag == "/BANKACCTFROM" || tag == "/CCACCTFROM":
+			inFrom = false
+		case tag == "ACCTID" && inFrom:
+			account = value
+
+		case tag == "STMTTRN":
+			current = &ofxTransaction{}
+		case tag == "/STMTTRN":
+			if current == nil {
+				return nil, fmt.Errorf("</STMTTRN> without <STMTTRN>")
+			}
+			t, err := current.transaction(account)
+			if err != nil {
+				return nil, err
+			}
+			txns = append(txns, t)
+			current = nil
+
+		case current != nil:
+			current.set(tag, value)
+		}
+	}
+
+	if current != nil {
+		return nil, fmt.Errorf("transaction %s isn't closed", current.id)
+	}
+
+	return txns, nil
+}
+
+// set records one of a STMTTRN's values.  Aggregates inside it, like
+// PAYEE, and closing tags have no value of their own and are ignored.
+func (o *ofxTransaction) set(tag, value string) {
+	if value == "" {
+		return
+	}
+
+	switch tag {
+	case "TRNTYPE":
+		o.kind = strings.ToUpper(value)
+	case "DTPOSTED":
+		o.posted = value
+	case "TRNAMT":
+		o.amount = value
+	case "FITID":
+		o.id = value
+	case "NAME":
+		if o.name == "" {
+			o.name = value
+		}
+	case "MEMO":
+		o.memo = value
+	}
+}
+
+func (o *ofxTransaction) transaction(account string) (Transaction, error) {
+	if o.id == "" {
+		return Transaction{}, fmt.Errorf("transaction %q has no FITID", o.name)
+	}
+
+	if len(o.posted) < len(ofxDateFmt) {
+		return Transaction{}, fmt.Errorf("transaction %s has invalid date %q", o.id, o.posted)
+	}
+	date, err := time.Parse(ofxDateFmt, o.posted[:len(ofxDateFmt)])
+	if err != nil {
+		return Transaction{}, fmt.Errorf("transaction %s has invalid date %q", o.id, o.posted)
+	}
+
+	trnamt, err := ofxAmount(o.amount)
+	if err != nil {
+		return Transaction{}, fmt.Errorf("transaction %s: %v", o.id, err)
+	}
+
+	description := o.name
+	if description == "" {
+		description = o.memo
+	}
+
+	return Transaction{
+		ID:          o.id,
+		Account:     account,
+		Date:        date,
+		Description: description,
+		Category:    ofxCategories[o.kind],
+		// TRNAMT is negative for money leaving the account.
+		Amount: -trnamt,
+	}, nil
+}
+
+// ofxAmount reads a TRNAMT.  Some banks write a decimal comma, like -12,50
+// or 1.234,50, but a comma with three digits after it is a thousands
+// separator, like 1,234.
+func ofxAmount(s string) (money.Amount, error) {
+	if i := strings.LastIndexByte(s, ','); i >= 0 && len(s)-i-1 <= 2 && !strings.Contains(s[i:], ".") {
+		return money.Parse(strings.Replace(s[:i], ".", "", -1) + "." + s[i+1:])
+	}
+	return money.Parse(s)
+}
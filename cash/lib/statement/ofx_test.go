@@ -0,0 +1,57 @@
+package statement_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pcarleton/cashcoach/cash/lib/statement"
+)
+
+func TestParseOFX(t *testing.T) {
+	got := lines(readFixture(t, "bank.ofx", parseOFX))
+	want := []string{
+		// 1,234 has a thousands separator, and the others a decimal comma.
+		"1111 1001 2018-03-02 Rent & Fees [] 1234.00",
+		"1111 1002 2018-03-05 ACME PAYROLL [Payroll] -2500.00",
+		"1111 1003 2018-03-06 To savings [Transfer] 12.50",
+		"1111 1004 2018-03-07 Euro shop [] 1234.50",
+		"9999 1001 2018-03-03 Coffee [] 4.50",
+		"9999 1002 2018-03-08 Payment thank you [Payment] -12.50",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestParseOFXXML(t *testing.T) {
+	got := lines(readFixture(t, "overlap.ofx", parseOFX))
+	want := []string{
+		"9999 1001 2018-03-03 Coffee [] 4.50",
+		"9999 1003 2018-03-10 Flights <round trip> [] 1020.00",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestParseOFXErrors(t *testing.T) {
+	cases := []struct {
+		ofx  string
+		want string
+	}{
+		{"OFXHEADER:100\n", "not an OFX file: no <OFX> element"},
+		{"<OFX><STMTTRN><DTPOSTED>20180302<TRNAMT>1<NAME>Coffee</STMTTRN>", `transaction "Coffee" has no FITID`},
+		{"<OFX><STMTTRN><DTPOSTED>2018<TRNAMT>1<FITID>1</STMTTRN>", `transaction 1 has invalid date "2018"`},
+		{"<OFX><STMTTRN><DTPOSTED>20180302<TRNAMT>abc<FITID>1</STMTTRN>", `transaction 1: invalid amount "abc"`},
+		{"<OFX><STMTTRN><DTPOSTED>20180302<TRNAMT>1.005<FITID>1</STMTTRN>", `transaction 1: amount "1.005" has fractions of a cent`},
+		{"<OFX><STMTTRN><FITID>1", "transaction 1 isn't closed"},
+	}
+
+	for _, c := range cases {
+		_, err := statement.ParseOFX(strings.NewReader(c.ofx))
+		if err == nil || err.Error() != c.want {
+			t.Errorf("%q: got %v, want %q", c.ofx, err, c.want)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package statement
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/pcarleton/cashcoach/api/money"
+)
+
+// qifDateFmts are the US date layouts Quicken and banks write, after "'"
+// (as in 9/2'17) is turned into "/" and spaces are dropped.
+var qifDateFmts = []string{
+	"1/2/2006",
+	"1/2/06",
+	"1-2-2006",
+	"1-2-06",
+	"1.2.2006",
+	"2006-01-02",
+}
+
+// qifTypes are the account types whose records are transactions.  Other
+// sections, like category and class lists, are skipped.
+var qifTypes = map[string]bool{
+	"bank":  true,
+	"cash":  true,
+	"ccard": true,
+	"oth a": true,
+	"oth l": true,
+}
+
+func parseQIFDate(s string) (time.Time, error) {
+	date := strings.TrimSpace(s)
+
+	// Quicken writes years from 2000 on after a "'", padding them with a
+	// space rather than a zero, like 12/25' 5.
+	if i := strings.IndexByte(date, '\''); i >= 0 {
+		year := strings.TrimSpace(date[i+1:])
+		if len(year) == 1 {
+			year = "0" + year
+		}
+		date = date[:i] + "/" + year
+	}
+	date = strings.Replace(date, " ", "", -1)
+
+	for _, layout := range qifDateFmts {
+		if t, err := time.Parse(layout, date); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q", s)
+}
+
+// qifCategory turns an L field into a category.  "[Savings]" is a transfer
+// to the Savings account, and "/class" on the end is a class, not part of
+// the category.
+func qifCategory(l string) string {
+	if strings.HasPrefix(l, "[") && strings.HasSuffix(l, "]") {
+		return "Transfer"
+	}
+	if i := strings.IndexByte(l, '/'); i >= 0 {
+		l = l[:i]
+	}
+	if l == "--Splits--" {
+		return ""
+	}
+	return strings.TrimSpace(l)
+}
+
+// ParseQIF reads the transactions from the bank, cash and credit card
+// sections of a QIF file.  Transactions take the name from the !Account
+// block before them, if there is one.  The list of accounts between
+// !Option:AutoSwitch and !Clear:AutoSwitch names no account.  Split lines
+// are ignored, so a split transaction has only its main category.
+func ParseQIF(r io.Reader) ([]Transaction, error) {
+	scanner := bufio.NewScanner(r)
+
+	var (
+		txns      []Transaction
+		account   string
+		inAccount bool
+		// autoSwitch is set in an account list, where every record is
+		// an account rather than just the first.
+		autoSwitch bool
+		skip       bool
+		t          Transaction
+		hasDate    bool
+		hasAmount  bool
+		start      int
+	)
+
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "!") {
+			header := strings.ToLower(strings.TrimSpace(line))
+			switch {
+			case header == "!account":
+				inAccount, skip = true, false
+			case header == "!option:autoswitch":
+				autoSwitch = true
+			case header == "!clear:autoswitch":
+				inAccount, autoSwitch = false, false
+			case strings.HasPrefix(header, "!type:"):
+				kind := strings.TrimSpace(strings.TrimPrefix(header, "!type:"))
+				if kind == "invst" {
+					return nil, fmt.Errorf("line %d: investment accounts aren't supported", lineNo)
+				}
+				inAccount, skip = false, !qifTypes[kind]
+			}
+			// Other options don't matter to us.
+			continue
+		}
+
+		code, value := line[0], strings.TrimSpace(line[1:])
+
+		if code == '^' {
+			switch {
+			case inAccount:
+				inAccount = autoSwitch
+			case skip:
+			case !hasDate || !hasAmount:
+				return nil, fmt.Errorf("line %d: transaction needs a date and an amount", start)
+			default:
+				t.Account = account
+				txns = append(txns, t)
+			}
+			t, hasDate, hasAmount, start = Transaction{}, false, false, 0
+			continue
+		}
+
+		if start == 0 {
+			start = lineNo
+		}
+
+		if inAccount {
+			if code == 'N' && !autoSwitch {
+				account = value
+			}
+			continue
+		}
+		if skip {
+			continue
+		}
+
+		switch code {
+		case 'D':
+			date, err := parseQIFDate(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", lineNo, err)
+			}
+			t.Date, hasDate = date, true
+		case 'T', 'U':
+			amount, err := money.Parse(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", lineNo, err)
+			}
+			// QIF amounts are negative for money leaving the account.
+			t.Amount, hasAmount = -amount, true
+		case 'P':
+			t.Description = value
+		case 'M':
+			if t.Description == "" {
+				t.Description = value
+			}
+		case 'L':
+			t.Category = qifCategory(value)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if hasDate || hasAmount {
+		return nil, fmt.Errorf("line %d: transaction isn't ended with ^", start)
+	}
+
+	return txns, nil
+}
@@ -0,0 +1,68 @@
+package statement_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pcarleton/cashcoach/cash/lib/statement"
+)
+
+func TestParseQIF(t *testing.T) {
+	// The account list doesn't name the transactions' account, and the
+	// category list is skipped.
+	got := lines(readFixture(t, "quicken.qif", parseQIF))
+	want := []string{
+		"Checking  2005-12-25 Holiday Gifts [Gifts] 1234.56",
+		"Checking  2017-01-02 Paycheck [Payroll] -2000.00",
+		"Checking  2017-09-02 Visa payment [Transfer] 500.00",
+		"Visa  2017-09-04 Payment thank you [Transfer] -500.00",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestParseQIFDates(t *testing.T) {
+	dates := map[string]string{
+		"9/2'17":     "2017-09-02",
+		"12/25' 5":   "2005-12-25",
+		" 1/ 2' 0":   "2000-01-02",
+		"09/02/2017": "2017-09-02",
+		"9/2/17":     "2017-09-02",
+		"9-2-2017":   "2017-09-02",
+		"9.2.2017":   "2017-09-02",
+		"2017-09-02": "2017-09-02",
+	}
+
+	for in, want := range dates {
+		txns, err := statement.ParseQIF(strings.NewReader("!Type:Bank\nD" + in + "\nT1.00\n^\n"))
+		if err != nil {
+			t.Errorf("%q: %v", in, err)
+			continue
+		}
+		if got := txns[0].Date.Format("2006-01-02"); got != want {
+			t.Errorf("%q: got %s, want %s", in, got, want)
+		}
+	}
+}
+
+func TestParseQIFErrors(t *testing.T) {
+	cases := []struct {
+		qif  string
+		want string
+	}{
+		{"!Type:Bank\nD13/45/2017\nT1.00\n^\n", `line 2: invalid date "13/45/2017"`},
+		{"!Type:Bank\nD9/2/2017\nTabc\n^\n", `line 3: invalid amount "abc"`},
+		{"!Type:Bank\nPCoffee\nD9/2/2017\n^\n", "line 2: transaction needs a date and an amount"},
+		{"!Type:Bank\nD9/2/2017\nT1.00\n", "line 2: transaction isn't ended with ^"},
+		{"!Type:Invst\nD9/2/2017\n^\n", "line 1: investment accounts aren't supported"},
+	}
+
+	for _, c := range cases {
+		_, err := statement.ParseQIF(strings.NewReader(c.qif))
+		if err == nil || err.Error() != c.want {
+			t.Errorf("%q: got %v, want %q", c.qif, err, c.want)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+// Package statement reads bank statements downloaded by hand, for banks
+// Plaid can't reach: OFX (and Quicken's QFX, which is the same format),
+// QIF, and CSVs laid out as a CSVProfile says.
+package statement
+
+import (
+	"time"
+
+	"github.com/pcarleton/cashcoach/api/money"
+)
+
+// Transaction is one line of a statement, normalized to look like what
+// Plaid reports.
+type Transaction struct {
+	// ID is the OFX FITID, which banks only promise is unique within an
+	// account, or a CSV's reference column.  QIF has no IDs, so it's
+	// empty for QIF.
+	ID string
+	// Account is the statement's account ID, the account name in a QIF
+	// file, or a CSV's account column or profile account, if there is one.
+	Account     string
+	Date        time.Time
+	Description string
+	// Category is a colon-separated path like Food:Groceries, if the
+	// statement has one.
+	Category string
+	// Amount is positive for money leaving the account, like Plaid's.
+	Amount money.Amount
+}
+
+// Dedup drops transactions whose ID was already seen in the same account,
+// keeping the first.  Banks reuse a FITID when the same transaction shows
+// up in overlapping downloads.  Transactions without an ID are kept.
+func Dedup(txns []Transaction) []Transaction {
+	type key struct {
+		account, id string
+	}
+	seen := make(map[key]bool)
+
+	deduped := make([]Transaction, 0, len(txns))
+	for _, t := range txns {
+		if t.ID != "" {
+			k := key{t.Account, t.ID}
+			if seen[k] {
+				continue
+			}
+			seen[k] = true
+		}
+		deduped = append(deduped, t)
+	}
+	return deduped
+}
@@ -0,0 +1,65 @@
+package statement_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/pcarleton/cashcoach/cash/lib/statement"
+)
+
+// readFixture parses testdata/name with parse.
+func readFixture(t *testing.T, name string, parse func(*os.File) ([]statement.Transaction, error)) []statement.Transaction {
+	t.Helper()
+	f, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	txns, err := parse(f)
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	return txns
+}
+
+func parseOFX(f *os.File) ([]statement.Transaction, error) { return statement.ParseOFX(f) }
+
+func parseQIF(f *os.File) ([]statement.Transaction, error) { return statement.ParseQIF(f) }
+
+// lines formats txns like "1111 1001 2018-03-02 Rent & Fees [] 1234.00".
+func lines(txns []statement.Transaction) []string {
+	formatted := make([]string, len(txns))
+	for i, t := range txns {
+		formatted[i] = fmt.Sprintf("%s %s %s %s [%s] %s",
+			t.Account, t.ID, t.Date.Format("2006-01-02"), t.Description, t.Category, t.Amount)
+	}
+	return formatted
+}
+
+func TestDedup(t *testing.T) {
+	txns := append(readFixture(t, "bank.ofx", parseOFX), readFixture(t, "overlap.ofx", parseOFX)...)
+	txns = append(txns, statement.Transaction{Account: "9999", Description: "No ID"},
+		statement.Transaction{Account: "9999", Description: "No ID"})
+
+	// The card's 1001 was in both downloads.  The checking account's 1001
+	// is a different transaction.
+	got := lines(statement.Dedup(txns))
+	want := []string{
+		"1111 1001 2018-03-02 Rent & Fees [] 1234.00",
+		"1111 1002 2018-03-05 ACME PAYROLL [Payroll] -2500.00",
+		"1111 1003 2018-03-06 To savings [Transfer] 12.50",
+		"1111 1004 2018-03-07 Euro shop [] 1234.50",
+		"9999 1001 2018-03-03 Coffee [] 4.50",
+		"9999 1002 2018-03-08 Payment thank you [Payment] -12.50",
+		"9999 1003 2018-03-10 Flights <round trip> [] 1020.00",
+		"9999  0001-01-01 No ID [] 0.00",
+		"9999  0001-01-01 No ID [] 0.00",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got\n%q\nwant\n%q", got, want)
+	}
+}
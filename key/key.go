@@ -1,13 +1,60 @@
 package main
 
 import (
-	"github.com/gorilla/securecookie"
-	"fmt"
 	"encoding/hex"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/gorilla/securecookie"
+
+	"github.com/pcarleton/cashcoach/api/storage"
 )
 
+var (
+	keyringPath = flag.String("keyring", "", "keyring file for encrypting access tokens")
+	rotate      = flag.Bool("rotate", false, "add a new current key to -keyring, keeping the old ones for reading")
+)
 
+// With no flags, prints a random key suitable for cookie_secret.  With
+// -keyring, creates the keyring file if it doesn't exist, or with -rotate
+// adds a new key to it.  Restart the API server after rotating; it
+// re-encrypts stored tokens with the new key in the background.
 func main() {
-	key := securecookie.GenerateRandomKey(32)
-	fmt.Println(hex.EncodeToString(key))
+	flag.Parse()
+
+	if *keyringPath == "" {
+		if *rotate {
+			log.Fatal("-rotate requires -keyring")
+		}
+
+		key := securecookie.GenerateRandomKey(32)
+		fmt.Println(hex.EncodeToString(key))
+		return
+	}
+
+	keyring, err := storage.LoadKeyring(*keyringPath)
+
+	switch {
+	case os.IsNotExist(err):
+		if keyring, err = storage.NewKeyring(); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Created keyring with key %s\n", keyring.CurrentKeyID())
+	case err != nil:
+		log.Fatal(err)
+	case *rotate:
+		id, err := keyring.Rotate()
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Rotated to key %s; keeping %v for reading\n", id, keyring.IDs())
+	default:
+		log.Fatalf("%s already exists; pass -rotate to add a new key", *keyringPath)
+	}
+
+	if err := keyring.Save(*keyringPath); err != nil {
+		log.Fatal(err)
+	}
 }